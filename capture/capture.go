@@ -0,0 +1,251 @@
+// Package capture writes traffic of network connections to pcap files that
+// can be opened with tools like Wireshark or tcpdump.
+//
+// As only payloads are visible to the wrappers in this package, Ethernet, IP
+// and TCP or UDP headers are synthesized from the connection addresses. TCP
+// sequence numbers are tracked per connection, but there are no handshakes,
+// acknowledgement-only segments or retransmissions. Payloads larger than
+// MaxPayload are split into multiple packets.
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSnapLen is the default maximum number of bytes captured per
+	// packet.
+	DefaultSnapLen = 65535
+
+	// MaxPayload is the maximum payload size of a captured packet.
+	MaxPayload = 65000
+
+	// Synthetic ports (see Wrap) are taken from the dynamic port range.
+	firstSyntheticPort = 49152
+
+	pcapHeaderSize       = 24
+	pcapRecordHeaderSize = 16
+	linkTypeEthernet     = 1
+)
+
+// Config is the configuration for capture files (see Create).
+type Config struct {
+	// Path of the capture file.
+	Path string
+
+	// MaxFileSize is the size (in bytes) after which the capture file is
+	// rotated (renamed to Path.1, with older files renamed to Path.2 and so
+	// on). If 0, files are never rotated.
+	MaxFileSize int64
+
+	// MaxFiles is the maximum number of rotated files kept. Older ones are
+	// deleted. If 0, rotated files are deleted immediately.
+	MaxFiles int
+
+	// SnapLen is the maximum number of bytes captured per packet. Defaults to
+	// DefaultSnapLen.
+	SnapLen int
+}
+
+// Writer writes captured packets in pcap format. It is safe for concurrent
+// use.
+type Writer struct {
+	config Config
+
+	m    sync.Mutex
+	out  io.Writer
+	file *os.File // nil if not writing to files.
+	size int64    // Bytes written to the current file.
+	ipID uint16
+	port int // Synthetic ports handed out so far.
+	err  error
+}
+
+// NewWriter creates a new Writer that writes a pcap capture to the given
+// io.Writer, capturing up to snapLen bytes per packet (DefaultSnapLen if 0).
+func NewWriter(out io.Writer, snapLen int) (*Writer, error) {
+	if snapLen <= 0 {
+		snapLen = DefaultSnapLen
+	}
+
+	w := &Writer{
+		config: Config{SnapLen: snapLen},
+		out:    out,
+	}
+
+	err := w.writeHeaderLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Create creates (or truncates) the capture file described by the given
+// config and returns a Writer that writes to it.
+func Create(config Config) (*Writer, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("path cannot be empty")
+	}
+
+	if config.SnapLen <= 0 {
+		config.SnapLen = DefaultSnapLen
+	}
+
+	w := &Writer{
+		config: config,
+	}
+
+	err := w.openLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Close closes the capture file (if any). It does not close io.Writers
+// passed to NewWriter.
+func (w *Writer) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	if w.err == nil {
+		w.err = os.ErrClosed
+	}
+
+	return err
+}
+
+// Err returns the first error that happened while writing the capture, if
+// any.
+func (w *Writer) Err() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.err
+}
+
+// writeFrame writes a packet record with the given Ethernet frame. After the
+// first error, all calls fail with it.
+func (w *Writer) writeFrame(timestamp time.Time, frame []byte) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	captured := frame
+	if len(captured) > w.config.SnapLen {
+		captured = captured[:w.config.SnapLen]
+	}
+
+	record := make([]byte, pcapRecordHeaderSize+len(captured))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(timestamp.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8],
+		uint32(timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(captured)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(frame)))
+	copy(record[pcapRecordHeaderSize:], captured)
+
+	if w.file != nil && w.config.MaxFileSize > 0 &&
+		w.size > pcapHeaderSize &&
+		w.size+int64(len(record)) > w.config.MaxFileSize {
+		w.err = w.rotateLocked()
+		if w.err != nil {
+			return w.err
+		}
+	}
+
+	_, w.err = w.out.Write(record)
+	w.size += int64(len(record))
+
+	return w.err
+}
+
+// nextIPID returns the identification for the next IPv4 packet.
+func (w *Writer) nextIPID() uint16 {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.ipID++
+
+	return w.ipID
+}
+
+// nextPort returns a synthetic port for an endpoint without one.
+func (w *Writer) nextPort() int {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	port := firstSyntheticPort + w.port%(65536-firstSyntheticPort)
+	w.port++
+
+	return port
+}
+
+func (w *Writer) writeHeaderLocked() error {
+	header := make([]byte, pcapHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], uint32(w.config.SnapLen))
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeEthernet)
+
+	_, err := w.out.Write(header)
+	w.size = pcapHeaderSize
+
+	return err
+}
+
+func (w *Writer) openLocked() error {
+	file, err := os.Create(w.config.Path)
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.out = file
+
+	return w.writeHeaderLocked()
+}
+
+// rotateLocked renames the current capture file (and older ones) and opens a
+// new one.
+func (w *Writer) rotateLocked() error {
+	err := w.file.Close()
+	if err != nil {
+		return err
+	}
+
+	path := w.config.Path
+	if w.config.MaxFiles <= 0 {
+		os.Remove(path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", path, w.config.MaxFiles))
+		for i := w.config.MaxFiles - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", path, i),
+				fmt.Sprintf("%s.%d", path, i+1))
+		}
+
+		err = os.Rename(path, path+".1")
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.openLocked()
+}
@@ -0,0 +1,187 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// packet is a parsed captured packet.
+type packet struct {
+	source      string
+	destination string
+	ipv6        bool
+	protocol    byte
+	seq         uint32
+	flags       byte
+	payload     []byte
+}
+
+// parse parses the given pcap capture, checking headers and checksums.
+func parse(t *testing.T, data []byte) []packet {
+	t.Helper()
+
+	if len(data) < pcapHeaderSize ||
+		binary.LittleEndian.Uint32(data) != 0xa1b2c3d4 {
+		t.Fatalf("expected pcap header, got %x", data)
+	}
+
+	var packets []packet
+	data = data[pcapHeaderSize:]
+	for len(data) > 0 {
+		length := binary.LittleEndian.Uint32(data[8:12])
+		frame := data[pcapRecordHeaderSize : pcapRecordHeaderSize+length]
+		data = data[pcapRecordHeaderSize+length:]
+
+		var p packet
+		var ip []byte
+		var pseudoHeader []byte
+		var segment []byte
+		switch binary.BigEndian.Uint16(frame[12:14]) {
+		case 0x0800:
+			ip = frame[14:34]
+			if checksum(ip) != 0 {
+				t.Errorf("invalid IPv4 checksum")
+			}
+
+			p.protocol = ip[9]
+			segment = frame[34:]
+			pseudoHeader = append(append([]byte(nil), ip[12:20]...), 0,
+				p.protocol, byte(len(segment)>>8), byte(len(segment)))
+			p.source = net.IP(ip[12:16]).String()
+			p.destination = net.IP(ip[16:20]).String()
+		case 0x86dd:
+			p.ipv6 = true
+			ip = frame[14:54]
+			p.protocol = ip[6]
+			segment = frame[54:]
+			pseudoHeader = append(append([]byte(nil), ip[8:40]...), 0, 0,
+				byte(len(segment)>>8), byte(len(segment)), 0, 0, 0,
+				p.protocol)
+			p.source = net.IP(ip[8:24]).String()
+			p.destination = net.IP(ip[24:40]).String()
+		default:
+			t.Fatalf("expected IP packet, got %x", frame)
+		}
+
+		if checksum(append(pseudoHeader, segment...)) != 0 {
+			t.Errorf("invalid transport checksum")
+		}
+
+		p.source = net.JoinHostPort(p.source,
+			strconv.Itoa(int(binary.BigEndian.Uint16(segment[0:2]))))
+		p.destination = net.JoinHostPort(p.destination,
+			strconv.Itoa(int(binary.BigEndian.Uint16(segment[2:4]))))
+
+		if p.protocol == protocolTCP {
+			p.seq = binary.BigEndian.Uint32(segment[4:8])
+			p.flags = segment[13]
+			p.payload = segment[20:]
+		} else {
+			p.payload = segment[8:]
+		}
+
+		packets = append(packets, p)
+	}
+
+	return packets
+}
+
+func TestWriter_WriteUDP(t *testing.T) {
+	var buffer bytes.Buffer
+	w, err := NewWriter(&buffer, 0)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	source := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+	destination := &net.UDPAddr{IP: net.ParseIP("::1"), Port: 53}
+
+	w.WriteUDP(source, source, []byte("hello"))
+	w.WriteUDP(source, destination, []byte("world"))
+	w.WriteUDP(source, source, make([]byte, MaxPayload+1))
+
+	packets := parse(t, buffer.Bytes())
+	if len(packets) != 4 {
+		t.Fatalf("expected 4 packets, got %d", len(packets))
+	}
+
+	if packets[0].source != "10.0.0.1:1234" ||
+		string(packets[0].payload) != "hello" {
+		t.Errorf("expected %q from %q, got %q from %q", "hello",
+			"10.0.0.1:1234", packets[0].payload, packets[0].source)
+	}
+
+	// Mixed IPv4 and IPv6 addresses use IPv6.
+	if !packets[1].ipv6 || packets[1].destination != "[::1]:53" {
+		t.Errorf("expected IPv6 packet to [::1]:53, got %q (IPv6: %v)",
+			packets[1].destination, packets[1].ipv6)
+	}
+
+	if len(packets[2].payload) != MaxPayload || len(packets[3].payload) != 1 {
+		t.Errorf("expected %d and 1 bytes, got %d and %d", MaxPayload,
+			len(packets[2].payload), len(packets[3].payload))
+	}
+
+	// Packets are truncated to the snap length.
+	buffer.Reset()
+	w, _ = NewWriter(&buffer, 50)
+	w.WriteUDP(source, source, make([]byte, 100))
+
+	record := buffer.Bytes()[pcapHeaderSize:]
+	if binary.LittleEndian.Uint32(record[8:12]) != 50 ||
+		binary.LittleEndian.Uint32(record[12:16]) != 142 {
+		t.Errorf("expected 50 of 142 bytes, got %d of %d",
+			binary.LittleEndian.Uint32(record[8:12]),
+			binary.LittleEndian.Uint32(record[12:16]))
+	}
+}
+
+func TestCreate_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+
+	// Room for 2 packets (62 bytes each) per file.
+	w, err := Create(Config{Path: path, MaxFileSize: pcapHeaderSize + 2*62,
+		MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+	for i := 0; i < 7; i++ {
+		w.WriteUDP(addr, addr, []byte{byte(i)})
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// Files have packets [6], [4 5] and [2 3]. [0 1] was deleted.
+	for suffix, expected := range map[string][]byte{"": {6}, ".1": {4, 5},
+		".2": {2, 3}} {
+		data, err := os.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		var payloads []byte
+		for _, p := range parse(t, data) {
+			payloads = append(payloads, p.payload...)
+		}
+
+		if !bytes.Equal(payloads, expected) {
+			t.Errorf("expected %v in %q, got %v", expected, path+suffix,
+				payloads)
+		}
+	}
+
+	_, err = os.Stat(path + ".3")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
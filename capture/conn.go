@@ -0,0 +1,171 @@
+package capture
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Conn is a net.Conn wrapper that captures all data read and written to a
+// Writer. Connections on packet networks ("udp*" and "unixgram") are
+// captured as UDP datagrams and all others as TCP segments. Capture errors do
+// not affect the connection (see Writer.Err).
+type Conn struct {
+	net.Conn
+
+	w      *Writer
+	local  endpoint
+	remote endpoint
+	udp    bool
+
+	m         sync.Mutex
+	localSeq  uint32 // Next sequence number sent by the local side.
+	remoteSeq uint32 // Next sequence number sent by the remote side.
+	localFIN  bool
+	remoteFIN bool
+}
+
+// Wrap returns a Conn that captures the traffic of the given conn to the
+// given Writer. Addresses without an IP and port (for example, Unix socket
+// addresses) are captured as 127.0.0.1 (local) and 127.0.0.2 (remote) with a
+// port that is unique among the connections wrapped with the same Writer, so
+// each connection is a separate flow.
+func Wrap(conn net.Conn, w *Writer) *Conn {
+	udp := false
+	if addr := conn.LocalAddr(); addr != nil {
+		udp = strings.HasPrefix(addr.Network(), "udp") ||
+			addr.Network() == "unixgram"
+	}
+
+	local := endpointOf(conn.LocalAddr(), net.IPv4(127, 0, 0, 1))
+	if local.port == 0 {
+		local.port = w.nextPort()
+	}
+
+	remote := endpointOf(conn.RemoteAddr(), net.IPv4(127, 0, 0, 2))
+	if remote.port == 0 {
+		remote.port = w.nextPort()
+	}
+
+	return &Conn{
+		Conn:      conn,
+		w:         w,
+		local:     local,
+		remote:    remote,
+		udp:       udp,
+		localSeq:  1,
+		remoteSeq: 1,
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 || (c.udp && err == nil) {
+		c.capture(false, 0, b[:n])
+	}
+
+	if err == io.EOF && !c.udp {
+		c.capture(false, tcpFlagFIN, nil)
+	}
+
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 || (c.udp && err == nil) {
+		c.capture(true, 0, b[:n])
+	}
+
+	return n, err
+}
+
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	if err == nil && !c.udp {
+		c.capture(true, tcpFlagFIN, nil)
+	}
+
+	return err
+}
+
+// capture writes a packet with the given payload sent by the local side (if
+// outgoing is true) or by the remote side. For TCP, the given flags are
+// added to PSH|ACK (for payloads) or ACK and each FIN is only captured once.
+func (c *Conn) capture(outgoing bool, flags byte, payload []byte) {
+	source, destination := c.remote, c.local
+	if outgoing {
+		source, destination = c.local, c.remote
+	}
+
+	if c.udp {
+		c.w.writeUDP(source, destination, payload)
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	seq, ack := &c.remoteSeq, c.localSeq
+	fin := &c.remoteFIN
+	if outgoing {
+		seq, ack = &c.localSeq, c.remoteSeq
+		fin = &c.localFIN
+	}
+
+	if flags&tcpFlagFIN != 0 {
+		if *fin {
+			return
+		}
+
+		*fin = true
+	}
+
+	flags |= tcpFlagACK
+	if len(payload) > 0 {
+		flags |= tcpFlagPSH
+	}
+
+	*seq, _ = c.w.writeTCP(source, destination, *seq, ack, flags, payload)
+	if flags&tcpFlagFIN != 0 {
+		// A FIN consumes a sequence number.
+		*seq++
+	}
+}
+
+// PacketConn is a net.PacketConn wrapper that captures all datagrams read
+// and written to a Writer as UDP datagrams. Capture errors do not affect the
+// connection (see Writer.Err).
+type PacketConn struct {
+	net.PacketConn
+
+	w *Writer
+}
+
+// WrapPacketConn returns a PacketConn that captures the traffic of the given
+// packetConn to the given Writer.
+func WrapPacketConn(packetConn net.PacketConn, w *Writer) *PacketConn {
+	return &PacketConn{
+		PacketConn: packetConn,
+		w:          w,
+	}
+}
+
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		c.w.WriteUDP(addr, c.LocalAddr(), b[:n])
+	}
+
+	return n, addr, err
+}
+
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		c.w.WriteUDP(c.LocalAddr(), addr, b[:n])
+	}
+
+	return n, err
+}
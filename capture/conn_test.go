@@ -0,0 +1,137 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	testing2 "github.com/brunoga/net/testing"
+)
+
+func TestConn_TCP(t *testing.T) {
+	network := testing2.NewNetwork()
+
+	l, err := network.Listen("tcp", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer l.Close()
+
+	conn, err := network.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	peer, err := l.Accept()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	var buffer bytes.Buffer
+	w, _ := NewWriter(&buffer, 0)
+	c := Wrap(conn, w)
+
+	c.Write([]byte("hello"))
+	peer.Write([]byte("world"))
+	peer.Close()
+	io.ReadAll(c)
+	c.Close()
+	c.Close()
+
+	local, remote := c.LocalAddr().String(), "127.0.0.1:8080"
+	expected := []string{
+		fmt.Sprintf("%s>%s seq=1 flags=18 hello", local, remote),
+		fmt.Sprintf("%s>%s seq=1 flags=18 world", remote, local),
+		fmt.Sprintf("%s>%s seq=6 flags=11 ", remote, local),
+		fmt.Sprintf("%s>%s seq=6 flags=11 ", local, remote),
+	}
+
+	packets := parse(t, buffer.Bytes())
+	if len(packets) != len(expected) {
+		t.Fatalf("expected %d packets, got %d", len(expected), len(packets))
+	}
+
+	for i, p := range packets {
+		got := fmt.Sprintf("%s>%s seq=%d flags=%x %s", p.source,
+			p.destination, p.seq, p.flags, p.payload)
+		if p.protocol != protocolTCP || got != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], got)
+		}
+	}
+}
+
+func TestConn_Unix(t *testing.T) {
+	var buffer bytes.Buffer
+	w, _ := NewWriter(&buffer, 0)
+
+	// Pipe addresses have no IP or port, like Unix socket ones.
+	for i := 0; i < 2; i++ {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+
+		go io.Copy(io.Discard, peer)
+
+		Wrap(conn, w).Write([]byte("hello"))
+		conn.Close()
+	}
+
+	packets := parse(t, buffer.Bytes())
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+
+	flows := make(map[string]bool)
+	for _, p := range packets {
+		flow := p.source + ">" + p.destination
+		if strings.HasSuffix(p.source, ":0") ||
+			strings.HasSuffix(p.destination, ":0") || flows[flow] {
+			t.Errorf("expected unique non-zero ports, got %s", flow)
+		}
+
+		flows[flow] = true
+	}
+}
+
+func TestConn_UDP(t *testing.T) {
+	network := testing2.NewNetwork()
+
+	peer, err := network.ListenPacket("udp", "127.0.0.1:53")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer peer.Close()
+
+	conn, err := network.Dial("udp", peer.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	var buffer bytes.Buffer
+	w, _ := NewWriter(&buffer, 0)
+	c := Wrap(conn, w)
+
+	c.Write([]byte{})
+	peer.WriteTo([]byte("reply"), c.LocalAddr())
+	c.Read(make([]byte, 64))
+	c.Close()
+
+	packets := parse(t, buffer.Bytes())
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+
+	if packets[0].protocol != protocolUDP || len(packets[0].payload) != 0 ||
+		packets[0].destination != "127.0.0.1:53" {
+		t.Errorf("expected empty datagram to 127.0.0.1:53, got %q to %q",
+			packets[0].payload, packets[0].destination)
+	}
+
+	if string(packets[1].payload) != "reply" ||
+		packets[1].source != "127.0.0.1:53" {
+		t.Errorf("expected %q from 127.0.0.1:53, got %q from %q", "reply",
+			packets[1].payload, packets[1].source)
+	}
+}
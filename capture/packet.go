@@ -0,0 +1,195 @@
+package capture
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+
+	tcpFlagFIN = 0x01
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+var (
+	sourceMAC      = []byte{0x02, 0, 0, 0, 0, 0x01}
+	destinationMAC = []byte{0x02, 0, 0, 0, 0, 0x02}
+)
+
+// endpoint is an IP address and port.
+type endpoint struct {
+	ip   net.IP
+	port int
+}
+
+// endpointOf returns the endpoint for the given address. Addresses without
+// an IP (for example, Unix socket addresses) get the given fallback IP.
+func endpointOf(addr net.Addr, fallback net.IP) endpoint {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		if addr.IP != nil {
+			return endpoint{addr.IP, addr.Port}
+		}
+	case *net.UDPAddr:
+		if addr.IP != nil {
+			return endpoint{addr.IP, addr.Port}
+		}
+	case nil:
+		return endpoint{fallback, 0}
+	}
+
+	host, portString, err := net.SplitHostPort(addr.String())
+	if err == nil {
+		ip := net.ParseIP(host)
+		port, err := strconv.Atoi(portString)
+		if ip != nil && err == nil {
+			return endpoint{ip, port}
+		}
+	}
+
+	return endpoint{fallback, 0}
+}
+
+// WriteUDP writes UDP packets with the given payload sent from the given
+// source to the given destination addresses, splitting it into multiple
+// packets if it is larger than MaxPayload.
+func (w *Writer) WriteUDP(source, destination net.Addr,
+	payload []byte) error {
+	return w.writeUDP(endpointOf(source, net.IPv4(127, 0, 0, 1)),
+		endpointOf(destination, net.IPv4(127, 0, 0, 2)), payload)
+}
+
+func (w *Writer) writeUDP(source, destination endpoint, payload []byte) error {
+	return forEachChunk(payload, func(chunk []byte) error {
+		segment := make([]byte, 8+len(chunk))
+		binary.BigEndian.PutUint16(segment[0:2], uint16(source.port))
+		binary.BigEndian.PutUint16(segment[2:4], uint16(destination.port))
+		binary.BigEndian.PutUint16(segment[4:6], uint16(len(segment)))
+		copy(segment[8:], chunk)
+
+		return w.writeIP(source.ip, destination.ip, protocolUDP, segment, 6)
+	})
+}
+
+// writeTCP writes TCP segments with the given payload (which may be empty)
+// and flags, starting at the given sequence number. It returns the sequence
+// number after the payload.
+func (w *Writer) writeTCP(source, destination endpoint, seq, ack uint32,
+	flags byte, payload []byte) (uint32, error) {
+	err := forEachChunk(payload, func(chunk []byte) error {
+		segment := make([]byte, 20+len(chunk))
+		binary.BigEndian.PutUint16(segment[0:2], uint16(source.port))
+		binary.BigEndian.PutUint16(segment[2:4], uint16(destination.port))
+		binary.BigEndian.PutUint32(segment[4:8], seq)
+		binary.BigEndian.PutUint32(segment[8:12], ack)
+		segment[12] = 5 << 4
+		segment[13] = flags
+		binary.BigEndian.PutUint16(segment[14:16], 65535)
+		copy(segment[20:], chunk)
+
+		seq += uint32(len(chunk))
+
+		return w.writeIP(source.ip, destination.ip, protocolTCP, segment, 16)
+	})
+
+	return seq, err
+}
+
+// writeIP writes an IP packet (IPv4 if both addresses are IPv4, IPv6
+// otherwise) with the given transport segment, filling in its checksum at
+// the given offset.
+func (w *Writer) writeIP(source, destination net.IP, protocol byte,
+	segment []byte, checksumOffset int) error {
+	var header, pseudoHeader []byte
+	etherType := uint16(0x0800)
+	if source.To4() != nil && destination.To4() != nil {
+		header = make([]byte, 20)
+		header[0] = 0x45
+		binary.BigEndian.PutUint16(header[2:4], uint16(20+len(segment)))
+		binary.BigEndian.PutUint16(header[4:6], w.nextIPID())
+		binary.BigEndian.PutUint16(header[6:8], 0x4000) // Don't fragment.
+		header[8] = 64
+		header[9] = protocol
+		copy(header[12:16], source.To4())
+		copy(header[16:20], destination.To4())
+		binary.BigEndian.PutUint16(header[10:12], checksum(header))
+
+		pseudoHeader = make([]byte, 12)
+		copy(pseudoHeader[0:8], header[12:20])
+		pseudoHeader[9] = protocol
+		binary.BigEndian.PutUint16(pseudoHeader[10:12], uint16(len(segment)))
+	} else {
+		etherType = 0x86dd
+		header = make([]byte, 40)
+		header[0] = 0x60
+		binary.BigEndian.PutUint16(header[4:6], uint16(len(segment)))
+		header[6] = protocol
+		header[7] = 64
+		copy(header[8:24], source.To16())
+		copy(header[24:40], destination.To16())
+
+		pseudoHeader = make([]byte, 40)
+		copy(pseudoHeader[0:32], header[8:40])
+		binary.BigEndian.PutUint32(pseudoHeader[32:36], uint32(len(segment)))
+		pseudoHeader[39] = protocol
+	}
+
+	sum := checksum(append(pseudoHeader, segment...))
+	if sum == 0 && protocol == protocolUDP {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[checksumOffset:], sum)
+
+	frame := make([]byte, 0, 14+len(header)+len(segment))
+	frame = append(frame, destinationMAC...)
+	frame = append(frame, sourceMAC...)
+	frame = append(frame, byte(etherType>>8), byte(etherType))
+	frame = append(frame, header...)
+	frame = append(frame, segment...)
+
+	return w.writeFrame(time.Now(), frame)
+}
+
+// forEachChunk calls f with consecutive chunks of up to MaxPayload bytes of
+// the given payload (once, with an empty chunk, if the payload is empty).
+func forEachChunk(payload []byte, f func([]byte) error) error {
+	for {
+		n := len(payload)
+		if n > MaxPayload {
+			n = MaxPayload
+		}
+
+		err := f(payload[:n])
+		if err != nil {
+			return err
+		}
+
+		payload = payload[n:]
+		if len(payload) == 0 {
+			return nil
+		}
+	}
+}
+
+// checksum returns the Internet checksum (RFC 1071) of the given data.
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
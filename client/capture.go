@@ -0,0 +1,29 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/brunoga/net/capture"
+)
+
+// SetCapture makes this Client capture all traffic of its connection (or
+// packet connection, for unconnected packet Clients) to the given Writer in
+// pcap format (see package capture). Data is captured as sent through the
+// network (for example, before reassembly with SetFragmentation). It must be
+// called before Start.
+func (c *Client) SetCapture(w *capture.Writer) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.started {
+		return fmt.Errorf("client already started")
+	}
+
+	if w == nil {
+		return fmt.Errorf("writer cannot be nil")
+	}
+
+	c.capturer = w
+
+	return nil
+}
@@ -5,6 +5,11 @@ import (
 	"fmt"
 	"net"
 	"sync"
+
+	"github.com/brunoga/net/capture"
+	"github.com/brunoga/net/fragment"
+	"github.com/brunoga/net/record"
+	"github.com/brunoga/net/reliable"
 )
 
 // Client is a client that connects to a specific address using a specific
@@ -16,14 +21,32 @@ type Client struct {
 	splitFunc   bufio.SplitFunc
 	dataHandler DataHandler
 
+	// Only set for packet clients (see NewPacket and NewUnconnectedPacket).
+	packetDataHandler PacketDataHandler
+	unconnected       bool
+	maxDatagramSize   int
+	fragmentation     *fragment.Config
+
+	// Only set for reliable clients (see NewReliable).
+	reliable *reliable.Config
+
+	recorder *record.Writer
+	capturer *capture.Writer
+
 	// For testing purposes only.
-	dial func(string, string) (net.Conn, error)
+	dial         func(string, string) (net.Conn, error)
+	listenPacket func(string, string) (net.PacketConn, error)
 
 	wg sync.WaitGroup
 
-	m       sync.Mutex
-	conn    net.Conn
-	started bool
+	// Serializes writes, so Send does not hold m while a write blocks (which
+	// would prevent Stop from closing the connection).
+	sendM sync.Mutex
+
+	m          sync.Mutex
+	conn       net.Conn       // nil if packetConn is not
+	packetConn net.PacketConn // Only for unconnected packet clients.
+	started    bool
 }
 
 // DataHandler is the signature for functions that should be called when
@@ -92,7 +115,14 @@ func (c *Client) Start() error {
 		return fmt.Errorf("client already started")
 	}
 
-	if c.conn == nil {
+	if c.unconnected {
+		packetConn, err := c.listenPacket(c.network, c.address)
+		if err != nil {
+			return err
+		}
+
+		c.packetConn = packetConn
+	} else if c.conn == nil {
 		conn, err := c.dial(c.network, c.address)
 		if err != nil {
 			return err
@@ -101,8 +131,39 @@ func (c *Client) Start() error {
 		c.conn = conn
 	}
 
+	if c.capturer != nil {
+		if c.packetConn != nil {
+			c.packetConn = capture.WrapPacketConn(c.packetConn, c.capturer)
+		} else {
+			c.conn = capture.Wrap(c.conn, c.capturer)
+		}
+	}
+
+	if c.reliable != nil {
+		c.conn = reliable.Wrap(c.conn, *c.reliable)
+	}
+
+	bufferSize := c.maxDatagramSize
+	if c.fragmentation != nil {
+		fragmentConn := fragment.Wrap(c.conn, *c.fragmentation)
+		bufferSize = fragmentConn.MaxMessageSize()
+		c.conn = fragmentConn
+	}
+
+	if c.recorder != nil {
+		if c.packetConn != nil {
+			c.packetConn = record.WrapPacketConn(c.packetConn, c.recorder)
+		} else {
+			c.conn = record.Wrap(c.conn, c.recorder)
+		}
+	}
+
 	c.wg.Add(1)
-	go c.receiveLoop()
+	if c.packetDataHandler != nil {
+		go c.packetReceiveLoop(bufferSize)
+	} else {
+		go c.receiveLoop()
+	}
 
 	c.started = true
 
@@ -119,32 +180,62 @@ func (c *Client) Stop() error {
 		return fmt.Errorf("client not started")
 	}
 
-	c.conn.Close()
+	if c.packetConn != nil {
+		c.packetConn.Close()
+	} else {
+		c.conn.Close()
+	}
 
 	c.wg.Wait()
 
 	c.started = false
 	c.conn = nil
+	c.packetConn = nil
 
 	return nil
 }
 
 // Send tries to send the given data to the connection associated with this
-// Client. It returns a nil error on success and a non-nil error on failure.
+// Client. For packet clients, data is sent as a single datagram and it is an
+// error to send more than the maximum datagram size (see SetMaxDatagramSize).
+// It returns a nil error on success and a non-nil error on failure.
 func (c *Client) Send(data []byte) error {
+	conn, err := c.sendConn(data)
+	if err != nil {
+		return err
+	}
+
+	c.sendM.Lock()
+	defer c.sendM.Unlock()
+
+	_, err = conn.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// sendConn returns the connection Send should write the given data to.
+func (c *Client) sendConn(data []byte) (net.Conn, error) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
 	if !c.started {
-		return fmt.Errorf("client not started")
+		return nil, fmt.Errorf("client not started")
 	}
 
-	_, err := c.conn.Write(data)
-	if err != nil {
-		return err
+	if c.unconnected {
+		return nil, fmt.Errorf("unconnected packet clients must use SendTo")
 	}
 
-	return nil
+	if c.packetDataHandler != nil && c.fragmentation == nil &&
+		len(data) > c.maxDatagramSize {
+		return nil, fmt.Errorf("datagram too large (%d > %d bytes)",
+			len(data), c.maxDatagramSize)
+	}
+
+	return c.conn, nil
 }
 
 func (c *Client) receiveLoop() {
@@ -2,8 +2,16 @@ package client
 
 import (
 	"bufio"
+	"bytes"
 	"net"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/brunoga/net/capture"
+	"github.com/brunoga/net/record"
+
+	testing2 "github.com/brunoga/net/testing"
 )
 
 func TestNew(t *testing.T) {
@@ -215,3 +223,238 @@ func TestConnection(t *testing.T) {
 		t.Errorf("expected %v, got %v", "test3", data)
 	}
 }
+
+func TestSetNetwork(t *testing.T) {
+	n := testing2.NewNetwork()
+
+	l, err := n.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer l.Close()
+
+	ch := make(chan string, 1)
+	c, err := New("tcp", l.Addr().String(), bufio.ScanLines, func(data []byte) {
+		ch <- string(data)
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.SetNetwork(n)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer c.Stop()
+
+	err = c.SetNetwork(n)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("hello\n"))
+
+	select {
+	case data := <-ch:
+		if data != "hello" {
+			t.Errorf("expected %q, got %q", "hello", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected data, got nothing")
+	}
+}
+
+func TestConnection_Scripted(t *testing.T) {
+	conn := testing2.NewScriptedConn(
+		testing2.Expect([]byte("ping\n")),
+		testing2.Reply([]byte("pong\n")),
+		testing2.ExpectClose(),
+	)
+
+	ch := make(chan string, 1)
+	c, err := NewWithConn(conn, bufio.ScanLines, func(data []byte) {
+		ch <- string(data)
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.Send([]byte("ping\n"))
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	select {
+	case data := <-ch:
+		if data != "pong" {
+			t.Errorf("expected %q, got %q", "pong", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected data, got nothing")
+	}
+
+	c.Stop()
+
+	err = conn.Wait(time.Second)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+}
+
+func TestSetRecorder(t *testing.T) {
+	n := testing2.NewNetwork()
+
+	l, err := n.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			conn.Write([]byte(scanner.Text() + "!\n"))
+		}
+
+		conn.Close()
+	}()
+
+	// Talks to the server and returns the received lines.
+	talk := func(c *Client, ch chan string) []string {
+		err := c.Start()
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		defer c.Stop()
+
+		var received []string
+		for _, line := range []string{"hello\n", "world\n"} {
+			c.Send([]byte(line))
+
+			select {
+			case data := <-ch:
+				received = append(received, data)
+			case <-time.After(time.Second):
+				t.Fatal("expected data, got nothing")
+			}
+		}
+
+		return received
+	}
+
+	ch := make(chan string, 1)
+	dataHandler := func(data []byte) {
+		ch <- string(data)
+	}
+
+	c, err := New("tcp", l.Addr().String(), bufio.ScanLines, dataHandler)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	c.SetNetwork(n)
+
+	err = c.SetRecorder(nil)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	var buffer bytes.Buffer
+	w, _ := record.NewWriter(&buffer)
+
+	err = c.SetRecorder(w)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := talk(c, ch)
+
+	// Replaying the recording against a new client produces the same
+	// results.
+	conn, err := testing2.NewReplayConn(&buffer, 0)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	c, err = NewWithConn(conn, bufio.ScanLines, dataHandler)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	received := talk(c, ch)
+	if strings.Join(received, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, received)
+	}
+
+	err = conn.Wait(time.Second)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+}
+
+func TestSetCapture(t *testing.T) {
+	n := testing2.NewNetwork()
+
+	peer, err := n.ListenPacket("udp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer peer.Close()
+
+	c, err := NewPacket("udp", peer.LocalAddr().String(), func([]byte) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	c.SetNetwork(n)
+
+	err = c.SetCapture(nil)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	var buffer bytes.Buffer
+	w, _ := capture.NewWriter(&buffer, 0)
+
+	err = c.SetCapture(w)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	c.Send([]byte("hello"))
+	c.Stop()
+
+	// One UDP packet (16 bytes record header, 14 bytes Ethernet header, 20
+	// bytes IPv4 header, 8 bytes UDP header and 5 bytes payload).
+	captured := buffer.Bytes()
+	if len(captured) != 24+16+14+20+8+5 ||
+		!bytes.HasSuffix(captured, []byte("hello")) {
+		t.Errorf("expected 1 packet, got %x", captured)
+	}
+}
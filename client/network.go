@@ -0,0 +1,30 @@
+package client
+
+import (
+	"fmt"
+	"net"
+)
+
+// Network is the interface for networks a Client can connect through (see
+// SetNetwork). testing.Network implements it.
+type Network interface {
+	Dial(network, address string) (net.Conn, error)
+	ListenPacket(network, address string) (net.PacketConn, error)
+}
+
+// SetNetwork makes this Client connect (or listen, for unconnected packet
+// Clients) through the given Network instead of using package net. It must be
+// called before Start.
+func (c *Client) SetNetwork(network Network) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.started {
+		return fmt.Errorf("client already started")
+	}
+
+	c.dial = network.Dial
+	c.listenPacket = network.ListenPacket
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/brunoga/net/fragment"
+	"github.com/brunoga/net/reliable"
+)
+
+// DefaultMaxDatagramSize is the default maximum size of datagrams sent and
+// received by packet Clients (the maximum UDP payload over IPv4).
+const DefaultMaxDatagramSize = 65507
+
+// PacketDataHandler is the signature for functions that should be called when
+// a datagram is received by an unconnected packet Client. The data slice is
+// only valid until the handler returns.
+type PacketDataHandler func(data []byte, from net.Addr)
+
+// NewPacket creates a new Client instance that will "connect" to the given
+// packet network (for example, "udp") and address and that will call the
+// given dataHandler for each received datagram. Datagram boundaries are
+// preserved: each received datagram is passed to the dataHandler as exactly
+// one message and each Send call sends exactly one datagram. Note that
+// NewPacket only validates that the dataHandler is not nil. All other errors
+// will be reported when Start is called.
+func NewPacket(network, address string,
+	dataHandler DataHandler) (*Client, error) {
+	if dataHandler == nil {
+		return nil, fmt.Errorf("dataHandler cannot be nil")
+	}
+
+	c, err := New(network, address, ScanFullBuffer, dataHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	c.packetDataHandler = func(data []byte, from net.Addr) {
+		dataHandler(data)
+	}
+	c.maxDatagramSize = DefaultMaxDatagramSize
+
+	return c, nil
+}
+
+// NewUnconnectedPacket creates a new Client instance that will listen at the
+// given packet network and local address without being associated with any
+// specific peer. Datagrams are sent with SendTo (Send is not supported) and
+// each received datagram is passed to the given packetDataHandler, together
+// with the address it came from. Note that NewUnconnectedPacket only validates
+// that the packetDataHandler is not nil. All other errors will be reported
+// when Start is called.
+func NewUnconnectedPacket(network, localAddress string,
+	packetDataHandler PacketDataHandler) (*Client, error) {
+	if packetDataHandler == nil {
+		return nil, fmt.Errorf("packetDataHandler cannot be nil")
+	}
+
+	return &Client{
+		network:           network,
+		address:           localAddress,
+		packetDataHandler: packetDataHandler,
+		unconnected:       true,
+		maxDatagramSize:   DefaultMaxDatagramSize,
+		dial:              net.Dial,
+		listenPacket:      net.ListenPacket,
+	}, nil
+}
+
+// NewReliable creates a new Client instance that will "connect" to the given
+// packet network and address and use the reliability layer implemented by
+// package reliable (configured with the given config) on top of it. The
+// server must also use it (see server.Reliable). As with stream Clients,
+// incoming data is parsed into tokens with the given splitFunc and each token
+// is passed to the given dataHandler. Note that NewReliable only validates
+// that the dataHandler and splitFunc are not nil. All other errors will be
+// reported when Start is called.
+func NewReliable(network, address string, config reliable.Config,
+	splitFunc bufio.SplitFunc, dataHandler DataHandler) (*Client, error) {
+	c, err := New(network, address, splitFunc, dataHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	c.reliable = &config
+
+	return c, nil
+}
+
+// SetMaxDatagramSize sets the maximum size of datagrams sent and received by
+// this packet Client. Sending larger datagrams fails and larger received
+// datagrams are truncated. It must be called before Start.
+func (c *Client) SetMaxDatagramSize(size int) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.started {
+		return fmt.Errorf("client already started")
+	}
+
+	if c.packetDataHandler == nil {
+		return fmt.Errorf("not a packet client")
+	}
+
+	if size <= 0 {
+		return fmt.Errorf("invalid datagram size %d", size)
+	}
+
+	c.maxDatagramSize = size
+
+	return nil
+}
+
+// SetFragmentation enables the fragmentation layer implemented by package
+// fragment (configured with the given config) for this connected packet
+// Client, so messages larger than a single datagram can be sent and received
+// (up to config.MaxMessageSize). Each Send is still delivered as exactly one
+// message. The server must also use it (see server.Fragmentation). It must be
+// called before Start.
+func (c *Client) SetFragmentation(config fragment.Config) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.started {
+		return fmt.Errorf("client already started")
+	}
+
+	if c.packetDataHandler == nil || c.unconnected {
+		return fmt.Errorf("not a connected packet client")
+	}
+
+	c.fragmentation = &config
+
+	return nil
+}
+
+// SendTo tries to send the given data as a single datagram to the given
+// address. It is only supported by unconnected packet Clients (see
+// NewUnconnectedPacket). It returns a nil error on success and a non-nil error
+// on failure.
+func (c *Client) SendTo(data []byte, addr net.Addr) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if !c.started {
+		return fmt.Errorf("client not started")
+	}
+
+	if !c.unconnected {
+		return fmt.Errorf("SendTo requires an unconnected packet client")
+	}
+
+	if len(data) > c.maxDatagramSize {
+		return fmt.Errorf("datagram too large (%d > %d bytes)", len(data),
+			c.maxDatagramSize)
+	}
+
+	_, err := c.packetConn.WriteTo(data, addr)
+
+	return err
+}
+
+func (c *Client) packetReceiveLoop(bufferSize int) {
+	buffer := make([]byte, bufferSize)
+	for {
+		var n int
+		var addr net.Addr
+		var err error
+		if c.unconnected {
+			n, addr, err = c.packetConn.ReadFrom(buffer)
+		} else {
+			n, err = c.conn.Read(buffer)
+			addr = c.conn.RemoteAddr()
+		}
+
+		if err != nil {
+			// Connected sockets report ICMP errors from previous sends
+			// (for example, no one listening at the peer yet).
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				continue
+			}
+
+			break
+		}
+
+		c.packetDataHandler(buffer[:n], addr)
+	}
+
+	c.wg.Done()
+}
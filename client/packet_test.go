@@ -0,0 +1,241 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brunoga/net/fragment"
+	"github.com/brunoga/net/reliable"
+)
+
+func TestNewPacket(t *testing.T) {
+	_, err := NewPacket("udp", "", nil)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, err = NewUnconnectedPacket("udp", "", nil)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	c, err := NewPacket("udp", "", func([]byte) {})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	err = c.SetMaxDatagramSize(0)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	err = c.SetFragmentation(fragment.Config{})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	c, err = NewUnconnectedPacket("udp", "", func([]byte, net.Addr) {})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	err = c.SetFragmentation(fragment.Config{})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	c, err = New("", "", ScanFullBuffer, func([]byte) {})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	err = c.SetMaxDatagramSize(512)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestPacket_Connected(t *testing.T) {
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer peer.Close()
+
+	ch := make(chan string, 8)
+	c, err := NewPacket("udp", peer.LocalAddr().String(), func(data []byte) {
+		ch <- string(data)
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	c.SetMaxDatagramSize(8)
+
+	err = c.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer c.Stop()
+
+	err = c.Send([]byte("too large"))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	err = c.SendTo([]byte("test"), peer.LocalAddr())
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	// Each Send is exactly one datagram.
+	expected := []string{"a", "bb", "ccc"}
+	buffer := make([]byte, 64)
+	var addr net.Addr
+	for _, data := range expected {
+		err = c.Send([]byte(data))
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+
+		var n int
+		n, addr, err = peer.ReadFrom(buffer)
+		if err != nil || string(buffer[:n]) != data {
+			t.Fatalf("expected %q, got %q (%v)", data, buffer[:n], err)
+		}
+	}
+
+	// Each received datagram is exactly one message.
+	for _, data := range expected {
+		peer.WriteTo([]byte(data), addr)
+	}
+
+	for _, data := range expected {
+		select {
+		case received := <-ch:
+			if received != data {
+				t.Errorf("expected %q, got %q", data, received)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %q, got nothing", data)
+		}
+	}
+}
+
+func TestPacket_Unconnected(t *testing.T) {
+	type datagram struct {
+		data string
+		from string
+	}
+
+	ch := make(chan datagram, 8)
+	c, err := NewUnconnectedPacket("udp", "127.0.0.1:0",
+		func(data []byte, from net.Addr) {
+			ch <- datagram{string(data), from.String()}
+		})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer c.Stop()
+
+	err = c.Send([]byte("test"))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	localAddr := c.packetConn.LocalAddr()
+	buffer := make([]byte, 64)
+	for i := 0; i < 2; i++ {
+		peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		defer peer.Close()
+
+		err = c.SendTo([]byte("ping"), peer.LocalAddr())
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+
+		n, _, err := peer.ReadFrom(buffer)
+		if err != nil || string(buffer[:n]) != "ping" {
+			t.Fatalf("expected %q, got %q (%v)", "ping", buffer[:n], err)
+		}
+
+		peer.WriteTo([]byte("pong"), localAddr)
+
+		select {
+		case received := <-ch:
+			expected := datagram{"pong", peer.LocalAddr().String()}
+			if received != expected {
+				t.Errorf("expected %v, got %v", expected, received)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected datagram, got nothing")
+		}
+	}
+}
+
+func TestReliable_StopDuringSend(t *testing.T) {
+	// A peer that never acknowledges anything.
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer peer.Close()
+
+	c, err := NewReliable("udp", peer.LocalAddr().String(), reliable.Config{
+		MaxPayload:   100,
+		Window:       1,
+		CloseTimeout: 10 * time.Millisecond,
+	}, ScanFullBuffer, func([]byte) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// Blocks waiting for the window to open.
+	sendErrCh := make(chan error, 1)
+	go func() {
+		sendErrCh <- c.Send(make([]byte, 1000))
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	stopErrCh := make(chan error, 1)
+	go func() {
+		stopErrCh <- c.Stop()
+	}()
+
+	select {
+	case err := <-stopErrCh:
+		if err != nil {
+			t.Errorf("expected nil error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Stop to not wait for the blocked Send")
+	}
+
+	select {
+	case err := <-sendErrCh:
+		if err == nil {
+			t.Error("expected non-nil error, got nil")
+		}
+	case <-time.After(time.Second):
+		t.Error("expected Send to fail once stopped")
+	}
+}
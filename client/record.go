@@ -0,0 +1,30 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/brunoga/net/record"
+)
+
+// SetRecorder makes this Client record all traffic of its connection (or
+// packet connection, for unconnected packet Clients) to the given Writer
+// (see package record). Data is recorded as seen by the Client (for example,
+// after reassembly with SetFragmentation), so recordings can be replayed with
+// testing.NewReplayConn and NewWithConn. Recordings of all connections
+// (after restarts) go to the same Writer. It must be called before Start.
+func (c *Client) SetRecorder(w *record.Writer) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.started {
+		return fmt.Errorf("client already started")
+	}
+
+	if w == nil {
+		return fmt.Errorf("writer cannot be nil")
+	}
+
+	c.recorder = w
+
+	return nil
+}
@@ -0,0 +1,446 @@
+// Command netc is a netcat-like tool built on client.Client. It connects to
+// the given address, sends messages read from stdin (one per line) or from
+// files (one per file) and prints the tokens it receives.
+//
+// Received data is split into tokens with a named split function that also
+// determines how sent messages are framed:
+//
+//	lines   newline terminated lines
+//	length  messages prefixed with their big endian length (see -length-size)
+//	full    whatever is available (messages are sent as is)
+//	hex     newline terminated lines of hexadecimal digits
+//
+// For packet networks (udp and unixgram), each datagram is one message and
+// -split is ignored (unless -reliable is used).
+//
+// Usage:
+//
+//	netc [-network tcp] [-split lines] [-output text|hex|json]
+//	     [-input text|hex] [-file path]... [flags] address
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/brunoga/net/capture"
+	"github.com/brunoga/net/client"
+	"github.com/brunoga/net/fragment"
+	"github.com/brunoga/net/record"
+	"github.com/brunoga/net/reliable"
+)
+
+// Maximum size of a stdin line.
+const maxLineSize = 1 << 20
+
+// config is the netc configuration (usually set from flags).
+type config struct {
+	Network    string
+	Address    string
+	Split      string
+	LengthSize int
+	Output     string
+	Input      string
+	Files      []string
+
+	// How long to wait for data after all messages are sent. If negative,
+	// wait until the connection is closed.
+	Wait time.Duration
+
+	Reliable bool
+	Fragment bool
+	Record   string
+	Capture  string
+}
+
+// files is a flag.Value for repeated -file flags.
+type files []string
+
+func (f *files) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *files) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	var c config
+	var fileList files
+
+	flag.StringVar(&c.Network, "network", "tcp",
+		"network to connect through (tcp, udp, unix, unixgram, ...)")
+	flag.StringVar(&c.Split, "split", "lines",
+		"how to split and frame data (lines, length, full or hex)")
+	flag.IntVar(&c.LengthSize, "length-size", 4,
+		"size in bytes of the length prefix for -split length (1, 2, 4 or 8)")
+	flag.StringVar(&c.Output, "output", "text",
+		"how to print received tokens (text, hex or json)")
+	flag.StringVar(&c.Input, "input", "text",
+		"how to read stdin lines (text, or hex to decode them)")
+	flag.Var(&fileList, "file",
+		"file to send as a single message instead of stdin (repeatable)")
+	flag.DurationVar(&c.Wait, "wait", time.Second,
+		"how long to wait for data after sending (negative, like -1s, waits "+
+			"until the connection is closed)")
+	flag.BoolVar(&c.Reliable, "reliable", false,
+		"use the reliability layer for packet networks (see package reliable)")
+	flag.BoolVar(&c.Fragment, "fragment", false,
+		"use the fragmentation layer for packet networks (see package "+
+			"fragment)")
+	flag.StringVar(&c.Record, "record", "",
+		"file to record the connection to (see package record)")
+	flag.StringVar(&c.Capture, "capture", "",
+		"pcap file to capture traffic to (see package capture)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"usage: %s [flags] address\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c.Address = flag.Arg(0)
+	c.Files = fileList
+
+	err := run(c, os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "netc: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run connects as configured by c, sends all messages read from stdin (or
+// from the configured files) and writes the received tokens to stdout.
+func run(c config, stdin io.Reader, stdout io.Writer) error {
+	f, err := newFraming(c.Split, c.LengthSize)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPrinter(c.Output, stdout)
+	if err != nil {
+		return err
+	}
+
+	if c.Input != "text" && c.Input != "hex" {
+		return fmt.Errorf("unknown input %q", c.Input)
+	}
+
+	packet := isPacketNetwork(c.Network)
+	if c.Fragment && (!packet || c.Reliable) {
+		return fmt.Errorf("fragmentation requires a packet network " +
+			"without -reliable")
+	}
+
+	var cl *client.Client
+	if packet && c.Reliable {
+		cl, err = client.NewReliable(c.Network, c.Address, reliable.Config{},
+			f.Split, p.print)
+	} else if packet {
+		cl, err = client.NewPacket(c.Network, c.Address, p.print)
+		f.Frame = frameFull
+	} else {
+		cl, err = client.New(c.Network, c.Address, f.Split, p.print)
+	}
+	if err != nil {
+		return err
+	}
+
+	d, err := newDialer(c.Network)
+	if err != nil {
+		return err
+	}
+	defer d.cleanup()
+
+	err = cl.SetNetwork(d)
+	if err != nil {
+		return err
+	}
+
+	if c.Fragment {
+		err = cl.SetFragmentation(fragment.Config{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Record != "" {
+		output, err := os.Create(c.Record)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+
+		w, err := record.NewWriter(output)
+		if err != nil {
+			return err
+		}
+
+		err = cl.SetRecorder(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Capture != "" {
+		w, err := capture.Create(capture.Config{Path: c.Capture})
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+
+		err = cl.SetCapture(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cl.Start()
+	if err != nil {
+		return err
+	}
+
+	err = send(c, f, cl, stdin)
+
+	if err == nil {
+		if c.Wait < 0 {
+			<-d.closedCh
+		} else {
+			select {
+			case <-d.closedCh:
+			case <-time.After(c.Wait):
+			}
+		}
+	}
+
+	cl.Stop()
+
+	if err == nil {
+		err = p.err
+	}
+
+	return err
+}
+
+// send sends the configured files or, if there are none, all lines read from
+// stdin.
+func send(c config, f framing, cl *client.Client, stdin io.Reader) error {
+	sendMessage := func(message []byte) error {
+		framed, err := f.Frame(message)
+		if err != nil {
+			return err
+		}
+
+		return cl.Send(framed)
+	}
+
+	if len(c.Files) > 0 {
+		for _, path := range c.Files {
+			message, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			err = sendMessage(message)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+
+		return nil
+	}
+
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		message := scanner.Bytes()
+		if c.Input == "hex" {
+			var err error
+			message, err = decodeHex(message)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := sendMessage(message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// isPacketNetwork returns true if the given network is a packet network.
+func isPacketNetwork(network string) bool {
+	return strings.HasPrefix(network, "udp") || network == "unixgram"
+}
+
+// printer prints received tokens in a given format.
+type printer struct {
+	output string
+	w      io.Writer
+
+	m   sync.Mutex
+	err error
+}
+
+// jsonToken is how tokens are printed by the json output.
+type jsonToken struct {
+	Time   time.Time `json:"time"`
+	Length int       `json:"length"`
+	Text   string    `json:"text"`
+	Hex    string    `json:"hex"`
+}
+
+func newPrinter(output string, w io.Writer) (*printer, error) {
+	switch output {
+	case "text", "hex", "json":
+	default:
+		return nil, fmt.Errorf("unknown output %q", output)
+	}
+
+	return &printer{
+		output: output,
+		w:      w,
+	}, nil
+}
+
+// print prints the given token. It is a client.DataHandler.
+func (p *printer) print(token []byte) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var err error
+	switch p.output {
+	case "text":
+		_, err = fmt.Fprintf(p.w, "%s\n", token)
+	case "hex":
+		_, err = fmt.Fprintln(p.w, hex.EncodeToString(token))
+	case "json":
+		err = json.NewEncoder(p.w).Encode(jsonToken{
+			Time:   time.Now(),
+			Length: len(token),
+			Text:   string(token),
+			Hex:    hex.EncodeToString(token),
+		})
+	}
+
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+// dialer is a client.Network that notices when connections are closed (by
+// the peer or by an error). For unixgram, it binds connections to temporary
+// addresses (in a directory created once) so replies can be received.
+type dialer struct {
+	closedCh  chan struct{}
+	closeOnce sync.Once
+
+	dir     string // Only for unixgram.
+	sockets uint32 // Number of unixgram sockets created in dir.
+}
+
+func newDialer(network string) (*dialer, error) {
+	d := &dialer{
+		closedCh: make(chan struct{}),
+	}
+
+	if network == "unixgram" {
+		var err error
+		d.dir, err = os.MkdirTemp("", "netc")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (d *dialer) Dial(network, address string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if network == "unixgram" {
+		conn, err = d.dialUnixgram(address)
+	} else {
+		conn, err = net.Dial(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &dialerConn{Conn: conn, d: d}, nil
+}
+
+func (d *dialer) ListenPacket(network, address string) (net.PacketConn,
+	error) {
+	return net.ListenPacket(network, address)
+}
+
+func (d *dialer) dialUnixgram(address string) (net.Conn, error) {
+	if d.dir == "" {
+		return nil, fmt.Errorf("dialer not created for unixgram")
+	}
+
+	// Each connection (the client might reconnect) gets its own address.
+	name := fmt.Sprintf("netc-%d.sock", atomic.AddUint32(&d.sockets, 1))
+
+	laddr := &net.UnixAddr{
+		Name: filepath.Join(d.dir, name),
+		Net:  "unixgram",
+	}
+	raddr := &net.UnixAddr{Name: address, Net: "unixgram"}
+
+	return net.DialUnix("unixgram", laddr, raddr)
+}
+
+// cleanup removes temporary files created by the dialer.
+func (d *dialer) cleanup() {
+	if d.dir != "" {
+		os.RemoveAll(d.dir)
+	}
+}
+
+// dialerConn is a net.Conn that notifies its dialer when reads fail with an
+// error that stops the client receive loops.
+type dialerConn struct {
+	net.Conn
+
+	d *dialer
+}
+
+func (c *dialerConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	// Packet clients keep reading after ICMP errors from previous sends (for
+	// example, no one listening at the peer yet).
+	if err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+		c.d.closeOnce.Do(func() {
+			close(c.d.closedCh)
+		})
+	}
+
+	return n, err
+}
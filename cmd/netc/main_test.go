@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/brunoga/net/reliable"
+	"github.com/brunoga/net/server"
+	testing2 "github.com/brunoga/net/testing"
+)
+
+func echo(conn net.Conn) {
+	io.Copy(conn, conn)
+	conn.Close()
+}
+
+func startServer(t *testing.T, network, address string,
+	middlewares ...server.Middleware) *server.Server {
+	s, err := server.New(network, address, echo)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	s.Use(middlewares...)
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	t.Cleanup(func() {
+		s.Stop()
+	})
+
+	return s
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	for _, c := range []config{
+		{Network: "tcp", Split: "xml", Output: "text", Input: "text"},
+		{Network: "tcp", Split: "lines", Output: "xml", Input: "text"},
+		{Network: "tcp", Split: "lines", Output: "text", Input: "xml"},
+		{Network: "tcp", Split: "lines", Output: "text", Input: "text",
+			Fragment: true},
+	} {
+		err := run(c, strings.NewReader(""), io.Discard)
+		if err == nil {
+			t.Errorf("%+v: expected non-nil error, got nil", c)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, test := range []struct {
+		name        string
+		network     string
+		address     string
+		split       string
+		middlewares []server.Middleware
+		reliable    bool
+	}{
+		{"tcp/lines", "tcp", "127.0.0.1:0", "lines", nil, false},
+		{"tcp/length", "tcp", "127.0.0.1:0", "length", nil, false},
+		{"tcp/hex", "tcp", "127.0.0.1:0", "hex", nil, false},
+		{"unix/lines", "unix", filepath.Join(dir, "echo.sock"), "lines", nil,
+			false},
+		{"udp", "udp", "127.0.0.1:0", "lines", nil, false},
+		{"udp/reliable", "udp", "127.0.0.1:0", "length",
+			[]server.Middleware{server.Reliable(reliable.Config{})}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := startServer(t, test.network, test.address,
+				test.middlewares...)
+
+			var stdout bytes.Buffer
+			err := run(config{
+				Network:    test.network,
+				Address:    s.Addr().String(),
+				Split:      test.split,
+				LengthSize: 4,
+				Output:     "text",
+				Input:      "text",
+				Wait:       200 * time.Millisecond,
+				Reliable:   test.reliable,
+			}, strings.NewReader("hello\nworld\n"), &stdout)
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			if stdout.String() != "hello\nworld\n" {
+				t.Errorf("expected %q, got %q", "hello\nworld\n",
+					stdout.String())
+			}
+		})
+	}
+}
+
+func TestRun_Output(t *testing.T) {
+	s := startServer(t, "tcp", "127.0.0.1:0")
+
+	c := config{
+		Network: "tcp",
+		Address: s.Addr().String(),
+		Split:   "lines",
+		Output:  "hex",
+		Input:   "hex",
+		Wait:    200 * time.Millisecond,
+	}
+
+	var stdout bytes.Buffer
+	err := run(c, strings.NewReader("68 69\n"), &stdout)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if stdout.String() != "6869\n" {
+		t.Errorf("expected %q, got %q", "6869\n", stdout.String())
+	}
+
+	c.Output = "json"
+	stdout.Reset()
+	err = run(c, strings.NewReader("6869\n"), &stdout)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	var token jsonToken
+	err = json.Unmarshal(stdout.Bytes(), &token)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if token.Length != 2 || token.Text != "hi" || token.Hex != "6869" {
+		t.Errorf("unexpected token %+v", token)
+	}
+}
+
+func TestRun_Files(t *testing.T) {
+	s := startServer(t, "tcp", "127.0.0.1:0")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "message")
+	err := os.WriteFile(path, []byte("multi\nline"), 0644)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	var stdout bytes.Buffer
+	err = run(config{
+		Network:    "tcp",
+		Address:    s.Addr().String(),
+		Split:      "length",
+		LengthSize: 2,
+		Output:     "hex",
+		Input:      "text",
+		Files:      []string{path, path},
+		Wait:       200 * time.Millisecond,
+	}, strings.NewReader("ignored\n"), &stdout)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := strings.Repeat("6d756c74690a6c696e65\n", 2)
+	if stdout.String() != expected {
+		t.Errorf("expected %q, got %q", expected, stdout.String())
+	}
+}
+
+func TestRun_WaitForClose(t *testing.T) {
+	s, err := server.New("tcp", "127.0.0.1:0", func(conn net.Conn) {
+		conn.Write([]byte("bye\n"))
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	doneCh := make(chan error, 1)
+	var stdout bytes.Buffer
+	go func() {
+		doneCh <- run(config{
+			Network: "tcp",
+			Address: s.Addr().String(),
+			Split:   "lines",
+			Output:  "text",
+			Input:   "text",
+			Wait:    -1,
+		}, strings.NewReader(""), &stdout)
+	}()
+
+	select {
+	case err := <-doneCh:
+		if err != nil {
+			t.Errorf("expected nil error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected run to return once the connection is closed")
+	}
+
+	if stdout.String() != "bye\n" {
+		t.Errorf("expected %q, got %q", "bye\n", stdout.String())
+	}
+}
+
+func TestDialer_Unixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+	packetConn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer packetConn.Close()
+
+	d, err := newDialer("unixgram")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// Redials must reuse the same directory, with a new address each.
+	addrs := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		conn, err := d.Dial("unixgram", path)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		defer conn.Close()
+
+		addr := conn.LocalAddr().String()
+		if filepath.Dir(addr) != d.dir || addrs[addr] {
+			t.Errorf("expected new address in %s, got %s", d.dir, addr)
+		}
+
+		addrs[addr] = true
+	}
+
+	d.cleanup()
+
+	_, err = os.Stat(d.dir)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", d.dir, err)
+	}
+}
+
+func TestDialerConn_Read(t *testing.T) {
+	d, err := newDialer("udp")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	errs := []error{syscall.ECONNREFUSED, nil, io.EOF}
+	conn := &dialerConn{
+		Conn: &testing2.MockConn{
+			ReadFunc: func(b []byte) (int, error) {
+				err := errs[0]
+				errs = errs[1:]
+
+				return 0, err
+			},
+		},
+		d: d,
+	}
+
+	closed := func() bool {
+		select {
+		case <-d.closedCh:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Refused sends are retried by packet clients.
+	conn.Read(nil)
+	conn.Read(nil)
+	if closed() {
+		t.Error("expected dialer to not be closed")
+	}
+
+	conn.Read(nil)
+	if !closed() {
+		t.Error("expected dialer to be closed")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/brunoga/net/client"
+)
+
+// framing is a named way of splitting received data into tokens (with a
+// bufio.SplitFunc) and of framing messages before sending them.
+type framing struct {
+	// Split splits received data into tokens.
+	Split bufio.SplitFunc
+
+	// Frame returns the given message framed so it is received as a single
+	// token by the peer.
+	Frame func(message []byte) ([]byte, error)
+}
+
+// newFraming returns the framing with the given name ("lines", "length",
+// "full" or "hex"). lengthSize is the size of the length prefix (1, 2, 4 or 8
+// bytes) for "length".
+func newFraming(name string, lengthSize int) (framing, error) {
+	switch name {
+	case "lines":
+		return framing{bufio.ScanLines, frameLine}, nil
+	case "length":
+		if lengthSize != 1 && lengthSize != 2 && lengthSize != 4 &&
+			lengthSize != 8 {
+			return framing{}, fmt.Errorf("invalid length size %d",
+				lengthSize)
+		}
+
+		return framing{
+			scanLengthPrefixed(lengthSize),
+			frameLengthPrefixed(lengthSize),
+		}, nil
+	case "full":
+		return framing{client.ScanFullBuffer, frameFull}, nil
+	case "hex":
+		return framing{scanHexLines, frameHexLine}, nil
+	}
+
+	return framing{}, fmt.Errorf("unknown split %q", name)
+}
+
+func frameLine(message []byte) ([]byte, error) {
+	if bytes.IndexByte(message, '\n') >= 0 {
+		return nil, fmt.Errorf("lines cannot contain newlines")
+	}
+
+	return append(append([]byte(nil), message...), '\n'), nil
+}
+
+func frameFull(message []byte) ([]byte, error) {
+	return message, nil
+}
+
+// scanLengthPrefixed returns a bufio.SplitFunc for tokens prefixed with their
+// big endian length, encoded with the given number of bytes.
+func scanLengthPrefixed(size int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) >= size {
+			length := readLength(data[:size])
+			if length > uint64(len(data)-size) {
+				if atEOF {
+					return 0, nil, io.ErrUnexpectedEOF
+				}
+
+				return 0, nil, nil
+			}
+
+			end := size + int(length)
+
+			return end, data[size:end], nil
+		}
+
+		if atEOF && len(data) > 0 {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+
+		return 0, nil, nil
+	}
+}
+
+func frameLengthPrefixed(size int) func([]byte) ([]byte, error) {
+	return func(message []byte) ([]byte, error) {
+		if size < 8 && uint64(len(message)) >= 1<<(8*size) {
+			return nil, fmt.Errorf("message too large for a %d byte length "+
+				"(%d bytes)", size, len(message))
+		}
+
+		framed := make([]byte, size, size+len(message))
+		writeLength(framed, uint64(len(message)))
+
+		return append(framed, message...), nil
+	}
+}
+
+func readLength(b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b))
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+func writeLength(b []byte, length uint64) {
+	switch len(b) {
+	case 1:
+		b[0] = byte(length)
+	case 2:
+		binary.BigEndian.PutUint16(b, uint16(length))
+	case 4:
+		binary.BigEndian.PutUint32(b, uint32(length))
+	default:
+		binary.BigEndian.PutUint64(b, length)
+	}
+}
+
+// scanHexLines is a bufio.SplitFunc for lines of hexadecimal digits. Tokens
+// are the decoded bytes. Whitespace inside lines is ignored.
+func scanHexLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, line, err := bufio.ScanLines(data, atEOF)
+	if err != nil || line == nil {
+		return advance, line, err
+	}
+
+	token, err := decodeHex(line)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return advance, token, nil
+}
+
+func frameHexLine(message []byte) ([]byte, error) {
+	framed := make([]byte, hex.EncodedLen(len(message))+1)
+	hex.Encode(framed, message)
+	framed[len(framed)-1] = '\n'
+
+	return framed, nil
+}
+
+// decodeHex decodes the given hexadecimal digits, ignoring whitespace.
+func decodeHex(digits []byte) ([]byte, error) {
+	digits = bytes.Join(bytes.Fields(digits), nil)
+
+	decoded := make([]byte, hex.DecodedLen(len(digits)))
+	_, err := hex.Decode(decoded, digits)
+	if err != nil {
+		return nil, err
+	}
+
+	return decoded, nil
+}
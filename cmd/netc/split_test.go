@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestNewFraming(t *testing.T) {
+	_, err := newFraming("xml", 4)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, err = newFraming("length", 3)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestFraming(t *testing.T) {
+	messages := [][]byte{
+		[]byte("hello"),
+		{},
+		{0x00, 0xff, 0x7f},
+		bytes.Repeat([]byte("x"), 300),
+	}
+
+	for _, test := range []struct {
+		name       string
+		lengthSize int
+	}{
+		{"lines", 0},
+		{"length", 2},
+		{"length", 4},
+		{"length", 8},
+		{"hex", 0},
+	} {
+		f, err := newFraming(test.name, test.lengthSize)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		var data []byte
+		for _, message := range messages {
+			framed, err := f.Frame(message)
+			if err != nil {
+				t.Fatalf("%s: expected nil error, got %v", test.name, err)
+			}
+
+			data = append(data, framed...)
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Split(f.Split)
+
+		var tokens [][]byte
+		for scanner.Scan() {
+			tokens = append(tokens, append([]byte(nil), scanner.Bytes()...))
+		}
+
+		if scanner.Err() != nil {
+			t.Errorf("%s: expected nil error, got %v", test.name,
+				scanner.Err())
+		}
+
+		if len(tokens) != len(messages) {
+			t.Fatalf("%s: expected %d tokens, got %d", test.name,
+				len(messages), len(tokens))
+		}
+
+		for i := range messages {
+			if !bytes.Equal(tokens[i], messages[i]) {
+				t.Errorf("%s: expected %q, got %q", test.name, messages[i],
+					tokens[i])
+			}
+		}
+	}
+}
+
+func TestFrameLine_Newline(t *testing.T) {
+	_, err := frameLine([]byte("a\nb"))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestFrameLengthPrefixed_TooLarge(t *testing.T) {
+	_, err := frameLengthPrefixed(1)(make([]byte, 256))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, err = frameLengthPrefixed(1)(make([]byte, 255))
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+}
+
+func TestScanLengthPrefixed_Truncated(t *testing.T) {
+	split := scanLengthPrefixed(2)
+
+	advance, token, err := split([]byte{0, 5, 'a'}, false)
+	if advance != 0 || token != nil || err != nil {
+		t.Errorf("expected a request for more data, got %d, %q, %v", advance,
+			token, err)
+	}
+
+	_, _, err = split([]byte{0, 5, 'a'}, true)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestScanHexLines(t *testing.T) {
+	_, token, err := scanHexLines([]byte("de ad be ef\n"), false)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+	if !bytes.Equal(token, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Errorf("unexpected token %x", token)
+	}
+
+	_, _, err = scanHexLines([]byte("xyz\n"), false)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
@@ -0,0 +1,394 @@
+// Command netd runs the classic echo (RFC 862), discard (RFC 863), daytime
+// (RFC 867), chargen (RFC 864) and time (RFC 868) services on top of
+// server.Server. It serves as an example of the server package and as a
+// target for integration tests.
+//
+// Each service listens on every given network. For tcp and udp, it listens
+// at the given host on its well known port plus the given offset (or a random
+// port if the offset is negative). For unix, it listens at <service>.sock in
+// the given directory (replacing sockets left behind by previous runs, but not
+// live ones). Flags enable most features of the server package (see netd
+// -help).
+//
+// Usage:
+//
+//	netd [-services echo,discard,daytime,chargen,time] [-networks tcp,udp]
+//	     [-host 127.0.0.1] [-port-offset 7000] [-unix-dir dir] [flags]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/brunoga/net/capture"
+	"github.com/brunoga/net/fragment"
+	"github.com/brunoga/net/reliable"
+	"github.com/brunoga/net/server"
+)
+
+// config is the netd configuration (usually set from flags).
+type config struct {
+	Services   []string
+	Networks   []string
+	Host       string
+	PortOffset int
+	UnixDir    string
+
+	Acceptors int
+
+	PacketHandler     bool
+	PacketWorkers     int
+	PacketSockets     int
+	PacketBatchSize   int
+	PacketQueueSize   int
+	PacketIdleTimeout time.Duration
+
+	ProxyProtocol        bool
+	ProxyTrustedUpstream []string
+	ProxyHeaderTimeout   time.Duration
+
+	Allow       []string
+	IdleTimeout time.Duration
+	AccessLog   bool
+	Reliable    bool
+	Fragment    bool
+	RecordDir   string
+	Capture     *capture.Writer
+}
+
+func main() {
+	var c config
+	var services, networks, trusted, allow, capturePath string
+
+	flag.StringVar(&services, "services", "echo,discard,daytime,chargen,time",
+		"comma-separated services to run")
+	flag.StringVar(&networks, "networks", "tcp,udp",
+		"comma-separated networks to listen on (tcp, udp, unix)")
+	flag.StringVar(&c.Host, "host", "127.0.0.1", "host to listen at")
+	flag.IntVar(&c.PortOffset, "port-offset", 7000,
+		"offset added to well known ports (negative for random ports)")
+	flag.StringVar(&c.UnixDir, "unix-dir", filepath.Join(os.TempDir(), "netd"),
+		"directory for unix sockets")
+	flag.IntVar(&c.Acceptors, "acceptors", 0,
+		"accept goroutines (and sockets) per tcp server")
+	flag.BoolVar(&c.PacketHandler, "packet-handler", false,
+		"serve udp with stateless packet handlers instead of sessions "+
+			"(middlewares do not apply)")
+	flag.IntVar(&c.PacketWorkers, "packet-workers", 0,
+		"packet handler workers (default number of CPUs)")
+	flag.IntVar(&c.PacketSockets, "packet-sockets", 0,
+		"sockets per packet server (SO_REUSEPORT)")
+	flag.IntVar(&c.PacketBatchSize, "packet-batch", 0,
+		"datagrams read or written per system call")
+	flag.IntVar(&c.PacketQueueSize, "packet-queue", 0,
+		"datagrams queued per packet session")
+	flag.DurationVar(&c.PacketIdleTimeout, "packet-idle-timeout", time.Minute,
+		"idle time after which packet sessions are closed (0 disables)")
+	flag.BoolVar(&c.ProxyProtocol, "proxy-protocol", false,
+		"expect PROXY protocol headers")
+	flag.StringVar(&trusted, "proxy-trusted", "",
+		"comma-separated CIDRs allowed to send PROXY protocol headers "+
+			"(required with -proxy-protocol)")
+	flag.DurationVar(&c.ProxyHeaderTimeout, "proxy-timeout", 5*time.Second,
+		"maximum time to wait for PROXY protocol headers")
+	flag.StringVar(&allow, "allow", "",
+		"comma-separated CIDRs allowed to connect (empty allows all)")
+	flag.DurationVar(&c.IdleTimeout, "idle-timeout", 0,
+		"idle time after which connections are closed (0 disables)")
+	flag.BoolVar(&c.AccessLog, "access-log", false, "log every connection")
+	flag.BoolVar(&c.Reliable, "reliable", false,
+		"use the reliability layer for udp (see package reliable)")
+	flag.BoolVar(&c.Fragment, "fragment", false,
+		"use the fragmentation layer for udp (see package fragment)")
+	flag.StringVar(&c.RecordDir, "record", "",
+		"directory to record connections to (see package record)")
+	flag.StringVar(&capturePath, "capture", "",
+		"pcap file to capture traffic to (see package capture)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 5*time.Second,
+		"maximum time to wait for connections on shutdown")
+
+	flag.Parse()
+
+	c.Services = splitList(services)
+	c.Networks = splitList(networks)
+	c.ProxyTrustedUpstream = splitList(trusted)
+	c.Allow = splitList(allow)
+
+	if capturePath != "" {
+		w, err := capture.Create(capture.Config{Path: capturePath})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+
+		c.Capture = w
+	}
+
+	servers, err := newServers(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = startServers(servers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	<-signalCh
+
+	log.Print("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	for _, s := range servers {
+		err := s.Shutdown(ctx)
+		if err != nil {
+			log.Printf("%s: %v", s.Addr(), err)
+		}
+	}
+}
+
+// splitList splits a comma-separated list, ignoring empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// startServers starts all the given servers. If any of them fails to start,
+// the ones already started are stopped.
+func startServers(servers []*server.Server) error {
+	for i, s := range servers {
+		err := s.Start()
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				servers[j].Stop()
+			}
+
+			return err
+		}
+
+		log.Printf("listening at %s://%s", s.Addr().Network(), s.Addr())
+	}
+
+	return nil
+}
+
+// newServers creates (but does not start) a server for each service and
+// network in the given config.
+func newServers(c config) ([]*server.Server, error) {
+	if len(c.Services) == 0 {
+		return nil, fmt.Errorf("services cannot be empty")
+	}
+
+	if len(c.Networks) == 0 {
+		return nil, fmt.Errorf("networks cannot be empty")
+	}
+
+	var servers []*server.Server
+	for _, name := range c.Services {
+		svc, ok := lookupService(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown service %q", name)
+		}
+
+		for _, network := range c.Networks {
+			s, err := newServer(c, svc, network)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", name, network, err)
+			}
+
+			servers = append(servers, s)
+		}
+	}
+
+	return servers, nil
+}
+
+// newServer creates a server for the given service and network.
+func newServer(c config, svc service, network string) (*server.Server,
+	error) {
+	address, err := serviceAddress(c, svc, network)
+	if err != nil {
+		return nil, err
+	}
+
+	packet := network == "udp"
+
+	var s *server.Server
+	if packet && c.PacketHandler {
+		s, err = server.NewWithPacketHandler(network, address, c.PacketWorkers,
+			func(req []byte, from net.Addr, reply func([]byte) error) {
+				if b := svc.Datagram(req); b != nil {
+					reply(b)
+				}
+			})
+	} else if packet {
+		s, err = server.New(network, address, func(conn net.Conn) {
+			serveDatagrams(conn, svc.Datagram)
+		})
+	} else {
+		s, err = server.New(network, address, svc.Stream)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if packet {
+		err = s.SetPacketIO(server.PacketIOConfig{
+			BatchSize:   c.PacketBatchSize,
+			Sockets:     c.PacketSockets,
+			QueueSize:   c.PacketQueueSize,
+			IdleTimeout: c.PacketIdleTimeout,
+		})
+	} else if network == "tcp" && c.Acceptors > 0 {
+		// SetAcceptors rejects other networks (unix sockets lack
+		// SO_REUSEPORT) and the flag only applies to tcp servers.
+		err = s.SetAcceptors(c.Acceptors)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.ProxyProtocol {
+		err = s.EnableProxyProtocol(c.ProxyTrustedUpstream,
+			c.ProxyHeaderTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	middlewares, err := serviceMiddlewares(c, svc, packet)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Use(middlewares...)
+
+	return s, nil
+}
+
+// serviceAddress returns the address the given service listens at for the
+// given network.
+func serviceAddress(c config, svc service, network string) (string, error) {
+	switch network {
+	case "tcp", "udp":
+		port := 0
+		if c.PortOffset >= 0 {
+			port = svc.Port + c.PortOffset
+		}
+
+		return net.JoinHostPort(c.Host, strconv.Itoa(port)), nil
+	case "unix":
+		err := os.MkdirAll(c.UnixDir, 0755)
+		if err != nil {
+			return "", err
+		}
+
+		path := filepath.Join(c.UnixDir, svc.Name+".sock")
+
+		err = removeStaleSocket(path)
+		if err != nil {
+			return "", err
+		}
+
+		return path, nil
+	}
+
+	return "", fmt.Errorf("unsupported network %q", network)
+}
+
+// removeStaleSocket removes the unix socket at the given path if it was left
+// behind by a previous run. It returns an error if another process is still
+// serving at it.
+func removeStaleSocket(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+
+	return os.Remove(path)
+}
+
+// serviceMiddlewares returns the middlewares enabled in the given config.
+// Middlewares closer to the network come first.
+func serviceMiddlewares(c config, svc service,
+	packet bool) ([]server.Middleware, error) {
+	middlewares := []server.Middleware{
+		server.Recovery(func(conn net.Conn, r interface{}) {
+			log.Printf("%s: panic handling %s: %v", svc.Name, conn.RemoteAddr(),
+				r)
+		}),
+	}
+
+	if len(c.Allow) > 0 {
+		allowlist, err := server.IPAllowlist(c.Allow...)
+		if err != nil {
+			return nil, err
+		}
+
+		middlewares = append(middlewares, allowlist)
+	}
+
+	if c.AccessLog {
+		middlewares = append(middlewares, server.AccessLog(nil))
+	}
+
+	if c.Capture != nil {
+		middlewares = append(middlewares, server.Capture(c.Capture))
+	}
+
+	if packet && c.Reliable {
+		middlewares = append(middlewares, server.Reliable(reliable.Config{}))
+	}
+
+	if packet && c.Fragment {
+		middlewares = append(middlewares,
+			server.Fragmentation(fragment.Config{}))
+	}
+
+	if c.IdleTimeout > 0 {
+		middlewares = append(middlewares, server.IdleTimeout(c.IdleTimeout))
+	}
+
+	if c.RecordDir != "" {
+		err := os.MkdirAll(c.RecordDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+
+		middlewares = append(middlewares, server.Record(
+			func(conn net.Conn) (io.WriteCloser, error) {
+				return os.CreateTemp(c.RecordDir, fmt.Sprintf("%s-%s-*.rec",
+					svc.Name, conn.LocalAddr().Network()))
+			}))
+	}
+
+	return middlewares, nil
+}
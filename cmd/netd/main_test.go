@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/brunoga/net/server"
+)
+
+func TestNewServers(t *testing.T) {
+	_, err := newServers(config{Networks: []string{"tcp"}})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, err = newServers(config{Services: []string{"echo"}})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, err = newServers(config{
+		Services: []string{"qotd"},
+		Networks: []string{"tcp"},
+	})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, err = newServers(config{
+		Services: []string{"echo"},
+		Networks: []string{"sctp"},
+	})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	servers, err := newServers(config{
+		Services: []string{"echo", "time"},
+		Networks: []string{"tcp", "udp"},
+	})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+	if len(servers) != 4 {
+		t.Errorf("expected 4 servers, got %d", len(servers))
+	}
+}
+
+func TestServiceAddress_Unix(t *testing.T) {
+	c := config{UnixDir: t.TempDir()}
+	svc, _ := lookupService("echo")
+
+	path, err := serviceAddress(c, svc, "unix")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// A live socket must not be removed.
+	_, err = serviceAddress(c, svc, "unix")
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	// A stale one must.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	_, err = serviceAddress(c, svc, "unix")
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected stale socket to be removed, got %v", err)
+	}
+}
+
+func TestServices(t *testing.T) {
+	c := config{
+		Services:   []string{"echo", "discard", "daytime", "chargen", "time"},
+		Networks:   []string{"tcp", "udp", "unix"},
+		Host:       "127.0.0.1",
+		PortOffset: -1,
+		UnixDir:    t.TempDir(),
+	}
+
+	t.Run("Sessions", func(t *testing.T) {
+		testServices(t, c)
+	})
+
+	c.PacketHandler = true
+	c.UnixDir = t.TempDir()
+	t.Run("PacketHandler", func(t *testing.T) {
+		testServices(t, c)
+	})
+}
+
+func testServices(t *testing.T, c config) {
+	servers, err := newServers(c)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = startServers(servers)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer func() {
+		for _, s := range servers {
+			s.Stop()
+		}
+	}()
+
+	i := 0
+	for _, name := range c.Services {
+		for _, network := range c.Networks {
+			s := servers[i]
+			i++
+
+			t.Run(name+"/"+network, func(t *testing.T) {
+				conn, err := dial(s)
+				if err != nil {
+					t.Fatalf("expected nil error, got %v", err)
+				}
+				defer conn.Close()
+
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+				checkService(t, name, network == "udp", conn)
+			})
+		}
+	}
+
+	if c.PacketHandler {
+		return
+	}
+
+	// Unix sockets are removed on Stop.
+	for _, s := range servers {
+		if addr := s.Addr(); addr.Network() == "unix" {
+			s.Stop()
+
+			_, err := net.Dial("unix", addr.String())
+			if err == nil {
+				t.Error("expected non-nil error, got nil")
+			}
+		}
+	}
+}
+
+func dial(s *server.Server) (net.Conn, error) {
+	addr := s.Addr()
+
+	return net.Dial(addr.Network(), addr.String())
+}
+
+func checkService(t *testing.T, name string, packet bool, conn net.Conn) {
+	request := []byte("hello")
+	if packet || name == "echo" || name == "discard" {
+		_, err := conn.Write(request)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+	}
+
+	buf := make([]byte, 1024)
+	switch name {
+	case "echo":
+		_, err := io.ReadFull(conn, buf[:len(request)])
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		if !bytes.Equal(buf[:len(request)], request) {
+			t.Errorf("expected %q, got %q", request, buf[:len(request)])
+		}
+	case "discard":
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+		n, err := conn.Read(buf)
+		if n != 0 || err == io.EOF {
+			t.Errorf("expected no data and a timeout, got %d bytes (%v)", n,
+				err)
+		}
+	case "daytime":
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		if !bytes.HasSuffix(buf[:n], []byte("\r\n")) {
+			t.Errorf("expected CRLF terminated line, got %q", buf[:n])
+		}
+	case "chargen":
+		if packet {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			if n > maxChargenDatagramSize {
+				t.Errorf("expected at most %d bytes, got %d",
+					maxChargenDatagramSize, n)
+			}
+
+			return
+		}
+
+		_, err := io.ReadFull(conn, buf[:148])
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		expected := append(chargenLine(0), chargenLine(1)...)
+		if !bytes.Equal(buf[:148], expected) {
+			t.Errorf("expected %q, got %q", expected, buf[:148])
+		}
+	case "time":
+		_, err := io.ReadFull(conn, buf[:4])
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		seconds := int64(binary.BigEndian.Uint32(buf)) - timeEpochOffset
+		if d := time.Since(time.Unix(seconds, 0)); d < -time.Second ||
+			d > time.Minute {
+			t.Errorf("unexpected time %v", time.Unix(seconds, 0))
+		}
+	}
+}
+
+func TestChargenLine(t *testing.T) {
+	line := chargenLine(0)
+	if string(line[:4]) != ` !"#` || len(line) != 74 {
+		t.Errorf("unexpected line %q", line)
+	}
+
+	line = chargenLine(94)
+	if string(line[:3]) != `~ !` {
+		t.Errorf("unexpected line %q", line)
+	}
+}
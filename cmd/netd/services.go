@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	// Size of the buffer used to read datagrams.
+	datagramBufferSize = 65536
+
+	// Maximum size of chargen datagrams (RFC 864).
+	maxChargenDatagramSize = 512
+
+	// Seconds between 1900-01-01 (the time protocol epoch) and 1970-01-01.
+	timeEpochOffset = 2208988800
+)
+
+// service is one of the classic "simple" internet services. Each one has a
+// stream form (for tcp and unix) and a datagram form (for udp).
+type service struct {
+	// Name of the service.
+	Name string
+
+	// Port is the well known port of the service.
+	Port int
+
+	// Stream handles a stream connection.
+	Stream func(conn net.Conn)
+
+	// Datagram returns the reply to the given request datagram or nil if
+	// there is no reply.
+	Datagram func(req []byte) []byte
+}
+
+// services are all the supported services, in the order they are started.
+var services = []service{
+	{"echo", 7, echoStream, echoDatagram},
+	{"discard", 9, discardStream, discardDatagram},
+	{"daytime", 13, daytimeStream, daytimeDatagram},
+	{"chargen", 19, chargenStream, chargenDatagram},
+	{"time", 37, timeStream, timeDatagram},
+}
+
+// lookupService returns the service with the given name.
+func lookupService(name string) (service, bool) {
+	for _, s := range services {
+		if s.Name == name {
+			return s, true
+		}
+	}
+
+	return service{}, false
+}
+
+// serveDatagrams handles a packet session by replying to each datagram read
+// from the given conn with the given datagram function.
+func serveDatagrams(conn net.Conn, datagram func(req []byte) []byte) {
+	defer conn.Close()
+
+	buf := make([]byte, datagramBufferSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		reply := datagram(buf[:n])
+		if reply == nil {
+			continue
+		}
+
+		_, err = conn.Write(reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// echoStream sends back all data received (RFC 862).
+func echoStream(conn net.Conn) {
+	io.Copy(conn, conn)
+	conn.Close()
+}
+
+func echoDatagram(req []byte) []byte {
+	return append([]byte(nil), req...)
+}
+
+// discardStream throws away all data received (RFC 863).
+func discardStream(conn net.Conn) {
+	io.Copy(io.Discard, conn)
+	conn.Close()
+}
+
+func discardDatagram([]byte) []byte {
+	return nil
+}
+
+// daytimeStream sends the current date and time as text and closes the
+// connection (RFC 867).
+func daytimeStream(conn net.Conn) {
+	conn.Write(daytime(time.Now()))
+	conn.Close()
+}
+
+func daytimeDatagram([]byte) []byte {
+	return daytime(time.Now())
+}
+
+func daytime(t time.Time) []byte {
+	return []byte(t.Format("Monday, January 2, 2006 15:04:05-MST") + "\r\n")
+}
+
+// chargenStream sends lines of characters until the connection is closed
+// (RFC 864). Received data is discarded.
+func chargenStream(conn net.Conn) {
+	defer conn.Close()
+
+	go io.Copy(io.Discard, conn)
+
+	var line int
+	for {
+		_, err := conn.Write(chargenLine(line))
+		if err != nil {
+			return
+		}
+
+		line++
+	}
+}
+
+// chargenDatagram replies with a random number (up to 512) of characters.
+func chargenDatagram([]byte) []byte {
+	reply := make([]byte, 0, maxChargenDatagramSize)
+	for line := 0; len(reply) < maxChargenDatagramSize; line++ {
+		reply = append(reply, chargenLine(line)...)
+	}
+
+	return reply[:rand.Intn(maxChargenDatagramSize+1)]
+}
+
+// chargenLine returns the given line of the chargen pattern: 72 printable
+// ASCII characters, starting one character later than the previous line,
+// followed by CRLF.
+func chargenLine(line int) []byte {
+	const first, count, lineSize = ' ', '~' - ' ' + 1, 72
+
+	b := make([]byte, 0, lineSize+2)
+	for i := 0; i < lineSize; i++ {
+		b = append(b, byte(first+(line+i)%count))
+	}
+
+	return append(b, '\r', '\n')
+}
+
+// timeStream sends the current time as the number of seconds since
+// 1900-01-01 and closes the connection (RFC 868).
+func timeStream(conn net.Conn) {
+	conn.Write(timeSeconds(time.Now()))
+	conn.Close()
+}
+
+func timeDatagram([]byte) []byte {
+	return timeSeconds(time.Now())
+}
+
+func timeSeconds(t time.Time) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()+timeEpochOffset))
+
+	return b
+}
@@ -0,0 +1,86 @@
+// Command netload runs load tests against an echo server (by default, a
+// server.Server started in-process) and prints the results, one per network,
+// as JSON lines or as text.
+//
+// Usage:
+//
+//	netload [-network tcp,udp,unix] [-address addr] [-clients n]
+//	        [-duration d] [-size n] [-rate n] [-format json|text]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brunoga/net/load"
+)
+
+var (
+	network = flag.String("network", "tcp",
+		"comma-separated networks to test (tcp, udp, unix)")
+	address = flag.String("address", "",
+		"echo server address (empty starts an in-process server)")
+	clients  = flag.Int("clients", 10, "number of concurrent clients")
+	duration = flag.Duration("duration", 5*time.Second, "duration of each run")
+	size     = flag.Int("size", 64, "message size in bytes")
+	rate     = flag.Int("rate", 0,
+		"messages per second per client (0 waits for each reply)")
+	format = flag.String("format", "json", "output format (json or text)")
+)
+
+func main() {
+	flag.Parse()
+
+	if *format != "json" && *format != "text" {
+		fmt.Fprintf(os.Stderr, "unsupported format %q\n", *format)
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, n := range strings.Split(*network, ",") {
+		result, err := load.Run(load.Config{
+			Network:     strings.TrimSpace(n),
+			Address:     *address,
+			Clients:     *clients,
+			Duration:    *duration,
+			MessageSize: *size,
+			Rate:        *rate,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", n, err)
+			failed = true
+			continue
+		}
+
+		if *format == "json" {
+			json.NewEncoder(os.Stdout).Encode(result)
+		} else {
+			printText(result)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func printText(r *load.Result) {
+	fmt.Printf("%s: %d clients, %d byte messages, %v\n", r.Network,
+		r.Clients, r.MessageSize, r.Duration)
+	fmt.Printf("  messages: %d sent, %d received, %d errors\n", r.Sent,
+		r.Received, r.Errors)
+	fmt.Printf("  throughput: %.0f msgs/s, %.0f bytes/s\n",
+		r.MessagesPerSecond, r.BytesPerSecond)
+	fmt.Printf("  latency: mean %v, p50 %v, p90 %v, p99 %v, p99.9 %v, "+
+		"max %v\n", r.Latency.Mean, r.Latency.P50, r.Latency.P90,
+		r.Latency.P99, r.Latency.P999, r.Latency.Max)
+	fmt.Printf("  goroutines: %d start, %d peak, %d end\n",
+		r.Usage.GoroutinesStart, r.Usage.GoroutinesPeak,
+		r.Usage.GoroutinesEnd)
+	fmt.Printf("  memory: %d bytes peak heap, %d bytes allocated, %d GCs\n",
+		r.Usage.HeapPeak, r.Usage.TotalAlloc, r.Usage.NumGC)
+}
@@ -0,0 +1,258 @@
+package fragment
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Memory used by each fragment slot of an incomplete message, even before
+// the fragment is received.
+const fragmentSlotSize = int(unsafe.Sizeof([]byte(nil)))
+
+// Conn is a net.Conn that fragments and reassembles messages sent over a
+// datagram connection. Each Write sends one message and each message is
+// returned by Read as a whole (if the given buffer is smaller than the
+// message, the rest is returned by the next reads). Deadlines and Close are
+// handled by the underlying connection.
+type Conn struct {
+	// Accessed atomically. Kept first for 64-bit alignment.
+	dropped uint64
+
+	net.Conn
+
+	config Config
+	nextID uint32 // Accessed atomically.
+
+	readM        sync.Mutex
+	buffer       []byte
+	remainder    []byte              // nil if there is no message being read.
+	pending      map[uint32]*message // Incomplete messages by ID.
+	order        []*message          // Incomplete messages, oldest first.
+	pendingBytes int
+}
+
+// message is a message being reassembled.
+type message struct {
+	id        uint32
+	fragments [][]byte
+	received  int
+	size      int // Payload bytes received.
+	cost      int // Bytes charged to Conn.pendingBytes.
+	created   time.Time
+	done      bool // Completed or discarded.
+}
+
+// Wrap returns a new Conn that uses the given datagram connection.
+func Wrap(conn net.Conn, config Config) *Conn {
+	config = config.withDefaults()
+
+	return &Conn{
+		Conn:    conn,
+		config:  config,
+		buffer:  make([]byte, 65536),
+		pending: make(map[uint32]*message),
+	}
+}
+
+// Read reads data from the next message (or from the rest of the current
+// one). Incomplete messages do not unblock it.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readM.Lock()
+	defer c.readM.Unlock()
+
+	for c.remainder == nil {
+		n, err := c.Conn.Read(c.buffer)
+		if err != nil {
+			return 0, err
+		}
+
+		c.remainder = c.handleFragment(c.buffer[:n], time.Now())
+	}
+
+	n := copy(b, c.remainder)
+	c.remainder = c.remainder[n:]
+	if len(c.remainder) == 0 {
+		c.remainder = nil
+	}
+
+	return n, nil
+}
+
+// Write sends b as a single message, fragmenting it as needed. Messages
+// larger than Config.MaxMessageSize are rejected.
+func (c *Conn) Write(b []byte) (int, error) {
+	if len(b) > c.config.MaxMessageSize {
+		return 0, fmt.Errorf("message too large (%d > %d bytes)", len(b),
+			c.config.MaxMessageSize)
+	}
+
+	payloadSize := c.config.MTU - headerSize
+	count := (len(b) + payloadSize - 1) / payloadSize
+	if count == 0 {
+		count = 1
+	}
+
+	id := atomic.AddUint32(&c.nextID, 1)
+	datagram := make([]byte, c.config.MTU)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * payloadSize
+		if end > len(b) {
+			end = len(b)
+		}
+
+		header{id, uint16(i), uint16(count)}.encode(datagram)
+		n := copy(datagram[headerSize:], b[i*payloadSize:end])
+
+		_, err := c.Conn.Write(datagram[:headerSize+n])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// MaxMessageSize returns the maximum size of messages sent and received by
+// this Conn.
+func (c *Conn) MaxMessageSize() int {
+	return c.config.MaxMessageSize
+}
+
+// DroppedMessages returns the number of incomplete messages discarded so far
+// because they timed out or to free memory.
+func (c *Conn) DroppedMessages() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// handleFragment processes the given datagram and returns the message it
+// completes, if any (non-nil even for empty messages).
+func (c *Conn) handleFragment(datagram []byte, now time.Time) []byte {
+	c.expire(now)
+
+	h, err := decodeHeader(datagram)
+	if err != nil {
+		return nil
+	}
+
+	payload := datagram[headerSize:]
+	if h.count == 1 {
+		return append([]byte{}, payload...)
+	}
+
+	// All fragments but the last one are full.
+	payloadSize := c.config.MTU - headerSize
+	if int(h.count-1)*payloadSize >= c.config.MaxMessageSize ||
+		len(payload) > payloadSize ||
+		(h.index < h.count-1 && len(payload) != payloadSize) {
+		return nil
+	}
+
+	m, ok := c.pending[h.id]
+	if !ok {
+		cost := int(h.count) * fragmentSlotSize
+		if cost > c.config.MaxPendingBytes {
+			return nil
+		}
+
+		for len(c.pending) >= c.config.MaxPendingMessages {
+			c.discardOldest()
+		}
+
+		c.makeRoom(cost)
+
+		m = &message{
+			id:        h.id,
+			fragments: make([][]byte, h.count),
+			cost:      cost,
+			created:   now,
+		}
+
+		c.pending[h.id] = m
+		c.order = append(c.order, m)
+		c.pendingBytes += cost
+	}
+
+	if len(m.fragments) != int(h.count) || m.fragments[h.index] != nil {
+		// Inconsistent or duplicate fragment.
+		return nil
+	}
+
+	c.makeRoom(len(payload))
+	if m.done {
+		// Discarded to make room.
+		return nil
+	}
+
+	m.fragments[h.index] = append([]byte(nil), payload...)
+	m.received++
+	m.size += len(payload)
+	m.cost += len(payload)
+	c.pendingBytes += len(payload)
+
+	if m.received < len(m.fragments) {
+		return nil
+	}
+
+	data := make([]byte, 0, m.size)
+	for _, fragment := range m.fragments {
+		data = append(data, fragment...)
+	}
+
+	c.remove(m)
+
+	return data
+}
+
+// expire discards incomplete messages older than the reassembly timeout.
+func (c *Conn) expire(now time.Time) {
+	for len(c.order) > 0 {
+		m := c.order[0]
+		if !m.done && now.Sub(m.created) < c.config.ReassemblyTimeout {
+			break
+		}
+
+		if !m.done {
+			c.remove(m)
+			atomic.AddUint64(&c.dropped, 1)
+		}
+
+		c.order[0] = nil
+		c.order = c.order[1:]
+	}
+}
+
+// makeRoom discards the oldest incomplete messages until n more bytes fit in
+// the pending limit.
+func (c *Conn) makeRoom(n int) {
+	for len(c.order) > 0 && c.pendingBytes+n > c.config.MaxPendingBytes {
+		c.discardOldest()
+	}
+}
+
+// discardOldest discards the oldest incomplete message (if it was not
+// already completed or discarded).
+func (c *Conn) discardOldest() {
+	m := c.order[0]
+	if !m.done {
+		c.remove(m)
+		atomic.AddUint64(&c.dropped, 1)
+	}
+
+	c.order[0] = nil
+	c.order = c.order[1:]
+}
+
+// remove removes the given message from the pending ones.
+func (c *Conn) remove(m *message) {
+	m.done = true
+	m.fragments = nil
+	c.pendingBytes -= m.cost
+
+	if c.pending[m.id] == m {
+		delete(c.pending, m.id)
+	}
+}
@@ -0,0 +1,223 @@
+package fragment
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// datagramConn is an in-memory datagram net.Conn that records written
+// datagrams and returns queued ones on Read.
+type datagramConn struct {
+	net.Conn // Unused methods panic.
+
+	written [][]byte
+	toRead  [][]byte
+}
+
+func (c *datagramConn) Read(b []byte) (int, error) {
+	if len(c.toRead) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, c.toRead[0])
+	c.toRead = c.toRead[1:]
+
+	return n, nil
+}
+
+func (c *datagramConn) Write(b []byte) (int, error) {
+	c.written = append(c.written, append([]byte(nil), b...))
+
+	return len(b), nil
+}
+
+// fragments writes data with a new Conn using the given config and returns the
+// datagrams it sent.
+func fragments(t *testing.T, config Config, data ...[]byte) [][]byte {
+	conn := &datagramConn{}
+	c := Wrap(conn, config)
+
+	for _, message := range data {
+		_, err := c.Write(message)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+	}
+
+	return conn.written
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+
+	return data
+}
+
+func TestConn_Reassembly(t *testing.T) {
+	config := Config{MTU: 100}
+	messages := [][]byte{randomData(1000), {}, randomData(92),
+		randomData(93)}
+
+	datagrams := fragments(t, config, messages...)
+	if len(datagrams) != 11+1+1+2 {
+		t.Errorf("expected 15 datagrams, got %d", len(datagrams))
+	}
+
+	for _, datagram := range datagrams {
+		if len(datagram) > config.MTU {
+			t.Errorf("expected at most %d bytes, got %d", config.MTU,
+				len(datagram))
+		}
+	}
+
+	// Reverse the order of the fragments of the first message.
+	for i, j := 0, 10; i < j; i, j = i+1, j-1 {
+		datagrams[i], datagrams[j] = datagrams[j], datagrams[i]
+	}
+
+	c := Wrap(&datagramConn{toRead: datagrams}, config)
+
+	buffer := make([]byte, 2000)
+	for _, message := range messages {
+		n, err := c.Read(buffer)
+		if err != nil || !bytes.Equal(buffer[:n], message) {
+			t.Errorf("expected %d bytes message, got %d (%v)", len(message),
+				n, err)
+		}
+	}
+
+	_, err := c.Read(buffer)
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestConn_ShortRead(t *testing.T) {
+	message := randomData(250)
+	c := Wrap(&datagramConn{toRead: fragments(t, Config{MTU: 100},
+		message)}, Config{MTU: 100})
+
+	data, err := io.ReadAll(c)
+	if err != nil || !bytes.Equal(data, message) {
+		t.Errorf("expected %d bytes, got %d (%v)", len(message), len(data),
+			err)
+	}
+}
+
+func TestConn_MaxMessageSize(t *testing.T) {
+	config := Config{MTU: 100, MaxMessageSize: 500}
+
+	c := Wrap(&datagramConn{}, config)
+
+	_, err := c.Write(randomData(501))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	// Fragments of messages that are too large are dropped.
+	datagrams := fragments(t, Config{MTU: 100}, randomData(1000),
+		randomData(10))
+	c = Wrap(&datagramConn{toRead: datagrams}, config)
+
+	n, err := c.Read(make([]byte, 1000))
+	if err != nil || n != 10 {
+		t.Errorf("expected 10 bytes, got %d (%v)", n, err)
+	}
+}
+
+func TestConn_ReassemblyTimeout(t *testing.T) {
+	config := Config{MTU: 100, ReassemblyTimeout: time.Minute}
+	lost := fragments(t, config, randomData(500))
+	complete := fragments(t, config, randomData(50))
+
+	c := Wrap(&datagramConn{}, config)
+
+	now := time.Now()
+	if c.handleFragment(lost[0], now) != nil {
+		t.Fatal("expected incomplete message")
+	}
+
+	if c.pendingBytes == 0 || c.DroppedMessages() != 0 {
+		t.Errorf("expected pending message, got %d bytes (%d dropped)",
+			c.pendingBytes, c.DroppedMessages())
+	}
+
+	if c.handleFragment(complete[0], now.Add(2*time.Minute)) == nil {
+		t.Fatal("expected complete message")
+	}
+
+	if c.pendingBytes != 0 || c.DroppedMessages() != 1 {
+		t.Errorf("expected expired message, got %d bytes (%d dropped)",
+			c.pendingBytes, c.DroppedMessages())
+	}
+}
+
+func TestConn_MaxPendingBytes(t *testing.T) {
+	config := Config{MTU: 100, MaxPendingBytes: 500}
+
+	// 6 fragments per message (each one using 6 fragment slots and 92 bytes
+	// once its first fragment is received).
+	datagrams := fragments(t, config, randomData(500), randomData(500),
+		randomData(500))
+
+	// Only the first fragment of each message.
+	c := Wrap(&datagramConn{}, config)
+	for i := 0; i < len(datagrams); i += 6 {
+		c.handleFragment(datagrams[i], time.Now())
+	}
+
+	if c.pendingBytes > config.MaxPendingBytes || c.DroppedMessages() != 1 {
+		t.Errorf("expected 1 dropped message, got %d bytes (%d dropped)",
+			c.pendingBytes, c.DroppedMessages())
+	}
+}
+
+func TestConn_MaxPendingMessages(t *testing.T) {
+	config := Config{MTU: 100, MaxPendingMessages: 2}
+
+	datagrams := fragments(t, config, randomData(500), randomData(500),
+		randomData(500))
+
+	// Only the first fragment of each message.
+	c := Wrap(&datagramConn{}, config)
+	for i := 0; i < len(datagrams); i += 6 {
+		c.handleFragment(datagrams[i], time.Now())
+	}
+
+	if len(c.pending) != 2 || c.DroppedMessages() != 1 {
+		t.Errorf("expected 2 pending and 1 dropped messages, got %d (%d "+
+			"dropped)", len(c.pending), c.DroppedMessages())
+	}
+}
+
+func TestConn_ShortFragment(t *testing.T) {
+	config := Config{MTU: 100}
+
+	datagrams := fragments(t, config, randomData(500))
+
+	// Non-final fragments must be full.
+	c := Wrap(&datagramConn{}, config)
+	if c.handleFragment(datagrams[0][:50], time.Now()) != nil ||
+		len(c.pending) != 0 {
+		t.Errorf("expected short fragment to be dropped, got %d pending "+
+			"messages", len(c.pending))
+	}
+
+	// Many tiny final fragments of different messages are bounded.
+	for id := uint32(1); id <= 1000; id++ {
+		datagram := make([]byte, headerSize+1)
+		header{id, 7, 8}.encode(datagram)
+		c.handleFragment(datagram, time.Now())
+	}
+
+	if c.pendingBytes > c.config.MaxPendingBytes ||
+		len(c.pending) > c.config.MaxPendingMessages {
+		t.Errorf("expected bounded pending messages, got %d (%d bytes)",
+			len(c.pending), c.pendingBytes)
+	}
+}
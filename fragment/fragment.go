@@ -0,0 +1,112 @@
+// Package fragment implements an optional fragmentation layer on top of
+// datagram connections (for example, UDP sockets or the "fake" connections
+// created by server.Server for packet networks), so messages larger than the
+// path MTU can be sent.
+//
+// Each message written to a Conn is split into datagrams of at most
+// Config.MTU bytes, each one tagged with a message ID and its fragment index.
+// The receiving side reassembles messages and delivers each one as a whole.
+// Delivery is still unreliable: if any fragment is lost, the whole message is
+// discarded after Config.ReassemblyTimeout. Memory used by incomplete
+// messages is bounded by Config.MaxPendingBytes and
+// Config.MaxPendingMessages.
+//
+// Both sides of a connection must use this package. The underlying connection
+// must preserve datagram boundaries.
+package fragment
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Header: message ID (4) | fragment index (2) | fragment count (2).
+const headerSize = 8
+
+const maxFragments = 1<<16 - 1
+
+// Config controls the behavior of a Conn. Zero fields use their defaults.
+type Config struct {
+	// MTU is the maximum size of each datagram sent, including the fragment
+	// header. Defaults to 1200 (which keeps datagrams under the usual
+	// Internet MTU).
+	MTU int
+
+	// MaxMessageSize is the maximum size of a message. Writing larger
+	// messages fails and fragments of larger messages are dropped. Defaults
+	// to 1MiB.
+	MaxMessageSize int
+
+	// ReassemblyTimeout is how long fragments of an incomplete message are
+	// kept. Defaults to 5s.
+	ReassemblyTimeout time.Duration
+
+	// MaxPendingBytes is the maximum number of bytes used by incomplete
+	// messages. The oldest ones are discarded to make room for new ones.
+	// Defaults to 4MiB. It includes the bookkeeping of each fragment slot.
+	MaxPendingBytes int
+
+	// MaxPendingMessages is the maximum number of incomplete messages. The
+	// oldest ones are discarded to make room for new ones. Defaults to 64.
+	MaxPendingMessages int
+}
+
+func (c Config) withDefaults() Config {
+	if c.MTU <= headerSize {
+		c.MTU = 1200
+	}
+
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = 1 << 20
+	}
+
+	if max := maxFragments * (c.MTU - headerSize); c.MaxMessageSize > max {
+		c.MaxMessageSize = max
+	}
+
+	if c.ReassemblyTimeout <= 0 {
+		c.ReassemblyTimeout = 5 * time.Second
+	}
+
+	if c.MaxPendingBytes <= 0 {
+		c.MaxPendingBytes = 4 << 20
+	}
+
+	if c.MaxPendingMessages <= 0 {
+		c.MaxPendingMessages = 64
+	}
+
+	return c
+}
+
+type header struct {
+	id    uint32
+	index uint16
+	count uint16
+}
+
+func (h header) encode(b []byte) {
+	binary.BigEndian.PutUint32(b, h.id)
+	binary.BigEndian.PutUint16(b[4:], h.index)
+	binary.BigEndian.PutUint16(b[6:], h.count)
+}
+
+func decodeHeader(b []byte) (header, error) {
+	if len(b) < headerSize {
+		return header{}, fmt.Errorf("short fragment (%d bytes)", len(b))
+	}
+
+	h := header{
+		id:    binary.BigEndian.Uint32(b),
+		index: binary.BigEndian.Uint16(b[4:]),
+		count: binary.BigEndian.Uint16(b[6:]),
+	}
+
+	if h.count == 0 || h.index >= h.count {
+		return header{}, fmt.Errorf("invalid fragment %d/%d", h.index,
+			h.count)
+	}
+
+	return h, nil
+}
@@ -1,3 +1,8 @@
 module github.com/brunoga/net
 
 go 1.18
+
+require (
+	golang.org/x/net v0.35.0
+	golang.org/x/sys v0.30.0
+)
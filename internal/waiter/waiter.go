@@ -0,0 +1,55 @@
+// Package waiter provides a condition variable that supports deadlines.
+package waiter
+
+import (
+	"sync"
+	"time"
+)
+
+// Waiter lets goroutines wait for a state change protected by a lock, like
+// sync.Cond, but optionally with a deadline. All methods must be called with
+// the lock held. The zero value is ready to use.
+type Waiter struct {
+	ch chan struct{} // Closed (and replaced) when the state changes.
+}
+
+// Notify wakes up all goroutines waiting for a state change.
+func (w *Waiter) Notify() {
+	if w.ch != nil {
+		close(w.ch)
+		w.ch = nil
+	}
+}
+
+// Wait releases the given lock and waits for a state change or for the given
+// deadline (if not zero) to expire. It returns with the lock held and false
+// if the deadline expired.
+func (w *Waiter) Wait(l sync.Locker, deadline time.Time) bool {
+	var timeoutCh <-chan time.Time
+	if !deadline.IsZero() {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return false
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		timeoutCh = timer.C
+	}
+
+	if w.ch == nil {
+		w.ch = make(chan struct{})
+	}
+	ch := w.ch
+
+	l.Unlock()
+	defer l.Lock()
+
+	select {
+	case <-ch:
+		return true
+	case <-timeoutCh:
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package waiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaiter_Notify(t *testing.T) {
+	var m sync.Mutex
+	var w Waiter
+
+	ready := false
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+
+		m.Lock()
+		defer m.Unlock()
+
+		ready = true
+		w.Notify()
+	}()
+
+	m.Lock()
+	defer m.Unlock()
+
+	for !ready {
+		if !w.Wait(&m, time.Time{}) {
+			t.Fatal("expected true, got false")
+		}
+	}
+}
+
+func TestWaiter_Deadline(t *testing.T) {
+	var m sync.Mutex
+	var w Waiter
+
+	m.Lock()
+	defer m.Unlock()
+
+	if w.Wait(&m, time.Now().Add(-time.Second)) {
+		t.Error("expected false for expired deadline, got true")
+	}
+
+	start := time.Now()
+	if w.Wait(&m, start.Add(10*time.Millisecond)) {
+		t.Error("expected false, got true")
+	}
+
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("expected to wait for the deadline, returned after %v",
+			elapsed)
+	}
+
+	// Notifying without waiters must not panic.
+	w.Notify()
+	w.Notify()
+}
@@ -0,0 +1,454 @@
+// Package load implements a load generator that measures the behavior of a
+// server.Server (or any echo server) under load. It runs many client.Client
+// instances that send fixed size messages and measures the time until each
+// message is echoed back.
+package load
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunoga/net/client"
+	"github.com/brunoga/net/server"
+)
+
+const (
+	defaultClients     = 10
+	defaultDuration    = 5 * time.Second
+	defaultMessageSize = 64
+
+	// Minimum message size (messages start with their send time).
+	minMessageSize = 8
+
+	// How long a client waits for a reply before sending the next message
+	// (replies might be lost for packet networks).
+	replyTimeout = time.Second
+
+	// How often goroutines and memory usage are sampled.
+	sampleInterval = 100 * time.Millisecond
+
+	// How long to wait for the handlers of a started server to return.
+	shutdownTimeout = time.Second
+)
+
+// Config is the configuration for a load run.
+type Config struct {
+	// Network to use ("tcp", "udp" or "unix").
+	Network string
+
+	// Address of the echo server. If empty, a server.Server echo server is
+	// started (and stopped) by Run, in which case its goroutines and memory
+	// usage are included in the Result.
+	Address string
+
+	// Clients is the number of concurrent clients. Defaults to 10.
+	Clients int
+
+	// Duration of the run. Defaults to 5s.
+	Duration time.Duration
+
+	// MessageSize is the size of each message in bytes (at least 8).
+	// Defaults to 64.
+	MessageSize int
+
+	// Rate is the number of messages per second sent by each client. If 0,
+	// each client sends a message as soon as the reply to the previous one
+	// is received (or it times out).
+	Rate int
+}
+
+func (c Config) withDefaults() Config {
+	if c.Clients <= 0 {
+		c.Clients = defaultClients
+	}
+
+	if c.Duration <= 0 {
+		c.Duration = defaultDuration
+	}
+
+	if c.MessageSize <= 0 {
+		c.MessageSize = defaultMessageSize
+	}
+
+	return c
+}
+
+// Latency is the distribution of message round-trip times.
+type Latency struct {
+	Mean time.Duration `json:"mean_ns"`
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	P999 time.Duration `json:"p999_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// Usage is the goroutine and memory usage of the process during a run.
+type Usage struct {
+	GoroutinesStart int `json:"goroutines_start"`
+	GoroutinesPeak  int `json:"goroutines_peak"`
+	GoroutinesEnd   int `json:"goroutines_end"`
+
+	// Peak bytes of allocated heap objects.
+	HeapPeak uint64 `json:"heap_peak_bytes"`
+
+	// Bytes allocated (even if freed) during the run.
+	TotalAlloc uint64 `json:"total_alloc_bytes"`
+
+	// Number of garbage collections during the run.
+	NumGC uint32 `json:"num_gc"`
+}
+
+// Result is the result of a load run. It can be encoded as JSON.
+type Result struct {
+	Network     string        `json:"network"`
+	Clients     int           `json:"clients"`
+	MessageSize int           `json:"message_size"`
+	Rate        int           `json:"rate"`
+	Duration    time.Duration `json:"duration_ns"`
+
+	// Messages sent and received. For packet networks, the difference is the
+	// number of lost messages.
+	Sent     uint64 `json:"sent"`
+	Received uint64 `json:"received"`
+
+	// Errors sending messages or starting clients.
+	Errors uint64 `json:"errors"`
+
+	// Received messages (and bytes) per second.
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	BytesPerSecond    float64 `json:"bytes_per_second"`
+
+	Latency Latency `json:"latency"`
+	Usage   Usage   `json:"usage"`
+}
+
+// Run runs a load test with the given config and returns its result.
+func Run(config Config) (*Result, error) {
+	config = config.withDefaults()
+
+	if config.MessageSize < minMessageSize {
+		return nil, fmt.Errorf("message size must be at least %d bytes",
+			minMessageSize)
+	}
+
+	switch config.Network {
+	case "tcp", "udp", "unix":
+	default:
+		return nil, fmt.Errorf("unsupported network %q", config.Network)
+	}
+
+	address := config.Address
+	var stop func()
+	if address == "" {
+		s, stopServer, err := startEchoServer(config.Network)
+		if err != nil {
+			return nil, err
+		}
+
+		address = s.Addr().String()
+		stop = stopServer
+	}
+
+	r := &run{
+		config:  config,
+		address: address,
+	}
+
+	result := r.run()
+
+	if stop != nil {
+		stop()
+
+		// Do not count the goroutines of the server we started.
+		result.Usage.GoroutinesEnd = runtime.NumGoroutine()
+	}
+
+	return result, nil
+}
+
+// run is a single load run.
+type run struct {
+	// Accessed atomically. Kept first for 64-bit alignment.
+	sent     uint64
+	received uint64
+	errors   uint64
+
+	config  Config
+	address string
+
+	m         sync.Mutex
+	latencies []time.Duration
+}
+
+func (r *run) run() *Result {
+	var startStats runtime.MemStats
+	runtime.ReadMemStats(&startStats)
+
+	usage := Usage{
+		GoroutinesStart: runtime.NumGoroutine(),
+		HeapPeak:        startStats.HeapAlloc,
+	}
+
+	stopCh := make(chan struct{})
+	samplerDoneCh := make(chan struct{})
+	go func() {
+		defer close(samplerDoneCh)
+
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+
+		for {
+			sample(&usage)
+
+			select {
+			case <-ticker.C:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	end := start.Add(r.config.Duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.config.Clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r.runClient(end)
+		}()
+	}
+
+	wg.Wait()
+
+	elapsed := time.Since(start)
+
+	close(stopCh)
+	<-samplerDoneCh
+
+	var endStats runtime.MemStats
+	runtime.ReadMemStats(&endStats)
+
+	usage.GoroutinesEnd = runtime.NumGoroutine()
+	usage.TotalAlloc = endStats.TotalAlloc - startStats.TotalAlloc
+	usage.NumGC = endStats.NumGC - startStats.NumGC
+
+	received := atomic.LoadUint64(&r.received)
+
+	return &Result{
+		Network:     r.config.Network,
+		Clients:     r.config.Clients,
+		MessageSize: r.config.MessageSize,
+		Rate:        r.config.Rate,
+		Duration:    elapsed,
+		Sent:        atomic.LoadUint64(&r.sent),
+		Received:    received,
+		Errors:      atomic.LoadUint64(&r.errors),
+		MessagesPerSecond: float64(received) /
+			elapsed.Seconds(),
+		BytesPerSecond: float64(received) *
+			float64(r.config.MessageSize) / elapsed.Seconds(),
+		Latency: latency(r.latencies),
+		Usage:   usage,
+	}
+}
+
+// runClient runs a single client until the given end time.
+func (r *run) runClient(end time.Time) {
+	replyCh := make(chan struct{}, 1)
+	var latencies []time.Duration
+	dataHandler := func(data []byte) {
+		if len(data) < minMessageSize {
+			return
+		}
+
+		sentAt := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+		latencies = append(latencies, time.Since(sentAt))
+		atomic.AddUint64(&r.received, 1)
+
+		select {
+		case replyCh <- struct{}{}:
+		default:
+		}
+	}
+
+	var c *client.Client
+	var err error
+	if r.config.Network == "udp" {
+		c, err = client.NewPacket(r.config.Network, r.address, dataHandler)
+	} else {
+		c, err = client.New(r.config.Network, r.address,
+			splitFixed(r.config.MessageSize), dataHandler)
+	}
+
+	if err == nil {
+		err = c.Start()
+	}
+
+	if err != nil {
+		atomic.AddUint64(&r.errors, 1)
+		return
+	}
+
+	var tickCh <-chan time.Time
+	if r.config.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(r.config.Rate))
+		defer ticker.Stop()
+
+		tickCh = ticker.C
+	}
+
+	message := make([]byte, r.config.MessageSize)
+	for {
+		now := time.Now()
+		if !now.Before(end) {
+			break
+		}
+
+		binary.BigEndian.PutUint64(message, uint64(now.UnixNano()))
+
+		err := c.Send(message)
+		if err != nil {
+			atomic.AddUint64(&r.errors, 1)
+		} else {
+			atomic.AddUint64(&r.sent, 1)
+		}
+
+		if tickCh != nil {
+			<-tickCh
+			continue
+		}
+
+		select {
+		case <-replyCh:
+		case <-time.After(replyTimeout):
+		}
+	}
+
+	// Give in-flight replies a chance to arrive (the data handler is not
+	// called anymore once Stop returns).
+	time.Sleep(10 * time.Millisecond)
+	c.Stop()
+
+	r.m.Lock()
+	r.latencies = append(r.latencies, latencies...)
+	r.m.Unlock()
+}
+
+// sample updates the peak values in the given Usage.
+func sample(usage *Usage) {
+	if goroutines := runtime.NumGoroutine(); goroutines >
+		usage.GoroutinesPeak {
+		usage.GoroutinesPeak = goroutines
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	if stats.HeapAlloc > usage.HeapPeak {
+		usage.HeapPeak = stats.HeapAlloc
+	}
+}
+
+// latency returns the distribution of the given latencies.
+func latency(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+
+	percentile := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+
+	return Latency{
+		Mean: total / time.Duration(len(latencies)),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P99:  percentile(0.99),
+		P999: percentile(0.999),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// splitFixed returns a bufio.SplitFunc that returns tokens of the given size.
+func splitFixed(size int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) >= size {
+			return size, data[:size], nil
+		}
+
+		if atEOF && len(data) > 0 {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+
+		return 0, nil, nil
+	}
+}
+
+// startEchoServer starts a server.Server echo server on a free address of
+// the given network. The returned function stops the server and removes any
+// temporary files.
+func startEchoServer(network string) (*server.Server, func(), error) {
+	address := "127.0.0.1:0"
+	var dir string
+	if network == "unix" {
+		var err error
+		dir, err = os.MkdirTemp("", "load")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		address = filepath.Join(dir, "echo.sock")
+	}
+
+	s, err := server.New(network, address, func(conn net.Conn) {
+		io.Copy(conn, conn)
+		conn.Close()
+	})
+	if err == nil {
+		err = s.Start()
+	}
+
+	if err != nil {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+
+		return nil, nil, err
+	}
+
+	return s, func() {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			shutdownTimeout)
+		defer cancel()
+
+		s.Shutdown(ctx)
+
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	}, nil
+}
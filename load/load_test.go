@@ -0,0 +1,98 @@
+package load
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRun_InvalidConfig(t *testing.T) {
+	_, err := Run(Config{Network: "sctp"})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, err = Run(Config{Network: "tcp", MessageSize: 4})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, network := range []string{"tcp", "udp", "unix"} {
+		t.Run(network, func(t *testing.T) {
+			result, err := Run(Config{
+				Network:  network,
+				Clients:  4,
+				Duration: 200 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			if result.Sent == 0 {
+				t.Error("expected sent messages, got none")
+			}
+
+			if result.Received == 0 {
+				t.Error("expected received messages, got none")
+			}
+
+			if result.Received > result.Sent {
+				t.Errorf("expected at most %d received messages, got %d",
+					result.Sent, result.Received)
+			}
+
+			if result.Errors != 0 {
+				t.Errorf("expected no errors, got %d", result.Errors)
+			}
+
+			if result.Latency.P50 <= 0 ||
+				result.Latency.P50 > result.Latency.Max {
+				t.Errorf("unexpected latency %+v", result.Latency)
+			}
+
+			if result.Usage.GoroutinesPeak < result.Usage.GoroutinesStart {
+				t.Errorf("unexpected usage %+v", result.Usage)
+			}
+
+			_, err = json.Marshal(result)
+			if err != nil {
+				t.Errorf("expected nil error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRun_Rate(t *testing.T) {
+	result, err := Run(Config{
+		Network:  "tcp",
+		Clients:  2,
+		Duration: 300 * time.Millisecond,
+		Rate:     20,
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// 2 clients at 20 messages per second for 300ms.
+	if result.Sent < 4 || result.Sent > 16 {
+		t.Errorf("expected about 12 sent messages, got %d", result.Sent)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	latencies := make([]time.Duration, 0, 100)
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i))
+	}
+
+	l := latency(latencies)
+	if l.P50 != 50 || l.P90 != 90 || l.P99 != 99 || l.Max != 100 {
+		t.Errorf("unexpected latency %+v", l)
+	}
+
+	if l.Mean != 50 {
+		t.Errorf("expected mean 50, got %d", l.Mean)
+	}
+}
@@ -0,0 +1,100 @@
+package record
+
+import (
+	"io"
+	"net"
+)
+
+// Conn is a net.Conn wrapper that records all data read and written (and
+// closes) to a Writer. Recording errors do not affect the connection (see
+// Writer.Err).
+type Conn struct {
+	net.Conn
+
+	w *Writer
+}
+
+// Wrap returns a Conn that records the traffic of the given conn to the given
+// Writer.
+func Wrap(conn net.Conn, w *Writer) *Conn {
+	return &Conn{
+		Conn: conn,
+		w:    w,
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.w.Record(Read, "", b[:n])
+	}
+
+	if err == io.EOF {
+		c.w.Record(EOF, "", nil)
+	}
+
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.w.Record(Write, "", b[:n])
+	}
+
+	return n, err
+}
+
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	if err == nil {
+		c.w.Record(Close, "", nil)
+	}
+
+	return err
+}
+
+// PacketConn is a net.PacketConn wrapper that records all datagrams read and
+// written (and closes) to a Writer, together with the address of their
+// peers. Recording errors do not affect the connection (see Writer.Err).
+type PacketConn struct {
+	net.PacketConn
+
+	w *Writer
+}
+
+// WrapPacketConn returns a PacketConn that records the traffic of the given
+// packetConn to the given Writer.
+func WrapPacketConn(packetConn net.PacketConn, w *Writer) *PacketConn {
+	return &PacketConn{
+		PacketConn: packetConn,
+		w:          w,
+	}
+}
+
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		c.w.Record(Read, addr.String(), b[:n])
+	}
+
+	return n, addr, err
+}
+
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		c.w.Record(Write, addr.String(), b[:n])
+	}
+
+	return n, err
+}
+
+func (c *PacketConn) Close() error {
+	err := c.PacketConn.Close()
+	if err == nil {
+		c.w.Record(Close, "", nil)
+	}
+
+	return err
+}
@@ -0,0 +1,83 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// kinds returns the kinds and data of the given events as a string.
+func kinds(events []Event) string {
+	s := ""
+	for _, event := range events {
+		s += fmt.Sprintf("%v(%s%s) ", event.Kind, event.Addr, event.Data)
+	}
+
+	return s
+}
+
+func TestConn(t *testing.T) {
+	var buffer bytes.Buffer
+	w, _ := NewWriter(&buffer)
+
+	conn, peer := net.Pipe()
+	c := Wrap(conn, w)
+
+	go func() {
+		peer.Write([]byte("ping"))
+		peer.Read(make([]byte, 4))
+		peer.Close()
+	}()
+
+	io.ReadFull(c, make([]byte, 4))
+	c.Write([]byte("pong"))
+	c.Read(make([]byte, 4))
+	c.Close()
+
+	events, err := ReadAll(&buffer)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := "read(ping) write(pong) eof() close() "
+	if kinds(events) != expected {
+		t.Errorf("expected %q, got %q", expected, kinds(events))
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	var buffer bytes.Buffer
+	w, _ := NewWriter(&buffer)
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer peer.Close()
+
+	c := WrapPacketConn(packetConn, w)
+
+	c.WriteTo([]byte("ping"), peer.LocalAddr())
+	peer.WriteTo([]byte("pong"), c.LocalAddr())
+	c.ReadFrom(make([]byte, 4))
+	c.Close()
+
+	events, err := ReadAll(&buffer)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	addr := peer.LocalAddr().String()
+	expected := fmt.Sprintf("write(%sping) read(%spong) close() ", addr,
+		addr)
+	if kinds(events) != expected {
+		t.Errorf("expected %q, got %q", expected, kinds(events))
+	}
+}
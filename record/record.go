@@ -0,0 +1,225 @@
+// Package record implements recording of network traffic to a simple binary
+// format, so sessions can be inspected or replayed later (see
+// testing.NewReplayConn).
+//
+// A recording starts with an 8 byte magic string followed by events. Each
+// event is encoded as its kind (1 byte), its time since the recording started
+// in nanoseconds (8 bytes), the length of its address (2 bytes), its address,
+// the length of its data (4 bytes) and its data. All integers are big endian.
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+const magic = "NETREC\x00\x01"
+
+// Maximum number of bytes allocated for event data before it is read.
+const initialDataCapacity = 64 * 1024
+
+// Kind is the kind of a recorded Event.
+type Kind uint8
+
+const (
+	// Read is data read from the peer.
+	Read Kind = iota
+
+	// Write is data written to the peer.
+	Write
+
+	// EOF is recorded when the peer shut down its writing side (a read
+	// returned io.EOF).
+	EOF
+
+	// Close is recorded when the connection was closed locally.
+	Close
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	case EOF:
+		return "eof"
+	case Close:
+		return "close"
+	}
+
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+// Event is a recorded network event.
+type Event struct {
+	Kind Kind
+
+	// Time since the recording started.
+	Time time.Duration
+
+	// Address of the peer for packet connections (see WrapPacketConn). Empty
+	// for connections.
+	Addr string
+
+	Data []byte
+}
+
+// Writer writes events to a recording. It is safe for concurrent use.
+type Writer struct {
+	start time.Time
+
+	m   sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewWriter creates a new Writer that writes a recording to the given
+// io.Writer. Event times are relative to the time NewWriter is called.
+func NewWriter(w io.Writer) (*Writer, error) {
+	_, err := io.WriteString(w, magic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		start: time.Now(),
+		w:     w,
+	}, nil
+}
+
+// Record writes an event with the given kind, address and data, timestamped
+// with the current time. After the first error, all calls fail with it.
+func (w *Writer) Record(kind Kind, addr string, data []byte) error {
+	if len(addr) > math.MaxUint16 {
+		return fmt.Errorf("address too long (%d bytes)", len(addr))
+	}
+
+	elapsed := time.Since(w.start)
+
+	var header [15]byte
+	header[0] = byte(kind)
+	binary.BigEndian.PutUint64(header[1:9], uint64(elapsed))
+	binary.BigEndian.PutUint16(header[9:11], uint16(len(addr)))
+	binary.BigEndian.PutUint32(header[11:15], uint32(len(data)))
+
+	var buffer bytes.Buffer
+	buffer.Write(header[:11])
+	buffer.WriteString(addr)
+	buffer.Write(header[11:15])
+	buffer.Write(data)
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	_, w.err = w.w.Write(buffer.Bytes())
+
+	return w.err
+}
+
+// Err returns the first error that happened while writing the recording, if
+// any.
+func (w *Writer) Err() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.err
+}
+
+// Reader reads events from a recording.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader creates a new Reader that reads a recording from the given
+// io.Reader. It returns an error if the recording header is invalid.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := bufio.NewReader(r)
+
+	header := make([]byte, len(magic))
+	_, err := io.ReadFull(reader, header)
+	if err != nil || string(header) != magic {
+		return nil, fmt.Errorf("invalid recording header")
+	}
+
+	return &Reader{reader}, nil
+}
+
+// Next returns the next event in the recording. It returns io.EOF after the
+// last one.
+func (r *Reader) Next() (Event, error) {
+	var header [11]byte
+	_, err := io.ReadFull(r.r, header[:])
+	if err != nil {
+		return Event{}, err
+	}
+
+	event := Event{
+		Kind: Kind(header[0]),
+		Time: time.Duration(binary.BigEndian.Uint64(header[1:9])),
+	}
+
+	addr := make([]byte, binary.BigEndian.Uint16(header[9:11]))
+	_, err = io.ReadFull(r.r, addr)
+	if err != nil {
+		return Event{}, io.ErrUnexpectedEOF
+	}
+
+	event.Addr = string(addr)
+
+	var length [4]byte
+	_, err = io.ReadFull(r.r, length[:])
+	if err != nil {
+		return Event{}, io.ErrUnexpectedEOF
+	}
+
+	// Grow the data as it is read, so a corrupted length does not allocate
+	// much more than the recording actually holds.
+	size := int64(binary.BigEndian.Uint32(length[:]))
+	capacity := size
+	if capacity > initialDataCapacity {
+		capacity = initialDataCapacity
+	}
+
+	data := bytes.NewBuffer(make([]byte, 0, capacity))
+	_, err = io.CopyN(data, r.r, size)
+	if err != nil {
+		return Event{}, io.ErrUnexpectedEOF
+	}
+
+	event.Data = data.Bytes()
+
+	return event, nil
+}
+
+// ReadAll reads all events from the given recording.
+func ReadAll(r io.Reader) ([]Event, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return events, nil
+		}
+
+		if err != nil {
+			return events, err
+		}
+
+		events = append(events, event)
+	}
+}
@@ -0,0 +1,82 @@
+package record
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRecording(t *testing.T) {
+	var buffer bytes.Buffer
+
+	w, err := NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := []Event{
+		{Kind: Read, Data: []byte("hello")},
+		{Kind: Write, Addr: "127.0.0.1:8080", Data: []byte("world")},
+		{Kind: EOF, Data: []byte{}},
+		{Kind: Close, Data: []byte{}},
+	}
+
+	for _, event := range expected {
+		time.Sleep(time.Millisecond)
+
+		err = w.Record(event.Kind, event.Addr, event.Data)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+	}
+
+	events, err := ReadAll(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	var last time.Duration
+	for i := range events {
+		if events[i].Time <= last {
+			t.Errorf("expected time after %v, got %v", last, events[i].Time)
+		}
+
+		last = events[i].Time
+		events[i].Time = 0
+	}
+
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+
+	// Truncated recordings.
+	_, err = ReadAll(bytes.NewReader(buffer.Bytes()[:buffer.Len()-1]))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	// A corrupted length must not be allocated upfront.
+	corrupted := append([]byte(nil), buffer.Bytes()[:len(magic)+11]...)
+	corrupted = append(corrupted, 0xff, 0xff, 0xff, 0xff)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	_, err = ReadAll(bytes.NewReader(corrupted))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("expected at most 1MiB allocated, got %d bytes", allocated)
+	}
+
+	_, err = NewReader(bytes.NewReader(buffer.Bytes()[:4]))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
@@ -0,0 +1,632 @@
+package reliable
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/brunoga/net/internal/waiter"
+)
+
+const initialRTO = 200 * time.Millisecond
+
+// Conn is a net.Conn that provides reliable, ordered delivery on top of a
+// datagram connection. Message boundaries are not preserved (as with TCP).
+type Conn struct {
+	conn   net.Conn
+	config Config
+
+	wg sync.WaitGroup
+
+	m         sync.Mutex
+	changed   waiter.Waiter // Notified when the state changes.
+	stopCh    chan struct{} // Closed when the Conn is closed or fails.
+	kickCh    chan struct{} // Wakes up the retransmit loop.
+	err       error         // Why the Conn was stopped.
+	closed    bool
+	lastHeard time.Time // When the last packet from the peer was received.
+
+	// Sender state.
+	nextSeq  uint32
+	inFlight map[uint32]*outgoing
+	cwnd     float64
+	ssthresh float64
+	srtt     time.Duration
+	rttvar   time.Duration
+	rto      time.Duration
+	lastLoss time.Time
+
+	// Receiver state.
+	rcvNext    uint32
+	outOfOrder map[uint32][]byte
+	readQueue  [][]byte
+	peerClosed bool
+	closeAcked bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// outgoing is a datagram waiting to be acknowledged.
+type outgoing struct {
+	packet      []byte
+	sentAt      time.Time
+	lastSentAt  time.Time
+	expiresAt   time.Time
+	retransmits int
+
+	// Retransmissions without hearing anything from the peer.
+	silentRetransmits int
+}
+
+// Wrap returns a new Conn that uses the given datagram connection. The Conn
+// owns conn and closes it when it is closed.
+func Wrap(conn net.Conn, config Config) *Conn {
+	config = config.withDefaults()
+
+	c := &Conn{
+		conn:       conn,
+		config:     config,
+		stopCh:     make(chan struct{}),
+		kickCh:     make(chan struct{}, 1),
+		inFlight:   make(map[uint32]*outgoing),
+		cwnd:       4,
+		ssthresh:   float64(config.Window),
+		rto:        initialRTO,
+		outOfOrder: make(map[uint32][]byte),
+	}
+
+	if c.rto < config.MinRTO {
+		c.rto = config.MinRTO
+	} else if c.rto > config.MaxRTO {
+		c.rto = config.MaxRTO
+	}
+
+	c.wg.Add(2)
+	go c.receiveLoop()
+	go c.retransmitLoop()
+
+	return c
+}
+
+// Read reads data from the connection, in the order it was written by the
+// peer. It returns io.EOF once the peer closed the connection and all its
+// data was read.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.m.Lock()
+
+	for {
+		if c.closed {
+			c.m.Unlock()
+			return 0, net.ErrClosed
+		}
+
+		if len(c.readQueue) > 0 {
+			n := copy(b, c.readQueue[0])
+			c.readQueue[0] = c.readQueue[0][n:]
+
+			var ack []byte
+			if len(c.readQueue[0]) == 0 {
+				c.readQueue[0] = nil
+				c.readQueue = c.readQueue[1:]
+
+				// Take datagrams that did not fit in the queue before and let
+				// the peer know (they are not acknowledged until taken).
+				if c.deliverLocked() {
+					ack = c.ackLocked()
+				}
+			}
+
+			c.m.Unlock()
+
+			if ack != nil {
+				c.conn.Write(ack)
+			}
+
+			return n, nil
+		}
+
+		if c.peerClosed {
+			c.m.Unlock()
+			return 0, io.EOF
+		}
+
+		if c.err != nil {
+			err := c.err
+			c.m.Unlock()
+			return 0, err
+		}
+
+		if !c.changed.Wait(&c.m, c.readDeadline) {
+			c.m.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Write writes data to the connection, splitting it in datagrams of at most
+// Config.MaxPayload bytes. It blocks while the congestion window is full.
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		payload := b
+		if len(payload) > c.config.MaxPayload {
+			payload = payload[:c.config.MaxPayload]
+		}
+
+		err := c.send(payload)
+		if err != nil {
+			return written, err
+		}
+
+		written += len(payload)
+		b = b[len(payload):]
+	}
+
+	return written, nil
+}
+
+// send waits for room in the congestion window and sends the given payload
+// in a new datagram.
+func (c *Conn) send(payload []byte) error {
+	c.m.Lock()
+
+	for {
+		if c.closed {
+			c.m.Unlock()
+			return net.ErrClosed
+		}
+
+		if c.err != nil {
+			err := c.err
+			c.m.Unlock()
+			return err
+		}
+
+		if c.peerClosed {
+			c.m.Unlock()
+			return ErrPeerClosed
+		}
+
+		if !c.writeDeadline.IsZero() &&
+			!time.Now().Before(c.writeDeadline) {
+			c.m.Unlock()
+			return os.ErrDeadlineExceeded
+		}
+
+		if len(c.inFlight) < c.windowLocked() {
+			break
+		}
+
+		if !c.changed.Wait(&c.m, c.writeDeadline) {
+			c.m.Unlock()
+			return os.ErrDeadlineExceeded
+		}
+	}
+
+	now := time.Now()
+	packet := encodeData(c.nextSeq, payload)
+	c.inFlight[c.nextSeq] = &outgoing{
+		packet:     packet,
+		sentAt:     now,
+		lastSentAt: now,
+		expiresAt:  now.Add(c.rto),
+	}
+	c.nextSeq++
+
+	if len(c.inFlight) == 1 {
+		// The retransmit loop is idle while there is nothing in flight.
+		select {
+		case c.kickCh <- struct{}{}:
+		default:
+		}
+	}
+
+	c.m.Unlock()
+
+	// Lost datagrams are retransmitted, so errors are not relevant here.
+	c.conn.Write(packet)
+
+	return nil
+}
+
+// Close closes the connection. It waits up to Config.CloseTimeout for written
+// data to be acknowledged and for the peer to acknowledge that the connection
+// was closed (retransmitting the notification as needed). If the peer does not
+// acknowledge it in time, its reads fail only when they time out or once it
+// notices the peer is unreachable.
+func (c *Conn) Close() error {
+	c.m.Lock()
+
+	if c.closed {
+		c.m.Unlock()
+		return net.ErrClosed
+	}
+
+	// Stops new writes.
+	c.closed = true
+	c.changed.Notify()
+
+	deadline := time.Now().Add(c.config.CloseTimeout)
+	for len(c.inFlight) > 0 && c.err == nil {
+		if !c.changed.Wait(&c.m, deadline) {
+			break
+		}
+	}
+
+	// There is no one to notify if the peer already closed its side.
+	for c.err == nil && !c.peerClosed && !c.closeAcked &&
+		time.Now().Before(deadline) {
+		c.m.Unlock()
+		c.conn.Write([]byte{packetClose})
+		c.m.Lock()
+
+		retry := time.Now().Add(c.rto)
+		if retry.After(deadline) {
+			retry = deadline
+		}
+
+		for c.err == nil && !c.closeAcked && c.changed.Wait(&c.m, retry) {
+		}
+	}
+
+	c.stopLocked(net.ErrClosed)
+
+	c.m.Unlock()
+
+	err := c.conn.Close()
+
+	c.wg.Wait()
+
+	return err
+}
+
+// LocalAddr returns the local address of the underlying connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets both the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	c.SetWriteDeadline(t)
+
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read calls, including ones that are
+// currently blocked.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.readDeadline = t
+	c.changed.Notify()
+
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write calls (which block while the
+// congestion window is full), including ones that are currently blocked.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.writeDeadline = t
+	c.changed.Notify()
+
+	return nil
+}
+
+// RTT returns the current smoothed round-trip time estimation (zero if there
+// are no samples yet).
+func (c *Conn) RTT() time.Duration {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.srtt
+}
+
+// windowLocked returns the current maximum number of datagrams in flight.
+func (c *Conn) windowLocked() int {
+	window := int(c.cwnd)
+	if window > c.config.Window {
+		window = c.config.Window
+	}
+
+	if window < 1 {
+		window = 1
+	}
+
+	return window
+}
+
+// stopLocked stops the Conn with the given error, if not already stopped.
+func (c *Conn) stopLocked(err error) {
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.stopCh)
+	c.changed.Notify()
+}
+
+func (c *Conn) receiveLoop() {
+	defer c.wg.Done()
+
+	buffer := make([]byte, 65536)
+	for {
+		n, err := c.conn.Read(buffer)
+		if err != nil {
+			// Connected UDP sockets report ICMP errors from previous writes
+			// (for example, if the peer is not listening yet).
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				continue
+			}
+
+			c.m.Lock()
+			c.stopLocked(err)
+			c.m.Unlock()
+
+			return
+		}
+
+		packet := buffer[:n]
+		if validatePacket(packet) != nil {
+			continue
+		}
+
+		c.m.Lock()
+		c.lastHeard = time.Now()
+		c.m.Unlock()
+
+		switch packet[0] {
+		case packetData:
+			seq := binary.BigEndian.Uint32(packet[1:])
+			c.handleData(seq, packet[dataHeaderSize:])
+		case packetAck:
+			c.handleAck(binary.BigEndian.Uint32(packet[1:]),
+				binary.BigEndian.Uint32(packet[5:]))
+		case packetClose:
+			c.m.Lock()
+			c.peerClosed = true
+
+			// The peer will not acknowledge anything else.
+			c.inFlight = make(map[uint32]*outgoing)
+
+			c.changed.Notify()
+			c.m.Unlock()
+
+			// Close notifications are retransmitted until acknowledged.
+			c.conn.Write([]byte{packetCloseAck})
+		case packetCloseAck:
+			c.m.Lock()
+			c.closeAcked = true
+			c.changed.Notify()
+			c.m.Unlock()
+		}
+	}
+}
+
+func (c *Conn) handleData(seq uint32, payload []byte) {
+	c.m.Lock()
+
+	if !seqBefore(seq, c.rcvNext) &&
+		seq-c.rcvNext < uint32(c.config.Window) {
+		if _, ok := c.outOfOrder[seq]; !ok {
+			c.outOfOrder[seq] = append([]byte(nil), payload...)
+		}
+
+		if c.deliverLocked() {
+			c.changed.Notify()
+		}
+	}
+
+	// Always acknowledge, as previous acks might have been lost.
+	ack := c.ackLocked()
+
+	c.m.Unlock()
+
+	c.conn.Write(ack)
+}
+
+// deliverLocked moves everything that is now in order to the read queue. It
+// returns true if anything was moved. Datagrams are not taken if readers are
+// too far behind (they are taken by Read once there is room).
+func (c *Conn) deliverLocked() bool {
+	delivered := false
+	for len(c.readQueue) < c.config.Window {
+		data, ok := c.outOfOrder[c.rcvNext]
+		if !ok {
+			break
+		}
+
+		delete(c.outOfOrder, c.rcvNext)
+		c.readQueue = append(c.readQueue, data)
+		c.rcvNext++
+		delivered = true
+	}
+
+	return delivered
+}
+
+// ackLocked returns an ack packet for the current receiver state.
+func (c *Conn) ackLocked() []byte {
+	var bitmap uint32
+	for i := uint32(0); i < sackBits; i++ {
+		if _, ok := c.outOfOrder[c.rcvNext+1+i]; ok {
+			bitmap |= 1 << i
+		}
+	}
+
+	return encodeAck(c.rcvNext, bitmap)
+}
+
+func (c *Conn) handleAck(next, bitmap uint32) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := time.Now()
+	acked := false
+	for seq, out := range c.inFlight {
+		if !seqBefore(seq, next) {
+			offset := seq - next - 1
+			if seq == next || offset >= sackBits ||
+				bitmap&(1<<offset) == 0 {
+				continue
+			}
+		}
+
+		// Karn's algorithm: retransmitted datagrams give ambiguous samples.
+		if out.retransmits == 0 {
+			c.updateRTTLocked(now.Sub(out.sentAt))
+		}
+
+		delete(c.inFlight, seq)
+		acked = true
+
+		// Slow start, then additive increase.
+		if c.cwnd < c.ssthresh {
+			c.cwnd++
+		} else {
+			c.cwnd += 1 / c.cwnd
+		}
+	}
+
+	if c.cwnd > float64(c.config.Window) {
+		c.cwnd = float64(c.config.Window)
+	}
+
+	if acked {
+		c.changed.Notify()
+	}
+}
+
+// updateRTTLocked updates the round-trip time estimation and the
+// retransmission timeout with the given sample (see RFC 6298).
+func (c *Conn) updateRTTLocked(sample time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = sample
+		c.rttvar = sample / 2
+	} else {
+		delta := c.srtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + sample) / 8
+	}
+
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < c.config.MinRTO {
+		c.rto = c.config.MinRTO
+	} else if c.rto > c.config.MaxRTO {
+		c.rto = c.config.MaxRTO
+	}
+}
+
+// retransmitLoop retransmits datagrams that were not acknowledged in time. It
+// sleeps until the earliest retransmission is due (or until something is sent
+// if nothing is in flight).
+func (c *Conn) retransmitLoop() {
+	defer c.wg.Done()
+
+	for {
+		c.m.Lock()
+
+		var next time.Time
+		for _, out := range c.inFlight {
+			if next.IsZero() || out.expiresAt.Before(next) {
+				next = out.expiresAt
+			}
+		}
+
+		c.m.Unlock()
+
+		var timer *time.Timer
+		var timerCh <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			timerCh = timer.C
+		}
+
+		select {
+		case <-timerCh:
+		case <-c.kickCh:
+		case <-c.stopCh:
+			if timer != nil {
+				timer.Stop()
+			}
+
+			return
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		var packets [][]byte
+
+		c.m.Lock()
+
+		now := time.Now()
+		for _, out := range c.inFlight {
+			if now.Before(out.expiresAt) {
+				continue
+			}
+
+			// Only give up on peers that are not sending anything. Peers
+			// with slow readers keep acknowledging other datagrams.
+			if c.lastHeard.After(out.lastSentAt) {
+				out.silentRetransmits = 0
+			}
+
+			if out.silentRetransmits >= c.config.MaxRetransmits {
+				c.stopLocked(ErrPeerUnreachable)
+				break
+			}
+
+			// Halve the congestion window at most once per retransmission
+			// timeout, as losses tend to come in bursts.
+			if now.Sub(c.lastLoss) >= c.rto {
+				c.ssthresh = c.cwnd / 2
+				if c.ssthresh < 2 {
+					c.ssthresh = 2
+				}
+				c.cwnd = c.ssthresh
+				c.lastLoss = now
+			}
+
+			// Exponential backoff for each retransmission of the same
+			// datagram.
+			out.retransmits++
+			out.silentRetransmits++
+			timeout := c.rto << uint(out.retransmits)
+			if timeout > c.config.MaxRTO || timeout <= 0 {
+				timeout = c.config.MaxRTO
+			}
+			out.expiresAt = now.Add(timeout)
+			out.lastSentAt = now
+
+			packets = append(packets, out.packet)
+		}
+
+		c.m.Unlock()
+
+		for _, packet := range packets {
+			c.conn.Write(packet)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package reliable
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	testing2 "github.com/brunoga/net/testing"
+)
+
+// lossyConn is an in-memory datagram net.Conn that drops, delays and reorders
+// datagrams.
+type lossyConn struct {
+	inCh  chan []byte
+	outCh chan []byte
+
+	loss  float64       // Probability of dropping a datagram.
+	delay time.Duration // Maximum delay (random, so datagrams are reordered).
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	m    sync.Mutex
+	rand *rand.Rand
+}
+
+// lossyPipe returns two connected lossyConns.
+func lossyPipe(loss float64, delay time.Duration) (*lossyConn, *lossyConn) {
+	ch1 := make(chan []byte, 1024)
+	ch2 := make(chan []byte, 1024)
+
+	addr1 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	addr2 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+
+	newConn := func(inCh, outCh chan []byte, localAddr, remoteAddr net.Addr,
+		seed int64) *lossyConn {
+		return &lossyConn{
+			inCh:       inCh,
+			outCh:      outCh,
+			loss:       loss,
+			delay:      delay,
+			localAddr:  localAddr,
+			remoteAddr: remoteAddr,
+			closeCh:    make(chan struct{}),
+			rand:       rand.New(rand.NewSource(seed)),
+		}
+	}
+
+	return newConn(ch1, ch2, addr1, addr2, 1),
+		newConn(ch2, ch1, addr2, addr1, 2)
+}
+
+func (c *lossyConn) Read(b []byte) (int, error) {
+	select {
+	case data := <-c.inCh:
+		return copy(b, data), nil
+	case <-c.closeCh:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closeCh:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	c.m.Lock()
+	drop := c.rand.Float64() < c.loss
+	var delay time.Duration
+	if c.delay > 0 {
+		delay = time.Duration(c.rand.Int63n(int64(c.delay)))
+	}
+	c.m.Unlock()
+
+	if drop {
+		return len(b), nil
+	}
+
+	data := append([]byte(nil), b...)
+	deliver := func() {
+		select {
+		case c.outCh <- data:
+		default:
+			// Queue full. Drop it.
+		}
+	}
+
+	if delay > 0 {
+		time.AfterFunc(delay, deliver)
+	} else {
+		deliver()
+	}
+
+	return len(b), nil
+}
+
+func (c *lossyConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+
+	return nil
+}
+
+func (c *lossyConn) LocalAddr() net.Addr                { return c.localAddr }
+func (c *lossyConn) RemoteAddr() net.Addr               { return c.remoteAddr }
+func (c *lossyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *lossyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *lossyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+var testConfig = Config{MaxPayload: 100, MinRTO: 5 * time.Millisecond}
+
+func testTransfer(t *testing.T, loss float64, delay time.Duration) {
+	conn1, conn2 := lossyPipe(loss, delay)
+
+	c1 := Wrap(conn1, testConfig)
+	c2 := Wrap(conn2, testConfig)
+
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+
+	// Send in both directions at the same time.
+	var wg sync.WaitGroup
+	received := make([][]byte, 2)
+	for i, pair := range [][2]*Conn{{c1, c2}, {c2, c1}} {
+		wg.Add(2)
+
+		go func(c *Conn) {
+			defer wg.Done()
+
+			_, err := c.Write(data)
+			if err != nil {
+				t.Errorf("expected nil error, got %v", err)
+			}
+		}(pair[0])
+
+		go func(i int, c *Conn) {
+			defer wg.Done()
+
+			c.SetReadDeadline(time.Now().Add(20 * time.Second))
+
+			buffer := make([]byte, len(data))
+			_, err := io.ReadFull(c, buffer)
+			if err != nil {
+				t.Errorf("expected nil error, got %v", err)
+			}
+
+			received[i] = buffer
+		}(i, pair[1])
+	}
+
+	wg.Wait()
+
+	for _, buffer := range received {
+		if !bytes.Equal(buffer, data) {
+			t.Error("received data does not match sent data")
+		}
+	}
+
+	c1.Close()
+	c2.Close()
+}
+
+func TestConn_NoLoss(t *testing.T) {
+	testTransfer(t, 0, 0)
+}
+
+func TestConn_Lossy(t *testing.T) {
+	testTransfer(t, 0.2, 2*time.Millisecond)
+}
+
+func TestConn_Close(t *testing.T) {
+	conn1, conn2 := lossyPipe(0.2, 0)
+
+	c1 := Wrap(conn1, testConfig)
+	c2 := Wrap(conn2, testConfig)
+	defer c2.Close()
+
+	_, err := c1.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// Close waits for the data to be acknowledged.
+	err = c1.Close()
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	err = c1.Close()
+	if err != net.ErrClosed {
+		t.Errorf("expected net.ErrClosed, got %v", err)
+	}
+
+	_, err = c1.Write([]byte("x"))
+	if err != net.ErrClosed {
+		t.Errorf("expected net.ErrClosed, got %v", err)
+	}
+
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	data, err := io.ReadAll(c2)
+	if string(data) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", data)
+	}
+
+	// The close datagram might have been lost, in which case the read times
+	// out instead of returning io.EOF.
+	if err != nil {
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+	}
+}
+
+func TestConn_PeerClosed(t *testing.T) {
+	conn1, conn2 := lossyPipe(0, 0)
+
+	c1 := Wrap(conn1, testConfig)
+	c2 := Wrap(conn2, testConfig)
+	defer c2.Close()
+
+	c1.Close()
+
+	_, err := c2.Read(make([]byte, 16))
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	_, err = c2.Write([]byte("x"))
+	if err != ErrPeerClosed {
+		t.Errorf("expected ErrPeerClosed, got %v", err)
+	}
+}
+
+// dropFirstCloseConn is a net.Conn that drops the first close notification
+// written to it.
+type dropFirstCloseConn struct {
+	net.Conn
+
+	dropped bool
+}
+
+func (c *dropFirstCloseConn) Write(b []byte) (int, error) {
+	if !c.dropped && len(b) == 1 && b[0] == packetClose {
+		c.dropped = true
+		return len(b), nil
+	}
+
+	return c.Conn.Write(b)
+}
+
+func TestConn_CloseRetransmitted(t *testing.T) {
+	conn1, conn2 := lossyPipe(0, 0)
+
+	c1 := Wrap(&dropFirstCloseConn{Conn: conn1}, testConfig)
+	c2 := Wrap(conn2, testConfig)
+	defer c2.Close()
+
+	c1.Close()
+
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, err := c2.Read(make([]byte, 16))
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestConn_SlowReader(t *testing.T) {
+	conn1, conn2 := lossyPipe(0, 0)
+
+	config := Config{MaxPayload: 10, Window: 4, MinRTO: time.Millisecond,
+		MaxRTO: 5 * time.Millisecond, MaxRetransmits: 3}
+	c1 := Wrap(conn1, config)
+	c2 := Wrap(conn2, config)
+	defer c2.Close()
+	defer c1.Close()
+
+	data := make([]byte, 200)
+	rand.New(rand.NewSource(4)).Read(data)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c1.Write(data)
+		errCh <- err
+	}()
+
+	// Much longer than MaxRetransmits retransmissions take, while the
+	// receive queue is full.
+	time.Sleep(200 * time.Millisecond)
+
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buffer := make([]byte, len(data))
+	_, err := io.ReadFull(c2, buffer)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !bytes.Equal(buffer, data) {
+		t.Error("received data does not match sent data")
+	}
+
+	err = <-errCh
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+}
+
+func TestConn_PeerUnreachable(t *testing.T) {
+	conn1, _ := lossyPipe(1, 0)
+
+	c := Wrap(conn1, Config{MinRTO: time.Millisecond,
+		MaxRTO: time.Millisecond, MaxRetransmits: 3})
+	defer c.Close()
+
+	_, err := c.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+
+	_, err = c.Read(make([]byte, 16))
+	if err != ErrPeerUnreachable {
+		t.Errorf("expected ErrPeerUnreachable, got %v", err)
+	}
+}
+
+func TestConn_Deadlines(t *testing.T) {
+	conn1, _ := lossyPipe(1, 0)
+
+	c := Wrap(conn1, Config{Window: 1, CloseTimeout: time.Millisecond})
+	defer c.Close()
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	_, err := c.Read(make([]byte, 16))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+
+	// The first datagram fills the window, so the second write blocks.
+	c.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+
+	_, err = c.Write([]byte("a"))
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	_, err = c.Write([]byte("b"))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestConn_RTT(t *testing.T) {
+	conn1, conn2 := lossyPipe(0, 0)
+
+	c1 := Wrap(conn1, testConfig)
+	c2 := Wrap(conn2, testConfig)
+	defer c2.Close()
+	defer c1.Close()
+
+	if c1.RTT() != 0 {
+		t.Errorf("expected no RTT estimation, got %v", c1.RTT())
+	}
+
+	c1.Write([]byte("hello"))
+	c2.Read(make([]byte, 16))
+
+	deadline := time.Now().Add(time.Second)
+	for c1.RTT() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if c1.RTT() == 0 {
+		t.Error("expected RTT estimation, got none")
+	}
+}
+
+func TestConn_Conformance(t *testing.T) {
+	testing2.TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		a, b := lossyPipe(0, 0)
+		c1 := Wrap(a, Config{})
+		c2 := Wrap(b, Config{})
+
+		return c1, c2, func() {
+			c1.Close()
+			c2.Close()
+		}, nil
+	})
+}
@@ -0,0 +1,81 @@
+package reliable
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Packet types.
+const (
+	packetData  byte = 1
+	packetAck   byte = 2
+	packetClose byte = 3
+
+	packetCloseAck byte = 4
+)
+
+const (
+	dataHeaderSize = 5 // Type and sequence number.
+	ackSize        = 9 // Type, next expected sequence number and bitmap.
+
+	// Number of sequence numbers after the cumulative acknowledgement
+	// covered by the selective acknowledgement bitmap.
+	sackBits = 32
+)
+
+// Wire format (all integers are big endian):
+//
+//	data:  type (1) | sequence number (4) | payload
+//	ack:   type (1) | next expected sequence number (4) | bitmap (4)
+//	close: type (1)
+//	close ack: type (1)
+//
+// Bit i of the ack bitmap is set if sequence number next+1+i was received.
+
+func encodeData(seq uint32, payload []byte) []byte {
+	packet := make([]byte, dataHeaderSize+len(payload))
+	packet[0] = packetData
+	binary.BigEndian.PutUint32(packet[1:], seq)
+	copy(packet[dataHeaderSize:], payload)
+
+	return packet
+}
+
+func encodeAck(next, bitmap uint32) []byte {
+	packet := make([]byte, ackSize)
+	packet[0] = packetAck
+	binary.BigEndian.PutUint32(packet[1:], next)
+	binary.BigEndian.PutUint32(packet[5:], bitmap)
+
+	return packet
+}
+
+// validatePacket returns a non-nil error if the given datagram is not a
+// valid packet.
+func validatePacket(packet []byte) error {
+	if len(packet) == 0 {
+		return fmt.Errorf("empty packet")
+	}
+
+	switch packet[0] {
+	case packetData:
+		if len(packet) < dataHeaderSize {
+			return fmt.Errorf("short data packet")
+		}
+	case packetAck:
+		if len(packet) != ackSize {
+			return fmt.Errorf("invalid ack packet size %d", len(packet))
+		}
+	case packetClose, packetCloseAck:
+	default:
+		return fmt.Errorf("unknown packet type %d", packet[0])
+	}
+
+	return nil
+}
+
+// seqBefore returns true if sequence number a comes before b (taking
+// wraparound into account).
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
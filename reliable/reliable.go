@@ -0,0 +1,90 @@
+// Package reliable implements an optional reliability layer on top of
+// datagram connections (for example, UDP sockets or the "fake" connections
+// created by server.Server for packet networks).
+//
+// Data written to a Conn is split into datagrams with sequence numbers that
+// are acknowledged by the peer with selective acknowledgements. Lost
+// datagrams are retransmitted based on an estimation of the round-trip time
+// and data is delivered to readers in order, without duplicates. The number
+// of datagrams in flight is limited by a simple (AIMD) congestion window.
+//
+// Both sides of a connection must use this package. The underlying connection
+// must preserve datagram boundaries (each Write sends exactly one datagram and
+// each Read returns exactly one datagram).
+package reliable
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrPeerUnreachable is returned by Read and Write when a datagram was
+	// retransmitted Config.MaxRetransmits times without being acknowledged
+	// and without receiving anything from the peer.
+	ErrPeerUnreachable = errors.New("reliable: peer unreachable")
+
+	// ErrPeerClosed is returned by Write after the peer closed its side of the
+	// connection.
+	ErrPeerClosed = errors.New("reliable: peer closed the connection")
+)
+
+// Config controls the behavior of a Conn. Zero fields use their defaults.
+type Config struct {
+	// MaxPayload is the maximum number of data bytes sent in a single
+	// datagram. Larger writes are split. Defaults to 1200 (which keeps
+	// datagrams under the usual Internet MTU).
+	MaxPayload int
+
+	// Window is the maximum number of unacknowledged datagrams in flight and
+	// of received datagrams buffered while waiting to be read. Defaults to
+	// 256.
+	Window int
+
+	// MinRTO and MaxRTO bound the retransmission timeout derived from the
+	// round-trip time estimation. Default to 20ms and 2s.
+	MinRTO time.Duration
+	MaxRTO time.Duration
+
+	// MaxRetransmits is the number of times a datagram is retransmitted
+	// without receiving anything from the peer before the connection fails
+	// with ErrPeerUnreachable. Datagrams are retransmitted for as long as the
+	// peer is alive, even if its reader is too slow to take them. Defaults to
+	// 10.
+	MaxRetransmits int
+
+	// CloseTimeout is how long Close waits for written data and for the
+	// close notification to be acknowledged. Defaults to 1s.
+	CloseTimeout time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxPayload <= 0 {
+		c.MaxPayload = 1200
+	}
+
+	if c.Window <= 0 {
+		c.Window = 256
+	}
+
+	if c.MinRTO <= 0 {
+		c.MinRTO = 20 * time.Millisecond
+	}
+
+	if c.MaxRTO < c.MinRTO {
+		c.MaxRTO = 2 * time.Second
+		if c.MaxRTO < c.MinRTO {
+			c.MaxRTO = c.MinRTO
+		}
+	}
+
+	if c.MaxRetransmits <= 0 {
+		c.MaxRetransmits = 10
+	}
+
+	if c.CloseTimeout <= 0 {
+		c.CloseTimeout = time.Second
+	}
+
+	return c
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// SetAcceptors sets the number of listeners this Server opens on the same
+// address (with SO_REUSEPORT) for stream networks, each one with its own
+// accept loop. This allows the kernel to balance incoming connections among
+// them. Values smaller than 2 use a single listener. Only TCP networks support
+// more than one acceptor, so an error is returned for other networks. Ignored
+// for servers created with an existing listener. It must be called before
+// Start.
+func (s *Server) SetAcceptors(acceptors int) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.started {
+		return fmt.Errorf("server already started")
+	}
+
+	if acceptors > 1 && s.listener == nil && s.packetConn == nil &&
+		!isTCPNetwork(s.network) {
+		return fmt.Errorf("multiple acceptors not supported for network %q",
+			s.network)
+	}
+
+	s.acceptors = acceptors
+
+	return nil
+}
+
+// listenReusePort creates count listeners bound to the same network and
+// address with SO_REUSEPORT set.
+func listenReusePort(network, address string,
+	count int) ([]net.Listener, error) {
+	listenConfig := net.ListenConfig{
+		Control: setReusePort,
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		listener, err := listenConfig.Listen(context.Background(), network,
+			address)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+
+			return nil, err
+		}
+
+		if i == 0 {
+			// Make sure all listeners use the same port if a random one was
+			// requested.
+			address = listener.Addr().String()
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// isTCPNetwork returns true if the given network is a TCP network.
+func isTCPNetwork(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return true
+	}
+
+	return false
+}
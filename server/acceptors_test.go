@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestSetAcceptors(t *testing.T) {
+	s, err := New("tcp", "127.0.0.1:0", func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.SetAcceptors(4)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	if len(s.listeners) != 4 {
+		t.Errorf("expected 4 listeners, got %d", len(s.listeners))
+	}
+
+	err = s.SetAcceptors(1)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestSetAcceptors_NonTCP(t *testing.T) {
+	for _, network := range []string{"unix", "udp"} {
+		s, err := New(network, "", func(net.Conn) {})
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		err = s.SetAcceptors(2)
+		if err == nil {
+			t.Errorf("expected non-nil error for %s, got nil", network)
+		}
+
+		err = s.SetAcceptors(1)
+		if err != nil {
+			t.Errorf("expected nil error for %s, got %v", network, err)
+		}
+	}
+}
+
+func TestAcceptors(t *testing.T) {
+	s, err := New("tcp", "127.0.0.1:0", func(conn net.Conn) {
+		conn.Write([]byte("hello"))
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	s.SetAcceptors(4)
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn, err := net.Dial("tcp", s.Addr().String())
+			if err != nil {
+				t.Errorf("expected nil error, got %v", err)
+				return
+			}
+			defer conn.Close()
+
+			buffer := make([]byte, 5)
+			_, err = conn.Read(buffer)
+			if err != nil || string(buffer) != "hello" {
+				t.Errorf("expected 'hello', got %q (%v)", buffer, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func BenchmarkAccept(b *testing.B) {
+	for _, acceptors := range []int{1, 4} {
+		b.Run(fmt.Sprintf("Acceptors%d", acceptors), func(b *testing.B) {
+			s, err := New("tcp", "127.0.0.1:0", func(conn net.Conn) {
+				conn.Close()
+			})
+			if err != nil {
+				b.Fatalf("expected nil error, got %v", err)
+			}
+
+			s.SetAcceptors(acceptors)
+
+			err = s.Start()
+			if err != nil {
+				b.Fatalf("expected nil error, got %v", err)
+			}
+			defer s.Stop()
+
+			addr := s.Addr().String()
+
+			b.SetParallelism(8)
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				buffer := make([]byte, 1)
+				for pb.Next() {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						b.Errorf("expected nil error, got %v", err)
+						return
+					}
+
+					// Wait for the server to accept and close it.
+					conn.Read(buffer)
+					conn.Close()
+				}
+			})
+		})
+	}
+}
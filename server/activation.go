@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// NewFromFile creates a new Server instance that will use the socket
+// referenced by the given file (for example, one inherited from a parent
+// process) and that will call the given connectionHandler to handle incoming
+// connections. Both stream (listening) and packet sockets are supported. The
+// file is duplicated, so the caller is free to close it after NewFromFile
+// returns.
+func NewFromFile(f *os.File,
+	connectionHandler ConnectionHandler) (*Server, error) {
+	if f == nil {
+		return nil, fmt.Errorf("file cannot be nil")
+	}
+
+	if connectionHandler == nil {
+		return nil, fmt.Errorf("connectionHandler cannot be nil")
+	}
+
+	// net.FileListener also succeeds for datagram unix sockets, which would
+	// then never be served.
+	if isDatagramSocket(f) {
+		packetConn, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewWithPacketConn(packetConn, connectionHandler)
+	}
+
+	listener, listenerErr := net.FileListener(f)
+	if listenerErr == nil {
+		return NewWithListener(listener, connectionHandler)
+	}
+
+	packetConn, packetConnErr := net.FilePacketConn(f)
+	if packetConnErr == nil {
+		return NewWithPacketConn(packetConn, connectionHandler)
+	}
+
+	return nil, fmt.Errorf("file %q is not a listener (%v) nor a packet "+
+		"connection (%v)", f.Name(), listenerErr, packetConnErr)
+}
+
+// ActivationFiles returns the files passed to this process through systemd
+// socket activation (LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES). Each file
+// Name is set to the associated LISTEN_FDNAMES entry (or "LISTEN_FD_<fd>" if
+// there is none). It returns an empty slice if the process was not socket
+// activated. If unsetEnv is true, the environment variables are unset so they
+// are not passed to child processes.
+func ActivationFiles(unsetEnv bool) ([]*os.File, error) {
+	if unsetEnv {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+	}
+
+	return activationFiles(os.Getenv, os.Getpid(), listenFDsStart)
+}
+
+// NewFromActivation creates one Server instance for each file returned by
+// ActivationFiles, all of them calling the given connectionHandler to handle
+// incoming connections.
+func NewFromActivation(connectionHandler ConnectionHandler) ([]*Server, error) {
+	files, err := ActivationFiles(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFromFiles(files, connectionHandler)
+}
+
+func newFromFiles(files []*os.File,
+	connectionHandler ConnectionHandler) ([]*Server, error) {
+	servers := make([]*Server, 0, len(files))
+	for i, f := range files {
+		s, err := NewFromFile(f, connectionHandler)
+		f.Close()
+		if err != nil {
+			for _, f := range files[i+1:] {
+				f.Close()
+			}
+
+			for _, s := range servers {
+				s.closeSockets()
+			}
+
+			return nil, err
+		}
+
+		servers = append(servers, s)
+	}
+
+	return servers, nil
+}
+
+func activationFiles(getenv func(string) string, pid int,
+	start int) ([]*os.File, error) {
+	listenPID := getenv("LISTEN_PID")
+	if listenPID != "" {
+		p, err := strconv.Atoi(listenPID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LISTEN_PID %q: %w", listenPID, err)
+		}
+
+		if p != pid {
+			// Meant for some other process.
+			return nil, nil
+		}
+	}
+
+	listenFDs := getenv("LISTEN_FDS")
+	if listenFDs == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", listenFDs)
+	}
+
+	var names []string
+	if listenFDNames := getenv("LISTEN_FDNAMES"); listenFDNames != "" {
+		names = strings.Split(listenFDNames, ":")
+	}
+
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		fd := start + i
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+
+	return files, nil
+}
@@ -0,0 +1,244 @@
+//go:build !windows && !plan9 && !js
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestNewFromFile_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	f, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer f.Close()
+
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = NewFromFile(nil, func(net.Conn) {})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	connCh := make(chan net.Conn)
+	s, err := NewFromFile(f, func(conn net.Conn) {
+		connCh <- conn
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	if s.Addr().String() != addr {
+		t.Errorf("expected %v, got %v", addr, s.Addr())
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer conn.Close()
+
+	serverConn := <-connCh
+	serverConn.Close()
+}
+
+func TestNewFromFile_UDP(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	f, err := packetConn.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer f.Close()
+
+	addr := packetConn.LocalAddr().String()
+	packetConn.Close()
+
+	dataCh := make(chan string)
+	s, err := NewFromFile(f, func(conn net.Conn) {
+		buffer := make([]byte, 16)
+		n, _ := conn.Read(buffer)
+		dataCh <- string(buffer[:n])
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("hello"))
+
+	data := <-dataCh
+	if data != "hello" {
+		t.Errorf("expected 'hello', got %v", data)
+	}
+}
+
+func TestNewFromFile_Unixgram(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "unixgram.sock")
+	packetConn, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	f, err := packetConn.(*net.UnixConn).File()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer f.Close()
+
+	packetConn.Close()
+
+	s, err := NewFromFile(f, func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if s.packetConn == nil || s.listener != nil {
+		t.Errorf("expected a packet server, got listener %v", s.listener)
+	}
+
+	s.closeSockets()
+}
+
+func TestNewFromFiles_Error(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	f1, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	f3, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	addr := listener.Addr().String()
+	listener.Close()
+
+	// Not a socket.
+	f2, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	_, err = newFromFiles([]*os.File{f1, f2, f3}, func(net.Conn) {})
+	if err == nil {
+		t.Fatal("expected non-nil error, got nil")
+	}
+
+	if f3.Fd() != ^uintptr(0) {
+		t.Error("expected remaining file to be closed")
+	}
+
+	// The listener of the server already created is closed too.
+	conn, err := net.Dial("tcp", addr)
+	if err == nil {
+		conn.Close()
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestActivationFiles(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(key string) string {
+		return env[key]
+	}
+
+	files, err := activationFiles(getenv, 1, listenFDsStart)
+	if err != nil || len(files) != 0 {
+		t.Errorf("expected no files and nil error, got %v, %v", files, err)
+	}
+
+	env["LISTEN_FDS"] = "invalid"
+	_, err = activationFiles(getenv, 1, listenFDsStart)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	env["LISTEN_PID"] = "2"
+	env["LISTEN_FDS"] = "1"
+	files, err = activationFiles(getenv, 1, listenFDsStart)
+	if err != nil || len(files) != 0 {
+		t.Errorf("expected no files and nil error, got %v, %v", files, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer listener.Close()
+
+	f, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// Activation files take ownership of the file descriptor, so give them
+	// their own.
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	env["LISTEN_PID"] = strconv.Itoa(os.Getpid())
+	env["LISTEN_FDNAMES"] = "http"
+	files, err = activationFiles(getenv, os.Getpid(), fd)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+	if files[0].Name() != "http" {
+		t.Errorf("expected 'http', got %v", files[0].Name())
+	}
+
+	servers, err := newFromFiles(files, func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if len(servers) != 1 {
+		t.Fatalf("expected 1 server, got %d", len(servers))
+	}
+	if servers[0].network != "tcp" {
+		t.Errorf("expected 'tcp', got %v", servers[0].network)
+	}
+
+	servers[0].listener.Close()
+}
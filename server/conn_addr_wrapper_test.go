@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	testing2 "github.com/brunoga/net/testing"
+)
+
+func TestConnAddrWrapper_Conformance(t *testing.T) {
+	testing2.TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		localAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+		remoteAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 49152}
+
+		c1, c2 := net.Pipe()
+		c1 = newConnAddrWrapper(c1, localAddr, remoteAddr)
+		c2 = newConnAddrWrapper(c2, remoteAddr, localAddr)
+
+		return c1, c2, func() {
+			c1.Close()
+			c2.Close()
+		}, nil
+	})
+}
@@ -0,0 +1,326 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// maxHandoffFiles is the maximum number of files that can be passed in a
+// single handoff (the Linux limit for file descriptors in a SCM_RIGHTS
+// message is 253).
+const maxHandoffFiles = 250
+
+const (
+	handoffAck  byte = 1
+	handoffNack byte = 0
+)
+
+// handoffEntry describes one of the files passed during a handoff.
+type handoffEntry struct {
+	Server  bool   `json:"server"` // false for live connections.
+	Network string `json:"network"`
+}
+
+// Handoff passes the listening sockets of the given (started) servers, and
+// optionally the given live stream connections, to another process through
+// the given unix socket connection (using SCM_RIGHTS). The other process is
+// expected to call ReceiveHandoff. Once it acknowledges that it started
+// serving, all servers are shut down (see Server.Shutdown) using the given
+// context. Connections are not closed, as they might still have handlers
+// running in this process, but they should not be used after Handoff returns.
+// If the other process fails to start serving (or does not acknowledge it
+// before the context is done), the servers are not stopped and a non-nil
+// error is returned. Servers with multiple acceptors or packet sockets (see
+// SetAcceptors and SetPacketIO) cannot be handed off.
+func Handoff(ctx context.Context, conn *net.UnixConn, servers []*Server,
+	conns []net.Conn) error {
+	if len(servers)+len(conns) > maxHandoffFiles {
+		return fmt.Errorf("too many files to handoff (maximum is %d)",
+			maxHandoffFiles)
+	}
+
+	entries := make([]handoffEntry, 0, len(servers)+len(conns))
+	files := make([]*os.File, 0, len(servers)+len(conns))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, s := range servers {
+		s.m.Lock()
+		acceptors, packetSockets := len(s.listeners), len(s.packetConns)
+		s.m.Unlock()
+
+		if acceptors > 1 {
+			return fmt.Errorf("handoff of servers with multiple acceptors " +
+				"is not supported")
+		}
+
+		if packetSockets > 1 {
+			return fmt.Errorf("handoff of servers with multiple packet " +
+				"sockets is not supported")
+		}
+
+		f, err := s.File()
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, handoffEntry{true, s.network})
+		files = append(files, f)
+	}
+
+	for _, c := range conns {
+		filer, ok := c.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("%T does not support File", c)
+		}
+
+		f, err := filer.File()
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, handoffEntry{false, c.LocalAddr().Network()})
+		files = append(files, f)
+	}
+
+	header, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// File.Fd would put the sockets (shared with the servers, that keep
+	// serving if the handoff fails) in blocking mode.
+	fds := make([]int, len(files))
+	for i, f := range files {
+		rawConn, err := f.SyscallConn()
+		if err != nil {
+			return err
+		}
+
+		err = rawConn.Control(func(fd uintptr) {
+			fds[i] = int(fd)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	message := make([]byte, 4+len(header))
+	binary.BigEndian.PutUint32(message, uint32(len(header)))
+	copy(message[4:], header)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	// Unblock the write and read below if the context is done.
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stopCh:
+		}
+	}()
+
+	ack, err := sendHandoff(conn, message, fds)
+
+	close(stopCh)
+	<-doneCh
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("waiting for handoff acknowledgement: %w",
+			ctx.Err())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if ack != handoffAck {
+		return fmt.Errorf("handoff rejected by receiver")
+	}
+
+	var firstErr error
+	for _, s := range servers {
+		s.m.Lock()
+		if unixListener, ok := s.listener.(*net.UnixListener); ok {
+			// The socket file now belongs to the other process.
+			unixListener.SetUnlinkOnClose(false)
+		}
+		s.m.Unlock()
+
+		err := s.Shutdown(ctx)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// sendHandoff sends the given handoff message and file descriptors through
+// the given connection and returns the acknowledgement.
+func sendHandoff(conn *net.UnixConn, message []byte, fds []int) (byte,
+	error) {
+	_, _, err := conn.WriteMsgUnix(message, syscall.UnixRights(fds...), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	ack := make([]byte, 1)
+	_, err = conn.Read(ack)
+	if err != nil {
+		return 0, fmt.Errorf("waiting for handoff acknowledgement: %w", err)
+	}
+
+	return ack[0], nil
+}
+
+// ReceiveHandoff receives listening sockets and live connections sent by
+// another process calling Handoff through the given unix socket connection.
+// A started Server calling the given connectionHandler is created for each
+// listening socket and the process calling Handoff is notified. If any of the
+// servers fails to start, the ones already started are stopped, the other
+// process is told to continue serving and a non-nil error is returned.
+// Received live connections are returned as is and it is up to the caller to
+// handle them.
+func ReceiveHandoff(conn *net.UnixConn,
+	connectionHandler ConnectionHandler) ([]*Server, []net.Conn, error) {
+	servers, conns, err := receiveHandoff(conn, connectionHandler)
+	if err != nil {
+		for _, s := range servers {
+			s.Stop()
+		}
+
+		for _, c := range conns {
+			c.Close()
+		}
+
+		conn.Write([]byte{handoffNack})
+
+		return nil, nil, err
+	}
+
+	_, err = conn.Write([]byte{handoffAck})
+	if err != nil {
+		for _, s := range servers {
+			s.Stop()
+		}
+
+		for _, c := range conns {
+			c.Close()
+		}
+
+		return nil, nil, err
+	}
+
+	return servers, conns, nil
+}
+
+func receiveHandoff(conn *net.UnixConn,
+	connectionHandler ConnectionHandler) ([]*Server, []net.Conn, error) {
+	if connectionHandler == nil {
+		return nil, nil, fmt.Errorf("connectionHandler cannot be nil")
+	}
+
+	message := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(maxHandoffFiles*4))
+
+	n, oobn, _, _, err := conn.ReadMsgUnix(message, oob)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := parseUnixRights(oob[:oobn])
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if n < 4 || int(binary.BigEndian.Uint32(message))+4 != n {
+		return nil, nil, fmt.Errorf("invalid handoff message")
+	}
+
+	var entries []handoffEntry
+	err = json.Unmarshal(message[4:n], &entries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(entries) != len(files) {
+		return nil, nil, fmt.Errorf("expected %d files, got %d", len(entries),
+			len(files))
+	}
+
+	var servers []*Server
+	var conns []net.Conn
+	for i, entry := range entries {
+		if !entry.Server {
+			c, err := net.FileConn(files[i])
+			if err != nil {
+				return servers, conns, err
+			}
+
+			conns = append(conns, c)
+
+			continue
+		}
+
+		s, err := NewFromFile(files[i], connectionHandler)
+		if err != nil {
+			return servers, conns, err
+		}
+
+		err = s.Start()
+		if err != nil {
+			s.closeSockets()
+
+			return servers, conns, err
+		}
+
+		servers = append(servers, s)
+	}
+
+	return servers, conns, nil
+}
+
+func parseUnixRights(oob []byte) ([]*os.File, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*os.File
+	for i := range messages {
+		fds, err := syscall.ParseUnixRights(&messages[i])
+		if err != nil {
+			return files, err
+		}
+
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), "handoff"))
+		}
+	}
+
+	return files, nil
+}
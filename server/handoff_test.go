@@ -0,0 +1,257 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const handoffChildEnv = "SERVER_HANDOFF_CHILD_SOCKET"
+
+func echoWithPrefix(conn net.Conn, prefix string) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		_, err := conn.Write([]byte(prefix + scanner.Text() + "\n"))
+		if err != nil {
+			break
+		}
+	}
+
+	conn.Close()
+}
+
+// TestHandoffChild is the child process side of TestHandoff. It does nothing
+// when run directly.
+func TestHandoffChild(t *testing.T) {
+	socketPath := os.Getenv(handoffChildEnv)
+	if socketPath == "" {
+		t.Skip("only runs as a child process of TestHandoff")
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer conn.Close()
+
+	servers, conns, err := ReceiveHandoff(conn.(*net.UnixConn),
+		func(c net.Conn) {
+			c.Write([]byte("child\n"))
+			echoWithPrefix(c, "child:")
+		})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	for _, c := range conns {
+		go echoWithPrefix(c, "child:")
+	}
+
+	// Serve until the parent closes our stdin.
+	io.Copy(io.Discard, os.Stdin)
+
+	for _, s := range servers {
+		s.Stop()
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	if os.Getenv(handoffChildEnv) != "" {
+		t.Skip("running as a child process")
+	}
+
+	liveCh := make(chan net.Conn)
+	s, err := New("tcp", "127.0.0.1:0", func(conn net.Conn) {
+		conn.Write([]byte("parent\n"))
+		liveCh <- conn
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	addr := s.Addr().String()
+
+	// Establish a live connection that will be handed off.
+	clientConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer clientConn.Close()
+
+	reader := bufio.NewReader(clientConn)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "parent\n" {
+		t.Fatalf("expected 'parent', got %q (%v)", line, err)
+	}
+
+	liveConn := <-liveCh
+
+	socketPath := filepath.Join(t.TempDir(), "handoff.sock")
+	handoffListener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer handoffListener.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffChild$")
+	cmd.Env = append(os.Environ(), handoffChildEnv+"="+socketPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer func() {
+		stdin.Close()
+		cmd.Wait()
+	}()
+
+	handoffConn, err := handoffListener.Accept()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer handoffConn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = Handoff(ctx, handoffConn.(*net.UnixConn), []*Server{s},
+		[]net.Conn{liveConn})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	liveConn.Close()
+
+	// The live connection is now served by the child.
+	clientConn.Write([]byte("ping\n"))
+	line, err = reader.ReadString('\n')
+	if err != nil || line != "child:ping\n" {
+		t.Errorf("expected 'child:ping', got %q (%v)", line, err)
+	}
+
+	// And so are new connections.
+	newConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer newConn.Close()
+
+	line, err = bufio.NewReader(newConn).ReadString('\n')
+	if err != nil || line != "child\n" {
+		t.Errorf("expected 'child', got %q (%v)", line, err)
+	}
+}
+
+func handoffPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	socketPath := filepath.Join(t.TempDir(), "handoff.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer listener.Close()
+
+	conn1, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	conn2, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	return conn1.(*net.UnixConn), conn2.(*net.UnixConn)
+}
+
+func TestHandoff_Timeout(t *testing.T) {
+	s, err := New("tcp", "127.0.0.1:0", func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	// The receiver never acknowledges the handoff.
+	conn, receiverConn := handoffPair(t)
+	defer conn.Close()
+	defer receiverConn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+
+	err = Handoff(ctx, conn, []*Server{s}, nil)
+	if err == nil {
+		t.Fatal("expected non-nil error, got nil")
+	}
+
+	// The same happens when the context is canceled.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err = Handoff(ctx, conn, []*Server{s}, nil)
+	if err == nil {
+		t.Fatal("expected non-nil error, got nil")
+	}
+
+	// The server is still serving.
+	if s.Addr() == nil {
+		t.Error("expected started server, got stopped one")
+	}
+}
+
+func TestHandoff_MultipleSockets(t *testing.T) {
+	tcpServer, err := New("tcp", "127.0.0.1:0", func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	tcpServer.SetAcceptors(2)
+
+	udpServer, err := New("udp", "127.0.0.1:0", func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	udpServer.SetPacketIO(PacketIOConfig{Sockets: 2})
+
+	for _, s := range []*Server{tcpServer, udpServer} {
+		err = s.Start()
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		defer s.Stop()
+
+		conn, receiverConn := handoffPair(t)
+		defer conn.Close()
+		defer receiverConn.Close()
+
+		err = Handoff(context.Background(), conn, []*Server{s}, nil)
+		if err == nil {
+			t.Errorf("%s: expected non-nil error, got nil", s.network)
+		}
+	}
+}
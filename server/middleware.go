@@ -0,0 +1,262 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunoga/net/capture"
+	"github.com/brunoga/net/fragment"
+	"github.com/brunoga/net/record"
+	"github.com/brunoga/net/reliable"
+)
+
+// Middleware is the signature for functions that wrap a ConnectionHandler to
+// add cross-cutting behavior (logging, authorization, deadlines, etc). The
+// returned ConnectionHandler is used in place of the given one and is
+// responsible for calling it (or not) as appropriate.
+type Middleware func(ConnectionHandler) ConnectionHandler
+
+// chain wraps the given handler with the given middlewares. The first
+// middleware is the outermost one.
+func chain(handler ConnectionHandler, middlewares []Middleware) ConnectionHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// Recovery returns a Middleware that recovers from panics in the wrapped
+// handler. The connection is closed and, if onPanic is not nil, it is called
+// with the connection and the recovered value.
+func Recovery(onPanic func(net.Conn, interface{})) Middleware {
+	return func(next ConnectionHandler) ConnectionHandler {
+		return func(conn net.Conn) {
+			defer func() {
+				if r := recover(); r != nil {
+					conn.Close()
+
+					if onPanic != nil {
+						onPanic(conn, r)
+					}
+				}
+			}()
+
+			next(conn)
+		}
+	}
+}
+
+// AccessLog returns a Middleware that logs one line per connection to the
+// given logger (or the standard logger if nil) once the wrapped handler
+// returns. The line includes the local and remote addresses, the number of
+// bytes read and written and the connection duration.
+func AccessLog(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return func(next ConnectionHandler) ConnectionHandler {
+		return func(conn net.Conn) {
+			start := time.Now()
+			counter := &countingConn{Conn: conn}
+
+			next(counter)
+
+			logger.Printf("%s %s -> %s read=%d written=%d duration=%s",
+				conn.LocalAddr().Network(), conn.RemoteAddr(), conn.LocalAddr(),
+				atomic.LoadInt64(&counter.read),
+				atomic.LoadInt64(&counter.written), time.Since(start))
+		}
+	}
+}
+
+// IdleTimeout returns a Middleware that closes connections that have not
+// read or written any data for the given timeout. The deadline is extended
+// every time a Read or Write happens. A timeout smaller than or equal to 0
+// disables it (connections are passed through unchanged).
+func IdleTimeout(timeout time.Duration) Middleware {
+	return func(next ConnectionHandler) ConnectionHandler {
+		if timeout <= 0 {
+			return next
+		}
+
+		return func(conn net.Conn) {
+			idleConn := &idleTimeoutConn{Conn: conn, timeout: timeout}
+			idleConn.extend()
+
+			next(idleConn)
+		}
+	}
+}
+
+// Reliable returns a Middleware that adds the reliability layer implemented
+// by package reliable to connections, so the wrapped handler gets reliable,
+// ordered delivery. It is meant for packet networks and peers must also use
+// package reliable (see client.NewReliable).
+func Reliable(config reliable.Config) Middleware {
+	return func(next ConnectionHandler) ConnectionHandler {
+		return func(conn net.Conn) {
+			reliableConn := reliable.Wrap(conn, config)
+
+			next(reliableConn)
+
+			reliableConn.Close()
+		}
+	}
+}
+
+// Fragmentation returns a Middleware that adds the fragmentation layer
+// implemented by package fragment to connections, so the wrapped handler can
+// read and write messages larger than a single datagram. It is meant for
+// packet networks and peers must also use package fragment (see
+// client.Client.SetFragmentation).
+func Fragmentation(config fragment.Config) Middleware {
+	return func(next ConnectionHandler) ConnectionHandler {
+		return func(conn net.Conn) {
+			next(fragment.Wrap(conn, config))
+		}
+	}
+}
+
+// Record returns a Middleware that records the traffic of each connection
+// (see package record) to the io.WriteCloser returned by open for it, which
+// is closed once the wrapped handler returns. If open returns an error, the
+// connection is handled without being recorded.
+func Record(open func(conn net.Conn) (io.WriteCloser, error)) Middleware {
+	return func(next ConnectionHandler) ConnectionHandler {
+		return func(conn net.Conn) {
+			output, err := open(conn)
+			if err != nil {
+				next(conn)
+				return
+			}
+			defer output.Close()
+
+			w, err := record.NewWriter(output)
+			if err != nil {
+				next(conn)
+				return
+			}
+
+			next(record.Wrap(conn, w))
+		}
+	}
+}
+
+// Capture returns a Middleware that captures the traffic of connections
+// (including packet sessions) to the given Writer in pcap format (see package
+// capture). Data is captured as seen by the middlewares after it, so it
+// should come before middlewares that change the data (like Reliable) to
+// capture what goes through the network.
+func Capture(w *capture.Writer) Middleware {
+	return func(next ConnectionHandler) ConnectionHandler {
+		return func(conn net.Conn) {
+			next(capture.Wrap(conn, w))
+		}
+	}
+}
+
+// IPAllowlist returns a Middleware that only calls the wrapped handler for
+// connections with a remote IP contained in one of the given networks.
+// Other connections are closed immediately. Invalid CIDRs are reported as
+// errors.
+func IPAllowlist(cidrs ...string) (Middleware, error) {
+	ipNets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+
+		ipNets = append(ipNets, ipNet)
+	}
+
+	return func(next ConnectionHandler) ConnectionHandler {
+		return func(conn net.Conn) {
+			ip := addrIP(conn.RemoteAddr())
+			if ip != nil {
+				for _, ipNet := range ipNets {
+					if ipNet.Contains(ip) {
+						next(conn)
+						return
+					}
+				}
+			}
+
+			conn.Close()
+		}
+	}, nil
+}
+
+// addrIP returns the IP associated with the given address or nil if there is
+// none.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	return net.ParseIP(host)
+}
+
+// countingConn is a net.Conn wrapper that counts bytes read and written.
+type countingConn struct {
+	net.Conn
+
+	read    int64
+	written int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+
+	return n, err
+}
+
+// idleTimeoutConn is a net.Conn wrapper that extends its deadline on every
+// Read and Write.
+type idleTimeoutConn struct {
+	net.Conn
+
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	c.extend()
+
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	c.extend()
+
+	return c.Conn.Write(b)
+}
+
+func (c *idleTimeoutConn) extend() {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+}
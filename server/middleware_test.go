@@ -0,0 +1,448 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brunoga/net/capture"
+	"github.com/brunoga/net/client"
+	"github.com/brunoga/net/fragment"
+	"github.com/brunoga/net/reliable"
+
+	testing2 "github.com/brunoga/net/testing"
+)
+
+func TestUse(t *testing.T) {
+	var order []string
+	middleware := func(name string) Middleware {
+		return func(next ConnectionHandler) ConnectionHandler {
+			return func(conn net.Conn) {
+				order = append(order, name)
+				next(conn)
+			}
+		}
+	}
+
+	doneCh := make(chan struct{})
+	s, err := New("tcp", "", func(net.Conn) {
+		order = append(order, "handler")
+		close(doneCh)
+	})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	s.Use(middleware("first"), middleware("second"))
+
+	connCh := make(chan net.Conn)
+	s.listen = func(string, string) (net.Listener, error) {
+		return &testing2.MockListener{
+			AcceptFunc: func() (net.Conn, error) {
+				conn := <-connCh
+				if conn == nil {
+					return nil, fmt.Errorf("accept error")
+				}
+
+				return conn, nil
+			},
+			CloseFunc: func() error {
+				close(connCh)
+				return nil
+			},
+		}, nil
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	_, remoteConn := net.Pipe()
+	connCh <- remoteConn
+
+	<-doneCh
+
+	if strings.Join(order, ",") != "first,second,handler" {
+		t.Errorf("expected 'first,second,handler', got %v",
+			strings.Join(order, ","))
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	var recovered interface{}
+	handler := Recovery(func(conn net.Conn, r interface{}) {
+		recovered = r
+	})(func(net.Conn) {
+		panic("boom")
+	})
+
+	localConn, remoteConn := net.Pipe()
+	defer localConn.Close()
+
+	handler(remoteConn)
+
+	if recovered != "boom" {
+		t.Errorf("expected 'boom', got %v", recovered)
+	}
+
+	_, err := remoteConn.Write([]byte("x"))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := log.New(&buffer, "", 0)
+
+	handler := AccessLog(logger)(func(conn net.Conn) {
+		conn.Write([]byte("hello"))
+	})
+
+	localConn, remoteConn := net.Pipe()
+	defer localConn.Close()
+
+	go func() {
+		b := make([]byte, 5)
+		localConn.Read(b)
+	}()
+
+	handler(newConnAddrWrapper(remoteConn, &net.TCPAddr{
+		IP: net.IPv4(127, 0, 0, 1), Port: 1}, &net.TCPAddr{
+		IP: net.IPv4(127, 0, 0, 2), Port: 2}))
+
+	line := buffer.String()
+	if !strings.Contains(line, "127.0.0.2:2 -> 127.0.0.1:1") {
+		t.Errorf("expected addresses in log line, got %v", line)
+	}
+	if !strings.Contains(line, "written=5") {
+		t.Errorf("expected 'written=5' in log line, got %v", line)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	errCh := make(chan error)
+	handler := IdleTimeout(10 * time.Millisecond)(func(conn net.Conn) {
+		_, err := conn.Read(make([]byte, 1))
+		errCh <- err
+	})
+
+	localConn, remoteConn := net.Pipe()
+	defer localConn.Close()
+
+	go handler(remoteConn)
+
+	select {
+	case err := <-errCh:
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Errorf("expected timeout error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected read to time out")
+	}
+}
+
+func TestIdleTimeout_Disabled(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		localConn, remoteConn := net.Pipe()
+
+		IdleTimeout(timeout)(func(conn net.Conn) {
+			if conn != remoteConn {
+				t.Errorf("%v: expected unwrapped conn, got %T", timeout,
+					conn)
+			}
+
+			go localConn.Write([]byte("a"))
+
+			_, err := conn.Read(make([]byte, 1))
+			if err != nil {
+				t.Errorf("%v: expected nil error, got %v", timeout, err)
+			}
+		})(remoteConn)
+
+		localConn.Close()
+	}
+}
+
+func TestReliable(t *testing.T) {
+	s, err := New("udp", "127.0.0.1:0", func(conn net.Conn) {
+		if _, ok := conn.(*reliable.Conn); !ok {
+			t.Errorf("expected *reliable.Conn, got %T", conn)
+		}
+
+		io.Copy(conn, conn)
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	s.Use(Reliable(reliable.Config{}))
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	linesCh := make(chan string, 100)
+	c, err := client.NewReliable("udp", s.Addr().String(), reliable.Config{},
+		bufio.ScanLines, func(data []byte) {
+			linesCh <- string(data)
+		})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer c.Stop()
+
+	for i := 0; i < 100; i++ {
+		err = c.Send([]byte(fmt.Sprintf("line %d\n", i)))
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		select {
+		case line := <-linesCh:
+			if expected := fmt.Sprintf("line %d", i); line != expected {
+				t.Fatalf("expected %q, got %q", expected, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected line %d, got nothing", i)
+		}
+	}
+}
+
+func TestFragmentation(t *testing.T) {
+	s, err := New("udp", "127.0.0.1:0", func(conn net.Conn) {
+		if _, ok := conn.(*fragment.Conn); !ok {
+			t.Errorf("expected *fragment.Conn, got %T", conn)
+		}
+
+		buffer := make([]byte, 65536)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				return
+			}
+
+			conn.Write(buffer[:n])
+		}
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	s.Use(Fragmentation(fragment.Config{}))
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	messageCh := make(chan []byte, 1)
+	c, err := client.NewPacket("udp", s.Addr().String(), func(data []byte) {
+		messageCh <- append([]byte(nil), data...)
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.SetFragmentation(fragment.Config{})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = c.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer c.Stop()
+
+	// Larger than the default packet buffer size, so it would be truncated
+	// without fragmentation. Datagrams might be lost, so retry.
+	message := bytes.Repeat([]byte("0123456789"), 1000)
+	for attempt := 0; attempt < 10; attempt++ {
+		err = c.Send(message)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		select {
+		case received := <-messageCh:
+			if !bytes.Equal(received, message) {
+				t.Fatalf("expected %d bytes, got %d", len(message),
+					len(received))
+			}
+
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	t.Error("expected echoed message, got nothing")
+}
+
+// recording is an io.WriteCloser that signals when it is closed.
+type recording struct {
+	bytes.Buffer
+
+	closedCh chan struct{}
+}
+
+func (r *recording) Close() error {
+	close(r.closedCh)
+
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	handler := func(conn net.Conn) {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			conn.Write([]byte(strings.ToUpper(scanner.Text()) + "\n"))
+		}
+
+		conn.Close()
+	}
+
+	s, err := New("tcp", "", handler)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	output := &recording{closedCh: make(chan struct{})}
+	s.Use(Record(func(net.Conn) (io.WriteCloser, error) {
+		return output, nil
+	}))
+
+	network := testing2.NewNetwork()
+	s.SetNetwork(network)
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := network.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	for _, line := range []string{"hello\n", "world\n"} {
+		conn.Write([]byte(line))
+		reader.ReadString('\n')
+	}
+
+	conn.Close()
+
+	<-output.closedCh
+
+	// Replaying the recording against the handler produces the same output.
+	replayConn, err := testing2.NewReplayConn(&output.Buffer, 0)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	go handler(replayConn)
+
+	err = replayConn.Wait(time.Second)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	doneCh := make(chan struct{})
+	s, err := New("udp", "", func(conn net.Conn) {
+		buffer := make([]byte, 64)
+		n, _ := conn.Read(buffer)
+		conn.Write(bytes.ToUpper(buffer[:n]))
+		close(doneCh)
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	var buffer bytes.Buffer
+	w, err := capture.NewWriter(&buffer, 0)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	s.Use(Capture(w))
+
+	network := testing2.NewNetwork()
+	s.SetNetwork(network)
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := network.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("hello"))
+
+	<-doneCh
+
+	// Two UDP packets (16 bytes record header, 14 bytes Ethernet header, 20
+	// bytes IPv4 header, 8 bytes UDP header and 5 bytes payload each).
+	captured := buffer.Bytes()
+	if len(captured) != 24+2*(16+14+20+8+5) ||
+		!bytes.HasSuffix(captured, []byte("HELLO")) {
+		t.Errorf("expected 2 packets, got %x", captured)
+	}
+}
+
+func TestIPAllowlist(t *testing.T) {
+	_, err := IPAllowlist("invalid")
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	middleware, err := IPAllowlist("10.0.0.0/8")
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	called := false
+	handler := middleware(func(net.Conn) {
+		called = true
+	})
+
+	_, remoteConn := net.Pipe()
+	handler(newConnAddrWrapper(remoteConn, nil, &net.UDPAddr{
+		IP: net.IPv4(192, 168, 0, 1), Port: 1}))
+	if called {
+		t.Error("expected handler not to be called")
+	}
+
+	_, remoteConn = net.Pipe()
+	handler(newConnAddrWrapper(remoteConn, nil, &net.UDPAddr{
+		IP: net.IPv4(10, 1, 2, 3), Port: 1}))
+	if !called {
+		t.Error("expected handler to be called")
+	}
+}
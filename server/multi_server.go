@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Endpoint is a network and address pair a MultiServer listens at.
+type Endpoint struct {
+	Network string
+	Address string
+}
+
+func (e Endpoint) String() string {
+	return e.Network + "://" + e.Address
+}
+
+// EndpointConnectionHandler is the signature for functions that will handle
+// MultiServer connections. It is identical to ConnectionHandler but also
+// receives the Endpoint the connection came from.
+type EndpointConnectionHandler func(Endpoint, net.Conn)
+
+// MultiServer is a server that serves the same handler over a set of
+// endpoints (for example, TCP, UDP and an unix socket at the same time). All
+// endpoints share the same lifecycle: they are started and stopped together.
+type MultiServer struct {
+	endpoints []Endpoint
+	servers   []*Server
+
+	m       sync.Mutex
+	started bool
+}
+
+// NewMulti creates a new MultiServer instance that will try to listen at all
+// the given endpoints and that will call the given connectionHandler to handle
+// incoming connections in any of them. Note that NewMulti only validates that
+// there is at least one endpoint and that connectionHandler is not nil. All
+// other errors will be reported when Start is called.
+func NewMulti(endpoints []Endpoint,
+	connectionHandler EndpointConnectionHandler) (*MultiServer, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("endpoints cannot be empty")
+	}
+
+	if connectionHandler == nil {
+		return nil, fmt.Errorf("connectionHandler cannot be nil")
+	}
+
+	m := &MultiServer{
+		endpoints: append([]Endpoint(nil), endpoints...),
+		servers:   make([]*Server, 0, len(endpoints)),
+	}
+
+	for _, endpoint := range m.endpoints {
+		endpoint := endpoint
+
+		s, err := New(endpoint.Network, endpoint.Address, func(conn net.Conn) {
+			connectionHandler(endpoint, conn)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		m.servers = append(m.servers, s)
+	}
+
+	return m, nil
+}
+
+// Endpoints returns the endpoints associated with this MultiServer.
+func (m *MultiServer) Endpoints() []Endpoint {
+	return append([]Endpoint(nil), m.endpoints...)
+}
+
+// Use appends the given middlewares to the chain of every endpoint. See
+// Server.Use for details.
+func (m *MultiServer) Use(middlewares ...Middleware) {
+	for _, s := range m.servers {
+		s.Use(middlewares...)
+	}
+}
+
+// Start tries to start listening for incoming connections at all endpoints.
+// If any of them fails to start, the ones already started are stopped and
+// the error is returned. It returns a nil error on success.
+func (m *MultiServer) Start() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.started {
+		return fmt.Errorf("server already started")
+	}
+
+	for i, s := range m.servers {
+		err := s.Start()
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				m.servers[j].Stop()
+			}
+
+			return fmt.Errorf("%s: %w", m.endpoints[i], err)
+		}
+	}
+
+	m.started = true
+
+	return nil
+}
+
+// Stop tries to stop listening for connections at all endpoints. All
+// endpoints are stopped even if some of them fail. It returns the first error
+// found, if any.
+func (m *MultiServer) Stop() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if !m.started {
+		return fmt.Errorf("server not started")
+	}
+
+	var firstErr error
+	for i, s := range m.servers {
+		err := s.Stop()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", m.endpoints[i], err)
+		}
+	}
+
+	m.started = false
+
+	return firstErr
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	testing2 "github.com/brunoga/net/testing"
+)
+
+func TestNewMulti(t *testing.T) {
+	_, err := NewMulti(nil, func(Endpoint, net.Conn) {})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, err = NewMulti([]Endpoint{{"tcp", ""}}, nil)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	m, err := NewMulti([]Endpoint{{"tcp", ""}, {"udp", ""}},
+		func(Endpoint, net.Conn) {})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+	if len(m.Endpoints()) != 2 {
+		t.Errorf("expected 2 endpoints, got %d", len(m.Endpoints()))
+	}
+}
+
+func TestMultiStart_Rollback(t *testing.T) {
+	m, err := NewMulti([]Endpoint{{"tcp", "a"}, {"udp", "b"}},
+		func(Endpoint, net.Conn) {})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	closed := false
+	ch := make(chan struct{})
+	m.servers[0].listen = func(string, string) (net.Listener, error) {
+		return &testing2.MockListener{
+			AcceptFunc: func() (net.Conn, error) {
+				<-ch
+				return nil, fmt.Errorf("accept error")
+			},
+			CloseFunc: func() error {
+				closed = true
+				close(ch)
+				return nil
+			},
+		}, nil
+	}
+	m.servers[1].listenPacket = func(string, string) (net.PacketConn, error) {
+		return nil, fmt.Errorf("listenPacket error")
+	}
+
+	err = m.Start()
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	if !closed {
+		t.Error("expected first endpoint to be stopped")
+	}
+
+	err = m.Stop()
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestMultiConnection(t *testing.T) {
+	endpointCh := make(chan Endpoint)
+	m, err := NewMulti([]Endpoint{{"tcp", "a"}, {"unix", "b"}},
+		func(endpoint Endpoint, conn net.Conn) {
+			endpointCh <- endpoint
+		})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	connChs := make([]chan net.Conn, len(m.servers))
+	for i, s := range m.servers {
+		connCh := make(chan net.Conn)
+		connChs[i] = connCh
+		s.listen = func(string, string) (net.Listener, error) {
+			return &testing2.MockListener{
+				AcceptFunc: func() (net.Conn, error) {
+					conn := <-connCh
+					if conn == nil {
+						return nil, fmt.Errorf("accept error")
+					}
+
+					return conn, nil
+				},
+				CloseFunc: func() error {
+					close(connCh)
+					return nil
+				},
+			}, nil
+		}
+	}
+
+	err = m.Start()
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+	defer m.Stop()
+
+	err = m.Start()
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, remoteConn := net.Pipe()
+	connChs[1] <- remoteConn
+
+	endpoint := <-endpointCh
+	if endpoint != (Endpoint{"unix", "b"}) {
+		t.Errorf("expected unix://b, got %v", endpoint)
+	}
+}
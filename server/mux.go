@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMuxMaxPeek     = 64
+	defaultMuxPeekTimeout = 5 * time.Second
+)
+
+// MatchResult is the result of a Matcher checking the first bytes of a
+// connection.
+type MatchResult int
+
+const (
+	// MatchNeedMore indicates that more bytes are needed to decide.
+	MatchNeedMore MatchResult = iota
+
+	// Match indicates that the bytes match.
+	Match
+
+	// NoMatch indicates that the bytes do not match.
+	NoMatch
+)
+
+// Matcher is the signature for functions that check the first bytes received
+// in a connection to decide if it should be handled by the associated
+// ConnectionHandler.
+type Matcher func(peeked []byte) MatchResult
+
+// Mux dispatches connections to different ConnectionHandlers based on their
+// first bytes. Its ServeConn method is itself a ConnectionHandler, so it can
+// be used directly with a Server:
+//
+//	mux := server.NewMux(nil)
+//	mux.Handle(server.TLSMatcher(), tlsHandler)
+//	mux.Handle(server.HTTPMatcher(), healthHandler)
+//	s, err := server.New("tcp", ":8080", mux.ServeConn)
+//
+// Bytes read while matching are replayed to the selected handler.
+type Mux struct {
+	fallback ConnectionHandler
+
+	m           sync.RWMutex
+	routes      []muxRoute
+	maxPeek     int
+	peekTimeout time.Duration
+}
+
+type muxRoute struct {
+	matcher Matcher
+	handler ConnectionHandler
+}
+
+// NewMux creates a new Mux instance. Connections that do not match any
+// registered Matcher are handled by the given fallback handler. If fallback
+// is nil, they are just closed.
+func NewMux(fallback ConnectionHandler) *Mux {
+	return &Mux{
+		fallback:    fallback,
+		maxPeek:     defaultMuxMaxPeek,
+		peekTimeout: defaultMuxPeekTimeout,
+	}
+}
+
+// Handle registers the given handler to be called for connections accepted by
+// the given matcher. Matchers are checked in registration order and the
+// first one to match wins.
+func (m *Mux) Handle(matcher Matcher, handler ConnectionHandler) error {
+	if matcher == nil {
+		return fmt.Errorf("matcher cannot be nil")
+	}
+
+	if handler == nil {
+		return fmt.Errorf("handler cannot be nil")
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.routes = append(m.routes, muxRoute{matcher, handler})
+
+	return nil
+}
+
+// SetMaxPeek sets the maximum number of bytes that will be read while trying
+// to match a connection. It must be positive. Defaults to 64.
+func (m *Mux) SetMaxPeek(maxPeek int) error {
+	if maxPeek <= 0 {
+		return fmt.Errorf("invalid maxPeek %d", maxPeek)
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.maxPeek = maxPeek
+
+	return nil
+}
+
+// SetPeekTimeout sets the maximum amount of time to wait for enough bytes to
+// match a connection. Defaults to 5 seconds.
+func (m *Mux) SetPeekTimeout(peekTimeout time.Duration) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.peekTimeout = peekTimeout
+}
+
+// ServeConn is a ConnectionHandler that reads the first bytes from the given
+// connection and dispatches it to the appropriate handler.
+func (m *Mux) ServeConn(conn net.Conn) {
+	m.m.RLock()
+	routes := m.routes
+	maxPeek := m.maxPeek
+	peekTimeout := m.peekTimeout
+	m.m.RUnlock()
+
+	if peekTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(peekTimeout))
+	}
+
+	buffer := make([]byte, 0, maxPeek)
+	handler := m.fallback
+	for {
+		route, needMore := matchRoutes(routes, buffer)
+		if route != nil {
+			handler = route.handler
+			break
+		}
+
+		if !needMore || len(buffer) == maxPeek {
+			break
+		}
+
+		n, err := conn.Read(buffer[len(buffer):maxPeek])
+		buffer = buffer[:len(buffer)+n]
+		if err != nil {
+			// Give matchers one last chance with what we have.
+			if route, _ := matchRoutes(routes, buffer); route != nil {
+				handler = route.handler
+			}
+
+			break
+		}
+	}
+
+	if peekTimeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	if handler == nil {
+		conn.Close()
+		return
+	}
+
+	handler(newPeekedConn(conn, buffer))
+}
+
+// matchRoutes returns the first route that matches the given bytes, provided
+// no earlier route still needs more bytes to decide. needMore is true if any
+// route needs more bytes.
+func matchRoutes(routes []muxRoute, peeked []byte) (*muxRoute, bool) {
+	needMore := false
+	for i := range routes {
+		switch routes[i].matcher(peeked) {
+		case Match:
+			if !needMore {
+				return &routes[i], false
+			}
+		case MatchNeedMore:
+			needMore = true
+		}
+	}
+
+	return nil, needMore
+}
+
+// PrefixMatcher returns a Matcher that matches connections starting with any
+// of the given prefixes.
+func PrefixMatcher(prefixes ...[]byte) Matcher {
+	return func(peeked []byte) MatchResult {
+		result := NoMatch
+		for _, prefix := range prefixes {
+			if len(peeked) < len(prefix) {
+				if bytes.HasPrefix(prefix, peeked) {
+					result = MatchNeedMore
+				}
+
+				continue
+			}
+
+			if bytes.HasPrefix(peeked, prefix) {
+				return Match
+			}
+		}
+
+		return result
+	}
+}
+
+// TLSMatcher returns a Matcher that matches connections starting with a TLS
+// ClientHello record.
+func TLSMatcher() Matcher {
+	return func(peeked []byte) MatchResult {
+		// Record type (handshake), version major, version minor, length (2
+		// bytes) and handshake type (client hello).
+		expected := []byte{0x16, 0x03}
+		for i := 0; i < len(peeked) && i < 6; i++ {
+			switch i {
+			case 0, 1:
+				if peeked[i] != expected[i] {
+					return NoMatch
+				}
+			case 2:
+				if peeked[i] > 0x04 {
+					return NoMatch
+				}
+			case 5:
+				if peeked[i] != 0x01 {
+					return NoMatch
+				}
+
+				return Match
+			}
+		}
+
+		return MatchNeedMore
+	}
+}
+
+// HTTPMatcher returns a Matcher that matches connections starting with an
+// HTTP/1.x request method.
+func HTTPMatcher() Matcher {
+	return PrefixMatcher([]byte("GET "), []byte("HEAD "), []byte("POST "),
+		[]byte("PUT "), []byte("DELETE "), []byte("OPTIONS "),
+		[]byte("PATCH "), []byte("CONNECT "), []byte("TRACE "))
+}
+
+// peekedConn is a net.Conn wrapper that replays already read bytes before
+// reading from the underlying connection.
+type peekedConn struct {
+	net.Conn
+
+	reader io.Reader
+}
+
+func newPeekedConn(conn net.Conn, peeked []byte) net.Conn {
+	if len(peeked) == 0 {
+		return conn
+	}
+
+	return &peekedConn{
+		Conn:   conn,
+		reader: io.MultiReader(bytes.NewReader(peeked), conn),
+	}
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPrefixMatcher(t *testing.T) {
+	matcher := PrefixMatcher([]byte("abc"), []byte("xy"))
+
+	tests := []struct {
+		peeked   string
+		expected MatchResult
+	}{
+		{"", MatchNeedMore},
+		{"a", MatchNeedMore},
+		{"abc", Match},
+		{"abcd", Match},
+		{"xy", Match},
+		{"xz", NoMatch},
+		{"q", NoMatch},
+	}
+
+	for _, test := range tests {
+		result := matcher([]byte(test.peeked))
+		if result != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.peeked, test.expected,
+				result)
+		}
+	}
+}
+
+func TestTLSMatcher(t *testing.T) {
+	matcher := TLSMatcher()
+
+	if matcher([]byte{0x16, 0x03}) != MatchNeedMore {
+		t.Error("expected MatchNeedMore")
+	}
+
+	if matcher([]byte{0x16, 0x03, 0x01, 0x02, 0x00, 0x01}) != Match {
+		t.Error("expected Match")
+	}
+
+	if matcher([]byte("GET / HTTP/1.1")) != NoMatch {
+		t.Error("expected NoMatch")
+	}
+}
+
+func TestMux(t *testing.T) {
+	type result struct {
+		name string
+		data string
+	}
+	resultCh := make(chan result)
+	handler := func(name string) ConnectionHandler {
+		return func(conn net.Conn) {
+			data, _ := io.ReadAll(conn)
+			resultCh <- result{name, string(data)}
+		}
+	}
+
+	mux := NewMux(handler("fallback"))
+	err := mux.Handle(nil, handler("nil"))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	mux.Handle(HTTPMatcher(), handler("http"))
+	mux.Handle(PrefixMatcher([]byte("BIN")), handler("binary"))
+
+	tests := []struct {
+		writes   []string
+		expected result
+	}{
+		{[]string{"GE", "T / HTTP/1.1"}, result{"http", "GET / HTTP/1.1"}},
+		{[]string{"BINdata"}, result{"binary", "BINdata"}},
+		{[]string{"other"}, result{"fallback", "other"}},
+		{[]string{"B"}, result{"fallback", "B"}},
+	}
+
+	for _, test := range tests {
+		localConn, remoteConn := net.Pipe()
+		go mux.ServeConn(remoteConn)
+
+		for _, write := range test.writes {
+			localConn.Write([]byte(write))
+		}
+		localConn.Close()
+
+		r := <-resultCh
+		if r != test.expected {
+			t.Errorf("expected %v, got %v", test.expected, r)
+		}
+	}
+}
+
+func TestMux_SetMaxPeek(t *testing.T) {
+	mux := NewMux(nil)
+
+	for _, maxPeek := range []int{-1, 0} {
+		err := mux.SetMaxPeek(maxPeek)
+		if err == nil {
+			t.Errorf("%d: expected non-nil error, got nil", maxPeek)
+		}
+	}
+
+	err := mux.SetMaxPeek(3)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	if mux.maxPeek != 3 {
+		t.Errorf("expected 3, got %d", mux.maxPeek)
+	}
+}
+
+func TestMux_PeekTimeout(t *testing.T) {
+	mux := NewMux(nil)
+	mux.SetPeekTimeout(10 * time.Millisecond)
+	mux.Handle(PrefixMatcher([]byte("abc")), func(net.Conn) {
+		t.Error("expected handler not to be called")
+	})
+
+	localConn, remoteConn := net.Pipe()
+	defer localConn.Close()
+
+	doneCh := make(chan struct{})
+	go func() {
+		mux.ServeConn(remoteConn)
+		close(doneCh)
+	}()
+
+	localConn.Write([]byte("a"))
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Error("expected ServeConn to return")
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+// Network is the interface for networks a Server can listen at (see
+// SetNetwork). testing.Network implements it.
+type Network interface {
+	Listen(network, address string) (net.Listener, error)
+	ListenPacket(network, address string) (net.PacketConn, error)
+}
+
+// SetNetwork makes this Server listen at the given Network instead of using
+// package net. Multiple acceptors and packet sockets (see SetAcceptors and
+// SetPacketIO) require SO_REUSEPORT, so they are not supported with custom
+// networks. It must be called before Start.
+func (s *Server) SetNetwork(network Network) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.started {
+		return fmt.Errorf("server already started")
+	}
+
+	s.listen = network.Listen
+	s.listenPacket = network.ListenPacket
+
+	s.listenReusePort = func(string, string, int) ([]net.Listener, error) {
+		return nil, fmt.Errorf("multiple acceptors not supported by %T",
+			network)
+	}
+	s.listenPacketReusePort = func(string, string,
+		int) ([]net.PacketConn, error) {
+		return nil, fmt.Errorf("multiple packet sockets not supported by %T",
+			network)
+	}
+
+	return nil
+}
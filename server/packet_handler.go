@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// PacketHandler is the signature for functions that will handle individual
+// datagrams in stateless packet servers (see NewWithPacketHandler). The req
+// slice is only valid until the handler returns. The given reply function
+// sends a datagram back to the sender of the request and can be called any
+// number of times (but also only until the handler returns).
+type PacketHandler func(req []byte, from net.Addr, reply func([]byte) error)
+
+// NewWithPacketHandler creates a new Server instance that will try to listen
+// at the given packet network and address and that will call the given
+// packetHandler for each incoming datagram. No "fake" connections are created
+// in this mode. Instead, datagrams are read and handled by a pool of worker
+// goroutines (runtime.NumCPU() if workers is not positive), so the
+// packetHandler might be called concurrently but each worker handles one
+// datagram at a time. Middlewares do not apply to packet handlers. Note that
+// NewWithPacketHandler only validates that packetHandler is not nil. All
+// other errors will be reported when Start is called.
+func NewWithPacketHandler(network, address string, workers int,
+	packetHandler PacketHandler) (*Server, error) {
+	if packetHandler == nil {
+		return nil, fmt.Errorf("packetHandler cannot be nil")
+	}
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	return &Server{
+		network:       network,
+		address:       address,
+		packetHandler: packetHandler,
+		packetWorkers: workers,
+		retryPolicy:   DefaultRetryPolicy,
+		listen:        net.Listen,
+		listenPacket:  net.ListenPacket,
+
+		listenReusePort:       listenReusePort,
+		listenPacketReusePort: listenPacketReusePort,
+	}, nil
+}
+
+func (s *Server) packetWorkerLoop(socket *packetSocket) {
+	var replyAddr net.Addr
+	reply := func(b []byte) error {
+		_, err := socket.packetConn.WriteTo(b, replyAddr)
+		return err
+	}
+
+	retrier := newRetrier(s.retryPolicy, s.stopCh)
+	messages := newMessages(socket.config.BatchSize, socket.config.BufferSize)
+	for {
+		n, err := socket.conn.ReadBatch(messages, 0)
+		if err != nil {
+			if retrier.retry(err) {
+				continue
+			}
+			break
+		}
+
+		retrier.reset()
+
+		for i := 0; i < n; i++ {
+			data := messages[i].Buffers[0][:messages[i].N]
+			addr := messages[i].Addr
+			from := addr
+			if s.proxyProtocol != nil && s.proxyProtocol.trusted(addr) {
+				header, headerLength, err := parseProxyHeaderV2(data)
+				if err != nil {
+					// Drop datagrams without a valid header.
+					continue
+				}
+
+				data = data[headerLength:]
+				if header.srcAddr != nil {
+					from = header.srcAddr
+				}
+			}
+
+			replyAddr = addr
+
+			s.packetHandler(data, from, reply)
+		}
+	}
+
+	s.wg.Done()
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	testing2 "github.com/brunoga/net/testing"
+)
+
+func TestNewWithPacketHandler(t *testing.T) {
+	_, err := NewWithPacketHandler("udp", "", 0, nil)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	s, err := NewWithPacketHandler("udp", "", 0,
+		func([]byte, net.Addr, func([]byte) error) {})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+	if s.packetWorkers <= 0 {
+		t.Errorf("expected positive number of workers, got %d",
+			s.packetWorkers)
+	}
+	if s.retryPolicy != DefaultRetryPolicy {
+		t.Errorf("expected default retry policy, got %+v", s.retryPolicy)
+	}
+
+	s, err = NewWithPacketHandler("tcp", "", 1,
+		func([]byte, net.Addr, func([]byte) error) {})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	err = s.Start()
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestPacketHandler(t *testing.T) {
+	s, err := NewWithPacketHandler("udp", "", 4,
+		func(req []byte, from net.Addr, reply func([]byte) error) {
+			reply(append([]byte(from.String()+" "), req...))
+		})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	type ConnData struct {
+		Addr net.Addr
+		Data []byte
+	}
+	readFromDataCh := make(chan *ConnData)
+	writeToDataCh := make(chan *ConnData)
+
+	s.listenPacket = func(string, string) (net.PacketConn, error) {
+		return &testing2.MockPacketConn{
+			ReadFromFunc: func(b []byte) (int, net.Addr, error) {
+				connData, ok := <-readFromDataCh
+				if !ok {
+					return 0, nil, fmt.Errorf("readfrom error")
+				}
+
+				return copy(b, connData.Data), connData.Addr, nil
+			},
+			WriteToFunc: func(b []byte, addr net.Addr) (int, error) {
+				writeToDataCh <- &ConnData{addr, append([]byte(nil), b...)}
+				return len(b), nil
+			},
+			CloseFunc: func() error {
+				close(readFromDataCh)
+				return nil
+			},
+		}, nil
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1000}
+	readFromDataCh <- &ConnData{addr, []byte("hello")}
+
+	writeToData := <-writeToDataCh
+	if writeToData.Addr != addr {
+		t.Errorf("expected %v, got %v", addr, writeToData.Addr)
+	}
+	if string(writeToData.Data) != "1.2.3.4:1000 hello" {
+		t.Errorf("expected '1.2.3.4:1000 hello', got %q", writeToData.Data)
+	}
+}
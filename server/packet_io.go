@@ -0,0 +1,395 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const defaultPacketBufferSize = 4096
+
+// PacketIOConfig controls how a Server reads and writes datagrams on packet
+// networks. The zero value reads and writes one datagram at a time from a
+// single socket.
+type PacketIOConfig struct {
+	// BatchSize is the maximum number of datagrams read or written with a
+	// single system call (recvmmsg/sendmmsg where supported, currently only
+	// for UDP sockets on Linux). Values smaller than 2 disable batching.
+	BatchSize int
+
+	// Sockets is the number of sockets bound to the same address (with
+	// SO_REUSEPORT), each one with its own read loop. Values smaller than 2
+	// use a single socket. Ignored for servers created with an existing
+	// packet connection.
+	Sockets int
+
+	// BufferSize is the maximum datagram size. Larger datagrams are
+	// truncated. Defaults to 4096.
+	BufferSize int
+
+	// QueueSize is the maximum number of datagrams queued for each packet
+	// session while its handler is not reading. Defaults to 64.
+	QueueSize int
+
+	// DropPolicy determines which datagram is dropped when a packet session
+	// queue is full. Defaults to DropNewest.
+	DropPolicy DropPolicy
+
+	// IdleTimeout makes reads from packet sessions fail with a timeout error
+	// when no datagram was received from the peer for the given duration.
+	// Zero (the default) disables it.
+	IdleTimeout time.Duration
+}
+
+// SetPacketIO sets the packet I/O configuration for this Server. It must be
+// called before Start.
+func (s *Server) SetPacketIO(config PacketIOConfig) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.started {
+		return fmt.Errorf("server already started")
+	}
+
+	s.packetIO = config.withDefaults()
+
+	return nil
+}
+
+func (c PacketIOConfig) withDefaults() PacketIOConfig {
+	if c.BatchSize < 1 {
+		c.BatchSize = 1
+	}
+
+	if c.Sockets < 1 {
+		c.Sockets = 1
+	}
+
+	if c.BufferSize <= 0 {
+		c.BufferSize = defaultPacketBufferSize
+	}
+
+	if c.QueueSize <= 0 {
+		c.QueueSize = defaultPacketQueueSize
+	}
+
+	return c
+}
+
+// packetSocket holds the state associated with one of the packet sockets of a
+// Server.
+type packetSocket struct {
+	server     *Server
+	packetConn net.PacketConn
+	conn       batchConn
+	config     PacketIOConfig
+	bufferPool *sync.Pool
+	writer     *packetWriter // nil if batching is disabled.
+
+	m        sync.Mutex
+	sessions map[string]*packetSession
+}
+
+func newPacketSocket(server *Server, packetConn net.PacketConn,
+	config PacketIOConfig, bufferPool *sync.Pool) *packetSocket {
+	return &packetSocket{
+		server:     server,
+		packetConn: packetConn,
+		conn:       newBatchConn(packetConn, config.BatchSize),
+		config:     config,
+		bufferPool: bufferPool,
+		sessions:   make(map[string]*packetSession),
+	}
+}
+
+// session returns the session associated with the given key. If there is no
+// such session, a new one is created for the given addresses and created is
+// true. ok is false if the socket is already closed.
+func (p *packetSocket) session(key string, addr, localAddr,
+	remoteAddr net.Addr) (session *packetSession, created, ok bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.sessions == nil {
+		return nil, false, false
+	}
+
+	session, found := p.sessions[key]
+	if !found {
+		session = newPacketSession(p, key, addr, localAddr, remoteAddr)
+		p.sessions[key] = session
+	}
+
+	return session, !found, true
+}
+
+// removeSession removes the given session if it is associated with the given
+// key.
+func (p *packetSocket) removeSession(key string, session *packetSession) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.sessions[key] == session {
+		delete(p.sessions, key)
+	}
+}
+
+// closeSessions closes all sessions. No new sessions can be created after it
+// is called.
+func (p *packetSocket) closeSessions() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for _, session := range p.sessions {
+		session.close()
+	}
+
+	p.sessions = nil
+}
+
+// send sends the given datagram to addr, retrying according to the Server
+// RetryPolicy. It fails with a timeout error if the given deadline (if not
+// zero) expires first.
+func (p *packetSocket) send(data []byte, addr net.Addr,
+	deadline time.Time) error {
+	if p.writer != nil {
+		return p.writer.write(data, addr, deadline)
+	}
+
+	retrier := newRetrier(p.server.retryPolicy, p.server.stopCh)
+	for {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return os.ErrDeadlineExceeded
+		}
+
+		_, err := p.packetConn.WriteTo(data, addr)
+		if err == nil || !retrier.retry(err) {
+			return err
+		}
+	}
+}
+
+func newBufferPool(size int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			buffer := make([]byte, size)
+			return &buffer
+		},
+	}
+}
+
+// listenPacketReusePort creates count packet connections bound to the same
+// network and address with SO_REUSEPORT set.
+func listenPacketReusePort(network, address string,
+	count int) ([]net.PacketConn, error) {
+	listenConfig := net.ListenConfig{
+		Control: setReusePort,
+	}
+
+	packetConns := make([]net.PacketConn, 0, count)
+	for i := 0; i < count; i++ {
+		packetConn, err := listenConfig.ListenPacket(context.Background(),
+			network, address)
+		if err != nil {
+			for _, packetConn := range packetConns {
+				packetConn.Close()
+			}
+
+			return nil, err
+		}
+
+		if i == 0 {
+			// Make sure all sockets use the same port if a random one was
+			// requested.
+			address = packetConn.LocalAddr().String()
+		}
+
+		packetConns = append(packetConns, packetConn)
+	}
+
+	return packetConns, nil
+}
+
+// batchConn reads and writes batches of datagrams from/to a packet connection.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// newBatchConn returns a batchConn for the given packetConn. If batching is
+// not supported, it falls back to reading and writing one datagram at a time.
+func newBatchConn(packetConn net.PacketConn, batchSize int) batchConn {
+	if batchSize > 1 {
+		if udpConn, ok := packetConn.(*net.UDPConn); ok {
+			if udpAddr, ok := udpConn.LocalAddr().(*net.UDPAddr); ok &&
+				udpAddr.IP.To4() != nil {
+				return ipv4.NewPacketConn(udpConn)
+			}
+
+			return ipv6.NewPacketConn(udpConn)
+		}
+	}
+
+	return &singleBatchConn{packetConn}
+}
+
+// singleBatchConn is a batchConn that reads and writes one datagram at a time.
+type singleBatchConn struct {
+	packetConn net.PacketConn
+}
+
+func (c *singleBatchConn) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	n, addr, err := c.packetConn.ReadFrom(ms[0].Buffers[0])
+	if err != nil {
+		return 0, err
+	}
+
+	ms[0].N = n
+	ms[0].Addr = addr
+
+	return 1, nil
+}
+
+func (c *singleBatchConn) WriteBatch(ms []ipv4.Message, flags int) (int, error) {
+	for i := range ms {
+		n, err := c.packetConn.WriteTo(ms[i].Buffers[0], ms[i].Addr)
+		if err != nil {
+			return i, err
+		}
+
+		ms[i].N = n
+	}
+
+	return len(ms), nil
+}
+
+// newMessages allocates count messages with a buffer of the given size each.
+func newMessages(count, bufferSize int) []ipv4.Message {
+	messages := make([]ipv4.Message, count)
+	for i := range messages {
+		messages[i].Buffers = [][]byte{make([]byte, bufferSize)}
+	}
+
+	return messages
+}
+
+// outgoingPacket is a datagram waiting to be sent by a packetWriter.
+type outgoingPacket struct {
+	buffer *[]byte
+	n      int
+	addr   net.Addr
+}
+
+// packetWriter sends datagrams to a packet connection in batches. It is used
+// by all sessions associated with a socket when batching is enabled.
+type packetWriter struct {
+	conn       batchConn
+	bufferPool *sync.Pool
+	batchSize  int
+
+	ch     chan outgoingPacket
+	doneCh chan struct{}
+}
+
+func newPacketWriter(conn batchConn, bufferPool *sync.Pool,
+	batchSize int) *packetWriter {
+	return &packetWriter{
+		conn:       conn,
+		bufferPool: bufferPool,
+		batchSize:  batchSize,
+		ch:         make(chan outgoingPacket, batchSize),
+		doneCh:     make(chan struct{}),
+	}
+}
+
+// writeLoop sends queued datagrams until close is called. All datagrams that
+// are immediately available (up to batchSize) are sent together.
+func (w *packetWriter) writeLoop() {
+	pending := make([]outgoingPacket, 0, w.batchSize)
+	messages := make([]ipv4.Message, w.batchSize)
+	for i := range messages {
+		messages[i].Buffers = make([][]byte, 1)
+	}
+
+	for {
+		select {
+		case packet := <-w.ch:
+			pending = append(pending[:0], packet)
+		case <-w.doneCh:
+			return
+		}
+
+	drain:
+		for len(pending) < w.batchSize {
+			select {
+			case packet := <-w.ch:
+				pending = append(pending, packet)
+			default:
+				break drain
+			}
+		}
+
+		for i, packet := range pending {
+			messages[i].Buffers[0] = (*packet.buffer)[:packet.n]
+			messages[i].Addr = packet.addr
+		}
+
+		for sent := 0; sent < len(pending); {
+			n, err := w.conn.WriteBatch(messages[sent:len(pending)], 0)
+			if err != nil {
+				// Datagrams are unreliable anyway.
+				break
+			}
+
+			sent += n
+		}
+
+		for _, packet := range pending {
+			w.bufferPool.Put(packet.buffer)
+		}
+	}
+}
+
+// write queues a copy of the given datagram to be sent to the given address.
+// It fails with a timeout error if the given deadline (if not zero) expires
+// before the datagram could be queued.
+func (w *packetWriter) write(data []byte, addr net.Addr,
+	deadline time.Time) error {
+	var timeoutCh <-chan time.Time
+	if !deadline.IsZero() {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		timeoutCh = timer.C
+	}
+
+	buffer := w.bufferPool.Get().(*[]byte)
+	n := copy(*buffer, data)
+
+	select {
+	case w.ch <- outgoingPacket{buffer, n, addr}:
+		return nil
+	case <-w.doneCh:
+		w.bufferPool.Put(buffer)
+		return net.ErrClosed
+	case <-timeoutCh:
+		w.bufferPool.Put(buffer)
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// close stops the writer. Datagrams not sent yet are discarded.
+func (w *packetWriter) close() {
+	close(w.doneCh)
+}
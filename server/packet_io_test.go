@@ -0,0 +1,294 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func echoConnectionHandler(conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+func echoPacketHandler(req []byte, from net.Addr, reply func([]byte) error) {
+	reply(req)
+}
+
+func startPacketServer(tb testing.TB, s *Server, config PacketIOConfig) {
+	err := s.SetPacketIO(config)
+	if err != nil {
+		tb.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.Start()
+	if err != nil {
+		tb.Fatalf("expected nil error, got %v", err)
+	}
+}
+
+// pingPong sends data to the given connection and waits for it to be echoed
+// back, retrying on timeouts (datagrams might be dropped).
+func pingPong(conn net.Conn, data, buffer []byte) error {
+	for attempt := 0; attempt < 10; attempt++ {
+		_, err := conn.Write(data)
+		if err != nil {
+			return err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+
+			return err
+		}
+
+		if string(buffer[:n]) != string(data) {
+			return fmt.Errorf("expected %q, got %q", data, buffer[:n])
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("no reply")
+}
+
+func testPacketEcho(t *testing.T, s *Server, config PacketIOConfig) {
+	startPacketServer(t, s, config)
+	defer s.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			conn, err := net.Dial("udp", s.Addr().String())
+			if err != nil {
+				t.Errorf("expected nil error, got %v", err)
+				return
+			}
+			defer conn.Close()
+
+			buffer := make([]byte, 64)
+			for j := 0; j < 10; j++ {
+				err = pingPong(conn, []byte(fmt.Sprintf("%d-%d", i, j)), buffer)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func TestSetPacketIO(t *testing.T) {
+	s, err := New("udp", "127.0.0.1:0", echoConnectionHandler)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.SetPacketIO(PacketIOConfig{})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	if s.packetIO != (PacketIOConfig{BatchSize: 1, Sockets: 1,
+		BufferSize: defaultPacketBufferSize,
+		QueueSize:  defaultPacketQueueSize}) {
+		t.Errorf("expected default config, got %v", s.packetIO)
+	}
+
+	startPacketServer(t, s, PacketIOConfig{})
+	defer s.Stop()
+
+	err = s.SetPacketIO(PacketIOConfig{})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestPacketIO_Sessions(t *testing.T) {
+	for _, config := range []PacketIOConfig{
+		{},
+		{BatchSize: 16},
+		{BatchSize: 16, Sockets: 4},
+	} {
+		t.Run(fmt.Sprintf("%+v", config), func(t *testing.T) {
+			s, err := New("udp", "127.0.0.1:0", echoConnectionHandler)
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			testPacketEcho(t, s, config)
+		})
+	}
+}
+
+func TestPacketIO_PacketHandler(t *testing.T) {
+	for _, config := range []PacketIOConfig{
+		{},
+		{BatchSize: 16},
+		{BatchSize: 16, Sockets: 4},
+	} {
+		t.Run(fmt.Sprintf("%+v", config), func(t *testing.T) {
+			s, err := NewWithPacketHandler("udp", "127.0.0.1:0", 2,
+				echoPacketHandler)
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			testPacketEcho(t, s, config)
+		})
+	}
+}
+
+func benchmarkPacketEcho(b *testing.B, s *Server, config PacketIOConfig) {
+	startPacketServer(b, s, config)
+	defer s.Stop()
+
+	b.SetParallelism(8)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		conn, err := net.Dial("udp", s.Addr().String())
+		if err != nil {
+			b.Errorf("expected nil error, got %v", err)
+			return
+		}
+		defer conn.Close()
+
+		data := make([]byte, 512)
+		buffer := make([]byte, 4096)
+		for pb.Next() {
+			err = pingPong(conn, data, buffer)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+var benchmarkPacketIOConfigs = []struct {
+	name   string
+	config PacketIOConfig
+}{
+	{"Default", PacketIOConfig{}},
+	{"Batch", PacketIOConfig{BatchSize: 32}},
+	{"BatchReusePort", PacketIOConfig{BatchSize: 32, Sockets: 4}},
+}
+
+func BenchmarkPacketIO_Sessions(b *testing.B) {
+	for _, bc := range benchmarkPacketIOConfigs {
+		b.Run(bc.name, func(b *testing.B) {
+			s, err := New("udp", "127.0.0.1:0", echoConnectionHandler)
+			if err != nil {
+				b.Fatalf("expected nil error, got %v", err)
+			}
+
+			benchmarkPacketEcho(b, s, bc.config)
+		})
+	}
+}
+
+func BenchmarkPacketIO_PacketHandler(b *testing.B) {
+	for _, bc := range benchmarkPacketIOConfigs {
+		b.Run(bc.name, func(b *testing.B) {
+			s, err := NewWithPacketHandler("udp", "127.0.0.1:0", 0,
+				echoPacketHandler)
+			if err != nil {
+				b.Fatalf("expected nil error, got %v", err)
+			}
+
+			benchmarkPacketEcho(b, s, bc.config)
+		})
+	}
+}
+
+// BenchmarkPacketIO_PipeBaseline measures the packet session implementation
+// PacketIOConfig replaced (a 4 KiB buffer allocated per datagram and a
+// net.Pipe plus a relay goroutine per peer), so the configurations above have
+// something to be compared with.
+func BenchmarkPacketIO_PipeBaseline(b *testing.B) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("expected nil error, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		peers := make(map[string]net.Conn)
+		defer func() {
+			for _, conn := range peers {
+				conn.Close()
+			}
+		}()
+
+		for {
+			buffer := make([]byte, 4096)
+			n, addr, err := packetConn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+
+			localConn, ok := peers[addr.String()]
+			if !ok {
+				var remoteConn net.Conn
+				localConn, remoteConn = net.Pipe()
+				peers[addr.String()] = localConn
+
+				go echoConnectionHandler(remoteConn)
+				go func(addr net.Addr) {
+					buffer := make([]byte, 4096)
+					for {
+						n, err := localConn.Read(buffer)
+						if err != nil {
+							return
+						}
+
+						packetConn.WriteTo(buffer[:n], addr)
+					}
+				}(addr)
+			}
+
+			localConn.Write(buffer[:n])
+		}
+	}()
+	defer wg.Wait()
+	defer packetConn.Close()
+
+	b.SetParallelism(8)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		conn, err := net.Dial("udp", packetConn.LocalAddr().String())
+		if err != nil {
+			b.Errorf("expected nil error, got %v", err)
+			return
+		}
+		defer conn.Close()
+
+		data := make([]byte, 512)
+		buffer := make([]byte, 4096)
+		for pb.Next() {
+			err = pingPong(conn, data, buffer)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
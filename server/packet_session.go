@@ -0,0 +1,345 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultPacketQueueSize = 64
+
+// DropPolicy determines which datagram is dropped when a packet session
+// inbound queue is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the datagram that was just received.
+	DropNewest DropPolicy = iota
+
+	// DropOldest drops the oldest queued datagram to make room for the one
+	// that was just received.
+	DropOldest
+)
+
+// PacketSession is implemented by the connections handed to connection
+// handlers for packet network peers (unless wrapped by a middleware).
+type PacketSession interface {
+	net.Conn
+
+	// DroppedPackets returns the number of datagrams from this peer dropped
+	// because the session inbound queue was full (see PacketIOConfig).
+	DroppedPackets() uint64
+}
+
+// packetSession is the "fake" connection handed to the connection handler for
+// a packet network peer. Incoming datagrams are queued by the socket read loop
+// (so a slow handler never blocks it) and outgoing ones are written directly
+// to the socket.
+//
+// Read and write deadlines behave as for any other net.Conn. If an idle
+// timeout is configured (see PacketIOConfig), Read also fails with a timeout
+// error when no datagram was received from the peer for that long. Each Write
+// sends one datagram (larger writes are split at the configured buffer size)
+// and a datagram larger than the buffer passed to Read is returned over
+// several reads. Close removes the session from the Server (a new one is
+// created if the peer sends more datagrams) and sends the goodbye datagram,
+// if any (see SetPacketGoodbye).
+type packetSession struct {
+	socket     *packetSocket
+	key        string
+	addr       net.Addr // Where datagrams are sent to.
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	readyCh chan struct{} // Signaled when Read should check its state again.
+	closeCh chan struct{}
+
+	m             sync.Mutex
+	queue         []queuedDatagram
+	dropped       uint64
+	lastReceived  time.Time
+	readDeadline  time.Time
+	writeDeadline time.Time
+	closed        bool
+	closeErr      error // io.EOF if closed by the Server, net.ErrClosed if not.
+}
+
+type queuedDatagram struct {
+	buffer *[]byte
+	n      int
+	offset int // Bytes already read.
+}
+
+func newPacketSession(socket *packetSocket, key string, addr, localAddr,
+	remoteAddr net.Addr) *packetSession {
+	return &packetSession{
+		socket:       socket,
+		key:          key,
+		addr:         addr,
+		localAddr:    localAddr,
+		remoteAddr:   remoteAddr,
+		readyCh:      make(chan struct{}, 1),
+		closeCh:      make(chan struct{}),
+		lastReceived: time.Now(),
+	}
+}
+
+// SetPacketGoodbye sets a datagram that is sent to the peer whenever a
+// connection handler closes its packet session connection, so protocols can
+// tell peers that the session is over. It is not sent for sessions closed
+// because the Server was stopped. A nil goodbye (the default) disables it. It
+// must be called before Start.
+func (s *Server) SetPacketGoodbye(goodbye []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.started {
+		return fmt.Errorf("server already started")
+	}
+
+	s.packetGoodbye = goodbye
+
+	return nil
+}
+
+// DroppedPackets returns the total number of datagrams dropped by this Server
+// because packet session inbound queues were full.
+func (s *Server) DroppedPackets() uint64 {
+	return atomic.LoadUint64(&s.packetDrops)
+}
+
+// enqueue queues a copy of the given datagram for delivery, dropping a
+// datagram if the queue is full. It returns false if the session is closed.
+func (p *packetSession) enqueue(data []byte) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.closed {
+		return false
+	}
+
+	p.lastReceived = time.Now()
+
+	if len(p.queue) >= p.socket.config.QueueSize {
+		p.dropped++
+		atomic.AddUint64(&p.socket.server.packetDrops, 1)
+
+		if p.socket.config.DropPolicy == DropNewest {
+			return true
+		}
+
+		p.socket.bufferPool.Put(p.queue[0].buffer)
+		p.queue[0] = queuedDatagram{}
+		p.queue = p.queue[1:]
+	}
+
+	buffer := p.socket.bufferPool.Get().(*[]byte)
+	n := copy(*buffer, data)
+	p.queue = append(p.queue, queuedDatagram{buffer, n, 0})
+
+	p.signal()
+
+	return true
+}
+
+// signal wakes up a blocked Read, if any.
+func (p *packetSession) signal() {
+	select {
+	case p.readyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (p *packetSession) Read(b []byte) (int, error) {
+	for {
+		p.m.Lock()
+
+		if p.closed {
+			err := p.closeErr
+			p.m.Unlock()
+			return 0, err
+		}
+
+		if len(p.queue) > 0 {
+			datagram := &p.queue[0]
+			n := copy(b, (*datagram.buffer)[datagram.offset:datagram.n])
+			datagram.offset += n
+			if datagram.offset == datagram.n {
+				p.socket.bufferPool.Put(datagram.buffer)
+				p.queue[0] = queuedDatagram{}
+				p.queue = p.queue[1:]
+			}
+
+			p.m.Unlock()
+
+			return n, nil
+		}
+
+		deadline := p.readDeadline
+		if p.socket.config.IdleTimeout > 0 {
+			idleDeadline := p.lastReceived.Add(p.socket.config.IdleTimeout)
+			if deadline.IsZero() || idleDeadline.Before(deadline) {
+				deadline = idleDeadline
+			}
+		}
+
+		p.m.Unlock()
+
+		if !p.wait(deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// wait blocks until Read should check its state again. It returns false if
+// the given deadline (if not zero) expired.
+func (p *packetSession) wait(deadline time.Time) bool {
+	var timeoutCh <-chan time.Time
+	if !deadline.IsZero() {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return false
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		timeoutCh = timer.C
+	}
+
+	select {
+	case <-p.readyCh:
+	case <-p.closeCh:
+	case <-timeoutCh:
+	}
+
+	return true
+}
+
+func (p *packetSession) Write(b []byte) (int, error) {
+	written := 0
+	for {
+		p.m.Lock()
+		closed, deadline := p.closed, p.writeDeadline
+		p.m.Unlock()
+
+		if closed {
+			return written, net.ErrClosed
+		}
+
+		datagram := b
+		if len(datagram) > p.socket.config.BufferSize {
+			datagram = datagram[:p.socket.config.BufferSize]
+		}
+
+		err := p.socket.send(datagram, p.addr, deadline)
+		if err != nil {
+			return written, err
+		}
+
+		written += len(datagram)
+		b = b[len(datagram):]
+
+		if len(b) == 0 {
+			return written, nil
+		}
+	}
+}
+
+func (p *packetSession) Close() error {
+	p.m.Lock()
+
+	if p.closed {
+		// Close was already called or the Server closed the session.
+		err := p.closeErr
+		p.closeErr = net.ErrClosed
+		p.m.Unlock()
+
+		if err == net.ErrClosed {
+			return err
+		}
+
+		return nil
+	}
+
+	p.closeLocked(net.ErrClosed)
+	deadline := p.writeDeadline
+
+	p.m.Unlock()
+
+	// Let the Server know this session is gone.
+	p.socket.removeSession(p.key, p)
+
+	if p.socket.server.packetGoodbye != nil {
+		p.socket.send(p.socket.server.packetGoodbye, p.addr, deadline)
+	}
+
+	return nil
+}
+
+// close closes the session on behalf of the Server. Pending and future reads
+// return io.EOF. Queued datagrams are discarded.
+func (p *packetSession) close() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if !p.closed {
+		p.closeLocked(io.EOF)
+	}
+}
+
+func (p *packetSession) closeLocked(err error) {
+	p.closed = true
+	p.closeErr = err
+
+	for _, datagram := range p.queue {
+		p.socket.bufferPool.Put(datagram.buffer)
+	}
+	p.queue = nil
+
+	close(p.closeCh)
+}
+
+func (p *packetSession) LocalAddr() net.Addr {
+	return p.localAddr
+}
+
+func (p *packetSession) RemoteAddr() net.Addr {
+	return p.remoteAddr
+}
+
+func (p *packetSession) SetDeadline(t time.Time) error {
+	p.SetReadDeadline(t)
+	p.SetWriteDeadline(t)
+
+	return nil
+}
+
+func (p *packetSession) SetReadDeadline(t time.Time) error {
+	p.m.Lock()
+	p.readDeadline = t
+	p.m.Unlock()
+
+	p.signal()
+
+	return nil
+}
+
+func (p *packetSession) SetWriteDeadline(t time.Time) error {
+	p.m.Lock()
+	p.writeDeadline = t
+	p.m.Unlock()
+
+	return nil
+}
+
+func (p *packetSession) DroppedPackets() uint64 {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.dropped
+}
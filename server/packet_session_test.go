@@ -0,0 +1,385 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	testing2 "github.com/brunoga/net/testing"
+)
+
+// newTestPacketSession returns a session on a socket that sends datagrams with
+// the given writeTo function.
+func newTestPacketSession(t *testing.T, config PacketIOConfig,
+	writeTo func([]byte, net.Addr) (int, error)) *packetSession {
+	s, err := New("udp", "", func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	s.stopCh = make(chan struct{})
+
+	config = config.withDefaults()
+	socket := newPacketSocket(s, &testing2.MockPacketConn{
+		WriteToFunc: writeTo,
+	}, config, newBufferPool(config.BufferSize))
+
+	addr := &testing2.MockAddr{}
+	session, _, _ := socket.session("key", addr, addr, addr)
+
+	return session
+}
+
+func TestPacketSession_DropPolicy(t *testing.T) {
+	for _, test := range []struct {
+		dropPolicy DropPolicy
+		expected   []string
+	}{
+		{DropNewest, []string{"a", "b"}},
+		{DropOldest, []string{"b", "c"}},
+	} {
+		session := newTestPacketSession(t, PacketIOConfig{QueueSize: 2,
+			DropPolicy: test.dropPolicy}, nil)
+
+		for _, data := range []string{"a", "b", "c"} {
+			if !session.enqueue([]byte(data)) {
+				t.Fatalf("expected enqueue to succeed")
+			}
+		}
+
+		var conn net.Conn = session
+		packetSession, ok := conn.(PacketSession)
+		if !ok {
+			t.Fatalf("expected PacketSession, got %T", conn)
+		}
+
+		drops := session.socket.server.DroppedPackets()
+		if packetSession.DroppedPackets() != 1 || drops != 1 {
+			t.Errorf("expected 1 drop, got %d (%d)",
+				packetSession.DroppedPackets(), drops)
+		}
+
+		buffer := make([]byte, 16)
+		for _, expected := range test.expected {
+			n, err := session.Read(buffer)
+			if err != nil || string(buffer[:n]) != expected {
+				t.Errorf("expected %q, got %q (%v)", expected, buffer[:n], err)
+			}
+		}
+
+		session.close()
+
+		if session.enqueue([]byte("d")) {
+			t.Error("expected enqueue to fail after close")
+		}
+	}
+}
+
+func TestPacketSession_Read(t *testing.T) {
+	session := newTestPacketSession(t, PacketIOConfig{}, nil)
+
+	session.enqueue([]byte("hello"))
+
+	// Datagrams larger than the buffer are returned over several reads.
+	buffer := make([]byte, 3)
+	for _, expected := range []string{"hel", "lo"} {
+		n, err := session.Read(buffer)
+		if err != nil || string(buffer[:n]) != expected {
+			t.Errorf("expected %q, got %q (%v)", expected, buffer[:n], err)
+		}
+	}
+
+	// Blocked reads are woken up by new datagrams.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		session.enqueue([]byte("abc"))
+	}()
+
+	n, err := session.Read(buffer)
+	if err != nil || string(buffer[:n]) != "abc" {
+		t.Errorf("expected %q, got %q (%v)", "abc", buffer[:n], err)
+	}
+
+	// Closing on behalf of the Server makes reads return io.EOF.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		session.close()
+	}()
+
+	_, err = session.Read(buffer)
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestPacketSession_ReadDeadline(t *testing.T) {
+	session := newTestPacketSession(t, PacketIOConfig{}, nil)
+
+	session.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	_, err := session.Read(make([]byte, 16))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+
+	// Extending the deadline while blocked must be honored.
+	session.SetReadDeadline(time.Now().Add(time.Hour))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		session.SetReadDeadline(time.Now())
+	}()
+
+	_, err = session.Read(make([]byte, 16))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+
+	// Data is still readable after a timeout.
+	session.SetReadDeadline(time.Time{})
+	session.enqueue([]byte("hello"))
+
+	n, err := session.Read(make([]byte, 16))
+	if err != nil || n != 5 {
+		t.Errorf("expected 5 bytes, got %d (%v)", n, err)
+	}
+}
+
+func TestPacketSession_IdleTimeout(t *testing.T) {
+	session := newTestPacketSession(t,
+		PacketIOConfig{IdleTimeout: 50 * time.Millisecond}, nil)
+
+	// Datagrams keep the session alive.
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		session.enqueue([]byte("ping"))
+
+		_, err := session.Read(make([]byte, 16))
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+	}
+
+	start := time.Now()
+
+	_, err := session.Read(make([]byte, 16))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected idle timeout, got %v", elapsed)
+	}
+}
+
+func TestPacketSession_Write(t *testing.T) {
+	var datagrams []string
+	session := newTestPacketSession(t, PacketIOConfig{BufferSize: 4},
+		func(b []byte, addr net.Addr) (int, error) {
+			datagrams = append(datagrams, string(b))
+			return len(b), nil
+		})
+
+	// Writes larger than the buffer size are split.
+	n, err := session.Write([]byte("abcdefghij"))
+	if err != nil || n != 10 {
+		t.Errorf("expected 10 bytes, got %d (%v)", n, err)
+	}
+
+	if strings.Join(datagrams, ",") != "abcd,efgh,ij" {
+		t.Errorf("expected 3 datagrams, got %q", datagrams)
+	}
+
+	session.SetWriteDeadline(time.Now())
+
+	_, err = session.Write([]byte("x"))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+
+	session.SetWriteDeadline(time.Time{})
+	session.Close()
+
+	_, err = session.Write([]byte("x"))
+	if err != net.ErrClosed {
+		t.Errorf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestPacketSession_Close(t *testing.T) {
+	var handlers int32
+	s, err := New("udp", "127.0.0.1:0", func(conn net.Conn) {
+		atomic.AddInt32(&handlers, 1)
+
+		buffer := make([]byte, 64)
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return
+		}
+
+		conn.Write(buffer[:n])
+		conn.Close()
+
+		_, err = conn.Read(buffer)
+		if err != net.ErrClosed {
+			t.Errorf("expected net.ErrClosed, got %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.SetPacketGoodbye([]byte("bye"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	startPacketServer(t, s, PacketIOConfig{})
+	defer s.Stop()
+
+	err = s.SetPacketGoodbye(nil)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer conn.Close()
+
+	// Each exchange uses a new session as the previous one was closed.
+	buffer := make([]byte, 64)
+	for i := 0; i < 2; i++ {
+		conn.Write([]byte("hello"))
+
+		for _, expected := range []string{"hello", "bye"} {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+
+			n, err := conn.Read(buffer)
+			if err != nil || string(buffer[:n]) != expected {
+				t.Fatalf("expected %q, got %q (%v)", expected, buffer[:n], err)
+			}
+		}
+	}
+
+	if atomic.LoadInt32(&handlers) != 2 {
+		t.Errorf("expected 2 handlers, got %d", handlers)
+	}
+}
+
+func TestPacketSession_SlowHandler(t *testing.T) {
+	blockCh := make(chan struct{})
+	s, err := New("udp", "127.0.0.1:0", func(conn net.Conn) {
+		buffer := make([]byte, 64)
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return
+		}
+
+		if string(buffer[:n]) == "slow" {
+			<-blockCh
+			io.Copy(io.Discard, conn)
+			return
+		}
+
+		conn.Write(buffer[:n])
+		io.Copy(conn, conn)
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	startPacketServer(t, s, PacketIOConfig{QueueSize: 4})
+	defer s.Stop()
+	defer close(blockCh)
+
+	slowConn, err := net.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer slowConn.Close()
+
+	for i := 0; i < 32; i++ {
+		slowConn.Write([]byte("slow"))
+	}
+
+	// The slow session must not block other sessions.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn, err := net.Dial("udp", s.Addr().String())
+			if err != nil {
+				t.Errorf("expected nil error, got %v", err)
+				return
+			}
+			defer conn.Close()
+
+			err = pingPong(conn, []byte("fast"), make([]byte, 64))
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for s.DroppedPackets() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if s.DroppedPackets() == 0 {
+		t.Error("expected dropped packets, got none")
+	}
+}
+
+func TestPacketSession_Conformance(t *testing.T) {
+	testing2.TestDatagramConn(t, func() (net.Conn, net.Conn, func(), error) {
+		sessionCh := make(chan net.Conn, 1)
+		stopCh := make(chan struct{})
+		s, err := New("udp", "", func(conn net.Conn) {
+			sessionCh <- conn
+			<-stopCh
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		network := testing2.NewNetwork()
+		s.SetNetwork(network)
+
+		err = s.Start()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		stop := func() {
+			close(stopCh)
+			s.Stop()
+		}
+
+		peer, err := network.Dial("udp", s.Addr().String())
+		if err != nil {
+			stop()
+			return nil, nil, nil, err
+		}
+
+		// Sessions are created by their first datagram.
+		peer.Write([]byte("hello"))
+
+		session := <-sessionCh
+		session.Read(make([]byte, 5))
+
+		return session, peer, func() {
+			peer.Close()
+			stop()
+		}, nil
+	})
+}
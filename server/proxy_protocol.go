@@ -0,0 +1,311 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Maximum length of a PROXY protocol v1 header, including the CRLF.
+	proxyV1MaxLength = 107
+
+	// Length of the fixed part of a PROXY protocol v2 header.
+	proxyV2HeaderLength = 16
+)
+
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyProtocol holds the PROXY protocol configuration of a Server.
+type proxyProtocol struct {
+	trustedUpstreams []*net.IPNet
+	headerTimeout    time.Duration
+}
+
+// proxyHeader is a parsed PROXY protocol header. Addresses are nil if the
+// header does not carry any (LOCAL command or UNKNOWN/UNSPEC family).
+type proxyHeader struct {
+	srcAddr net.Addr
+	dstAddr net.Addr
+}
+
+// EnableProxyProtocol enables PROXY protocol (v1 and v2) parsing on stream
+// connections and PROXY protocol v2 parsing on packet datagrams. Connections
+// and datagrams from the given trusted upstreams (CIDRs) must start with a
+// PROXY protocol header and the addresses it carries are reported by the
+// LocalAddr and RemoteAddr methods of the connection passed to the
+// connectionHandler. Connections with missing or invalid headers are closed
+// and datagrams are dropped. Connections and datagrams from other addresses
+// are handled as usual. At least one trusted upstream must be given (as
+// anyone trusted can claim any address) and only IP addresses can be trusted.
+// Replies to proxied datagrams are sent back to the upstream. Stream
+// connections that do not send a header within the given headerTimeout (if
+// positive) are closed. EnableProxyProtocol must be called before Start.
+func (s *Server) EnableProxyProtocol(trustedUpstreams []string,
+	headerTimeout time.Duration) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.started {
+		return fmt.Errorf("server already started")
+	}
+
+	if len(trustedUpstreams) == 0 {
+		return fmt.Errorf("trustedUpstreams cannot be empty")
+	}
+
+	p := &proxyProtocol{
+		headerTimeout: headerTimeout,
+	}
+
+	for _, cidr := range trustedUpstreams {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+
+		p.trustedUpstreams = append(p.trustedUpstreams, ipNet)
+	}
+
+	s.proxyProtocol = p
+
+	return nil
+}
+
+// trusted returns true if the given address is a trusted upstream.
+func (p *proxyProtocol) trusted(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range p.trustedUpstreams {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wrapConn reads the PROXY protocol header from the given connection (if it
+// comes from a trusted upstream) and returns a connection reporting the
+// addresses in it.
+func (p *proxyProtocol) wrapConn(conn net.Conn) (net.Conn, error) {
+	if !p.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	if p.headerTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(p.headerTimeout))
+	}
+
+	reader := bufio.NewReaderSize(conn, 256)
+
+	header, err := readProxyHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.headerTimeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	// Replay anything that was buffered after the header.
+	buffered, _ := reader.Peek(reader.Buffered())
+	conn = newPeekedConn(conn, append([]byte(nil), buffered...))
+
+	if header.srcAddr == nil {
+		return conn, nil
+	}
+
+	return newConnAddrWrapper(conn, header.dstAddr, header.srcAddr), nil
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from the given reader.
+func readProxyHeader(reader *bufio.Reader) (*proxyHeader, error) {
+	signature, err := reader.Peek(len(proxyV1Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(signature, proxyV1Signature) {
+		return readProxyHeaderV1(reader)
+	}
+
+	signature, err = reader.Peek(proxyV2HeaderLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(signature[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, fmt.Errorf("missing proxy protocol header")
+	}
+
+	length := proxyV2HeaderLength + int(binary.BigEndian.Uint16(signature[14:]))
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return nil, err
+	}
+
+	header, _, err := parseProxyHeaderV2(data)
+
+	return header, err
+}
+
+// readProxyHeaderV1 reads a PROXY protocol v1 (text) header from the given
+// reader.
+func readProxyHeaderV1(reader *bufio.Reader) (*proxyHeader, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header")
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return &proxyHeader{}, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("invalid proxy protocol v1 family %q",
+			fields[1])
+	}
+
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header")
+	}
+
+	srcIP := net.ParseIP(fields[2])
+	dstIP := net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || srcErr != nil || dstErr != nil {
+		return nil, fmt.Errorf("invalid proxy protocol v1 addresses")
+	}
+
+	return &proxyHeader{
+		srcAddr: &net.TCPAddr{IP: srcIP, Port: int(srcPort)},
+		dstAddr: &net.TCPAddr{IP: dstIP, Port: int(dstPort)},
+	}, nil
+}
+
+// parseProxyHeaderV2 parses a PROXY protocol v2 (binary) header at the start
+// of the given data. It returns the header and its length.
+func parseProxyHeaderV2(data []byte) (*proxyHeader, int, error) {
+	if len(data) < proxyV2HeaderLength ||
+		!bytes.Equal(data[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, 0, fmt.Errorf("missing proxy protocol header")
+	}
+
+	if data[12]>>4 != 2 {
+		return nil, 0, fmt.Errorf("invalid proxy protocol version %d",
+			data[12]>>4)
+	}
+
+	length := proxyV2HeaderLength + int(binary.BigEndian.Uint16(data[14:]))
+	if len(data) < length {
+		return nil, 0, fmt.Errorf("truncated proxy protocol v2 header")
+	}
+
+	addresses := data[proxyV2HeaderLength:length]
+
+	switch data[12] & 0x0f {
+	case 0x00:
+		// LOCAL command (health checks, etc). Keep real addresses.
+		return &proxyHeader{}, length, nil
+	case 0x01:
+		// PROXY command.
+	default:
+		return nil, 0, fmt.Errorf("invalid proxy protocol v2 command %d",
+			data[12]&0x0f)
+	}
+
+	family := data[13] >> 4
+	protocol := data[13] & 0x0f
+
+	var ipLength int
+	switch family {
+	case 0x00:
+		return &proxyHeader{}, length, nil
+	case 0x01:
+		ipLength = net.IPv4len
+	case 0x02:
+		ipLength = net.IPv6len
+	case 0x03:
+		if len(addresses) < 216 {
+			return nil, 0, fmt.Errorf("truncated proxy protocol v2 addresses")
+		}
+
+		network := "unix"
+		if protocol == 0x02 {
+			network = "unixgram"
+		}
+
+		return &proxyHeader{
+			srcAddr: &net.UnixAddr{Name: cString(addresses[:108]), Net: network},
+			dstAddr: &net.UnixAddr{Name: cString(addresses[108:216]), Net: network},
+		}, length, nil
+	default:
+		return nil, 0, fmt.Errorf("invalid proxy protocol v2 family %d", family)
+	}
+
+	if len(addresses) < 2*ipLength+4 {
+		return nil, 0, fmt.Errorf("truncated proxy protocol v2 addresses")
+	}
+
+	srcIP := net.IP(append([]byte(nil), addresses[:ipLength]...))
+	dstIP := net.IP(append([]byte(nil), addresses[ipLength:2*ipLength]...))
+	srcPort := int(binary.BigEndian.Uint16(addresses[2*ipLength:]))
+	dstPort := int(binary.BigEndian.Uint16(addresses[2*ipLength+2:]))
+
+	switch protocol {
+	case 0x01:
+		return &proxyHeader{
+			srcAddr: &net.TCPAddr{IP: srcIP, Port: srcPort},
+			dstAddr: &net.TCPAddr{IP: dstIP, Port: dstPort},
+		}, length, nil
+	case 0x02:
+		return &proxyHeader{
+			srcAddr: &net.UDPAddr{IP: srcIP, Port: srcPort},
+			dstAddr: &net.UDPAddr{IP: dstIP, Port: dstPort},
+		}, length, nil
+	}
+
+	return nil, 0, fmt.Errorf("invalid proxy protocol v2 protocol %d",
+		protocol)
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+
+	return string(b)
+}
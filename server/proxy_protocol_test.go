@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	testing2 "github.com/brunoga/net/testing"
+)
+
+func proxyHeaderV2(command, family byte, addresses []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addresses)))
+
+	return append(header, addresses...)
+}
+
+func TestReadProxyHeader_V1(t *testing.T) {
+	tests := []struct {
+		data     string
+		src, dst string
+		valid    bool
+	}{
+		{"PROXY TCP4 1.2.3.4 5.6.7.8 1000 2000\r\nrest", "1.2.3.4:1000",
+			"5.6.7.8:2000", true},
+		{"PROXY TCP6 ::1 ::2 1000 2000\r\n", "[::1]:1000", "[::2]:2000", true},
+		{"PROXY UNKNOWN\r\n", "", "", true},
+		{"PROXY TCP4 1.2.3.4 5.6.7.8 1000\r\n", "", "", false},
+		{"PROXY TCP4 x 5.6.7.8 1000 2000\r\n", "", "", false},
+		{"PROXY TCP4 1.2.3.4 5.6.7.8 1000 2000\n", "", "", false},
+		{"GET / HTTP/1.1\r\n\r\n", "", "", false},
+	}
+
+	for _, test := range tests {
+		header, err := readProxyHeader(bufio.NewReader(
+			bytes.NewReader([]byte(test.data))))
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%q: expected non-nil error, got nil", test.data)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: expected nil error, got %v", test.data, err)
+			continue
+		}
+
+		if test.src == "" {
+			if header.srcAddr != nil {
+				t.Errorf("%q: expected no addresses, got %v", test.data,
+					header.srcAddr)
+			}
+
+			continue
+		}
+
+		if header.srcAddr.String() != test.src ||
+			header.dstAddr.String() != test.dst {
+			t.Errorf("%q: expected %v -> %v, got %v -> %v", test.data,
+				test.src, test.dst, header.srcAddr, header.dstAddr)
+		}
+	}
+}
+
+func TestParseProxyHeaderV2(t *testing.T) {
+	addresses := []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x03, 0xe8, 0x07, 0xd0}
+
+	data := append(proxyHeaderV2(0x01, 0x11, addresses), []byte("rest")...)
+	header, length, err := parseProxyHeaderV2(data)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if string(data[length:]) != "rest" {
+		t.Errorf("expected 'rest', got %q", data[length:])
+	}
+	if header.srcAddr.String() != "1.2.3.4:1000" {
+		t.Errorf("expected '1.2.3.4:1000', got %v", header.srcAddr)
+	}
+	if _, ok := header.dstAddr.(*net.TCPAddr); !ok {
+		t.Errorf("expected *net.TCPAddr, got %T", header.dstAddr)
+	}
+
+	header, _, err = parseProxyHeaderV2(proxyHeaderV2(0x01, 0x12, addresses))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if _, ok := header.srcAddr.(*net.UDPAddr); !ok {
+		t.Errorf("expected *net.UDPAddr, got %T", header.srcAddr)
+	}
+
+	header, _, err = parseProxyHeaderV2(proxyHeaderV2(0x00, 0x00, nil))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if header.srcAddr != nil {
+		t.Errorf("expected no addresses, got %v", header.srcAddr)
+	}
+
+	_, _, err = parseProxyHeaderV2(proxyHeaderV2(0x01, 0x11, addresses[:4]))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	_, _, err = parseProxyHeaderV2([]byte("not a proxy header"))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestProxyProtocol_TCP(t *testing.T) {
+	s, err := New("tcp", "", func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.EnableProxyProtocol([]string{"invalid"}, 0)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	// Trusting everyone must be explicit.
+	err = s.EnableProxyProtocol(nil, 0)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	err = s.EnableProxyProtocol([]string{"10.0.0.0/8"}, time.Second)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	localConn, remoteConn := net.Pipe()
+	defer localConn.Close()
+
+	upstreamAddr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}
+	go localConn.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1000 2000\r\nhello"))
+
+	conn, err := s.proxyProtocol.wrapConn(newConnAddrWrapper(remoteConn,
+		nil, upstreamAddr))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if conn.RemoteAddr().String() != "1.2.3.4:1000" {
+		t.Errorf("expected '1.2.3.4:1000', got %v", conn.RemoteAddr())
+	}
+	if conn.LocalAddr().String() != "5.6.7.8:2000" {
+		t.Errorf("expected '5.6.7.8:2000', got %v", conn.LocalAddr())
+	}
+
+	data := make([]byte, 5)
+	_, err = io.ReadFull(conn, data)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("expected 'hello', got %q (%v)", data, err)
+	}
+
+	// Untrusted upstreams are passed through.
+	untrustedAddr := &net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1}
+	conn, err = s.proxyProtocol.wrapConn(newConnAddrWrapper(remoteConn,
+		nil, untrustedAddr))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if conn.RemoteAddr() != untrustedAddr {
+		t.Errorf("expected %v, got %v", untrustedAddr, conn.RemoteAddr())
+	}
+
+	// Header timeout.
+	s.proxyProtocol.headerTimeout = 10 * time.Millisecond
+	_, err = s.proxyProtocol.wrapConn(newConnAddrWrapper(remoteConn,
+		nil, upstreamAddr))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestProxyProtocol_UDP(t *testing.T) {
+	addrCh := make(chan string)
+	s, err := New("udp", "", func(conn net.Conn) {
+		buffer := make([]byte, 16)
+		n, _ := conn.Read(buffer)
+		addrCh <- conn.RemoteAddr().String() + " " + string(buffer[:n])
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.EnableProxyProtocol([]string{"10.0.0.0/8"}, 0)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	upstreamAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}
+	datagrams := [][]byte{
+		[]byte("no header"),
+		append(proxyHeaderV2(0x01, 0x12, []byte{1, 2, 3, 4, 5, 6, 7, 8,
+			0x03, 0xe8, 0x07, 0xd0}), []byte("hello")...),
+	}
+
+	readCh := make(chan []byte)
+	s.listenPacket = func(string, string) (net.PacketConn, error) {
+		return &testing2.MockPacketConn{
+			ReadFromFunc: func(b []byte) (int, net.Addr, error) {
+				data := <-readCh
+				if data == nil {
+					return 0, nil, fmt.Errorf("readfrom error")
+				}
+
+				return copy(b, data), upstreamAddr, nil
+			},
+			CloseFunc: func() error {
+				close(readCh)
+				return nil
+			},
+		}, nil
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer s.Stop()
+
+	for _, datagram := range datagrams {
+		readCh <- datagram
+	}
+
+	result := <-addrCh
+	if result != "1.2.3.4:1000 hello" {
+		t.Errorf("expected '1.2.3.4:1000 hello', got %q", result)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrorClass is the classification of an error returned by the underlying
+// listener, packet connection or session connection in one of the Server
+// loops.
+type ErrorClass int
+
+const (
+	// ErrorClassFatal is used for errors that can not be recovered from.
+	ErrorClassFatal ErrorClass = iota
+
+	// ErrorClassClosed is used for errors caused by the connection or
+	// listener being closed.
+	ErrorClassClosed
+
+	// ErrorClassTimeout is used for timeouts (deadlines being exceeded).
+	ErrorClassTimeout
+
+	// ErrorClassTransient is used for errors that only affect a single
+	// operation (for example, a connection being aborted before it could be
+	// accepted).
+	ErrorClassTransient
+
+	// ErrorClassResourceExhausted is used for errors caused by the lack of
+	// system resources (for example, file descriptors or buffer space).
+	ErrorClassResourceExhausted
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassFatal:
+		return "fatal"
+	case ErrorClassClosed:
+		return "closed"
+	case ErrorClassTimeout:
+		return "timeout"
+	case ErrorClassTransient:
+		return "transient"
+	case ErrorClassResourceExhausted:
+		return "resource exhausted"
+	}
+
+	return fmt.Sprintf("ErrorClass(%d)", int(c))
+}
+
+// ClassifyError returns the ErrorClass for the given error.
+func ClassifyError(err error) ErrorClass {
+	switch {
+	case errors.Is(err, net.ErrClosed), errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrClosedPipe):
+		return ErrorClassClosed
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE),
+		errors.Is(err, syscall.ENOBUFS), errors.Is(err, syscall.ENOMEM):
+		return ErrorClassResourceExhausted
+	case errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EINTR),
+		errors.Is(err, syscall.EAGAIN):
+		return ErrorClassTransient
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrorClassTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTimeout
+	}
+
+	return ErrorClassFatal
+}
+
+// RetryPolicy controls how the Server loops (accepting connections and reading
+// datagrams) and packet session writes react to errors. Closed and fatal
+// errors stop the loop (or fail the write). Other errors are retried after a
+// delay that starts at InitialDelay and is multiplied by Multiplier after each
+// consecutive failure, up to MaxDelay. As they usually only affect a single
+// operation, the first of consecutive timeouts and transient errors is retried
+// immediately.
+type RetryPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by Servers unless another one is
+// set with SetRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialDelay: 5 * time.Millisecond,
+	MaxDelay:     time.Second,
+	Multiplier:   2,
+}
+
+// SetRetryPolicy sets the RetryPolicy for this Server. It must be called
+// before Start.
+func (s *Server) SetRetryPolicy(policy RetryPolicy) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.started {
+		return fmt.Errorf("server already started")
+	}
+
+	if policy.InitialDelay <= 0 || policy.MaxDelay < policy.InitialDelay ||
+		policy.Multiplier < 1 {
+		return fmt.Errorf("invalid retry policy %+v", policy)
+	}
+
+	s.retryPolicy = policy
+
+	return nil
+}
+
+// nextDelay returns the delay to use after the given one (zero if this is the
+// first failure).
+func (p RetryPolicy) nextDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return p.InitialDelay
+	}
+
+	delay = time.Duration(float64(delay) * p.Multiplier)
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// retrier applies a RetryPolicy to consecutive errors in a loop.
+type retrier struct {
+	policy RetryPolicy
+	stopCh <-chan struct{}
+
+	failed bool
+	delay  time.Duration
+}
+
+func newRetrier(policy RetryPolicy, stopCh <-chan struct{}) *retrier {
+	return &retrier{
+		policy: policy,
+		stopCh: stopCh,
+	}
+}
+
+// retry returns true if the operation that returned the given error should be
+// retried, waiting as needed. It returns false if the loop should stop
+// (including if the Server was stopped while waiting).
+func (r *retrier) retry(err error) bool {
+	switch ClassifyError(err) {
+	case ErrorClassTimeout, ErrorClassTransient:
+		if !r.failed {
+			r.failed = true
+			return true
+		}
+	case ErrorClassResourceExhausted:
+	default:
+		return false
+	}
+
+	r.failed = true
+	r.delay = r.policy.nextDelay(r.delay)
+
+	timer := time.NewTimer(r.delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-r.stopCh:
+		return false
+	}
+}
+
+// reset must be called after a successful operation.
+func (r *retrier) reset() {
+	r.failed = false
+	r.delay = 0
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	testing2 "github.com/brunoga/net/testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errorClassTests = []struct {
+	err   error
+	class ErrorClass
+}{
+	{fmt.Errorf("fatal"), ErrorClassFatal},
+	{net.ErrClosed, ErrorClassClosed},
+	{&net.OpError{Op: "read", Err: net.ErrClosed}, ErrorClassClosed},
+	{io.EOF, ErrorClassClosed},
+	{io.ErrClosedPipe, ErrorClassClosed},
+	{os.ErrDeadlineExceeded, ErrorClassTimeout},
+	{&net.OpError{Op: "read", Err: timeoutError{}}, ErrorClassTimeout},
+	{&net.OpError{Op: "accept", Err: os.NewSyscallError("accept",
+		syscall.ECONNABORTED)}, ErrorClassTransient},
+	{&net.OpError{Op: "accept", Err: os.NewSyscallError("accept",
+		syscall.EMFILE)}, ErrorClassResourceExhausted},
+	{syscall.ENOBUFS, ErrorClassResourceExhausted},
+}
+
+func TestClassifyError(t *testing.T) {
+	for _, test := range errorClassTests {
+		class := ClassifyError(test.err)
+		if class != test.class {
+			t.Errorf("%v: expected %v, got %v", test.err, test.class, class)
+		}
+	}
+}
+
+func TestSetRetryPolicy(t *testing.T) {
+	s, err := New("tcp", "", func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	err = s.SetRetryPolicy(RetryPolicy{})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	err = s.SetRetryPolicy(RetryPolicy{time.Second, time.Millisecond, 2})
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	err = s.SetRetryPolicy(RetryPolicy{time.Millisecond, time.Second, 2})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+}
+
+func TestRetryPolicy_NextDelay(t *testing.T) {
+	policy := RetryPolicy{time.Millisecond, 5 * time.Millisecond, 3}
+
+	expected := []time.Duration{time.Millisecond, 3 * time.Millisecond,
+		5 * time.Millisecond, 5 * time.Millisecond}
+
+	var delay time.Duration
+	for _, e := range expected {
+		delay = policy.nextDelay(delay)
+		if delay != e {
+			t.Errorf("expected %v, got %v", e, delay)
+		}
+	}
+}
+
+func TestRetrier_Stop(t *testing.T) {
+	stopCh := make(chan struct{})
+	r := newRetrier(RetryPolicy{time.Hour, time.Hour, 1}, stopCh)
+
+	close(stopCh)
+
+	if r.retry(syscall.EMFILE) {
+		t.Error("expected retry to return false after stop")
+	}
+}
+
+func TestRetrier_Transient(t *testing.T) {
+	stopCh := make(chan struct{})
+	r := newRetrier(RetryPolicy{time.Hour, time.Hour, 1}, stopCh)
+
+	// The first transient error is retried immediately.
+	if !r.retry(syscall.ECONNABORTED) {
+		t.Fatal("expected retry to return true")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(stopCh)
+	}()
+
+	// Consecutive ones wait (until stopped).
+	start := time.Now()
+	if r.retry(os.ErrDeadlineExceeded) {
+		t.Error("expected retry to return false after stop")
+	}
+
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("expected retry to wait, returned after %v", elapsed)
+	}
+}
+
+// testErrorClasses checks that the loop driven by the given start function
+// retries or stops on each error class. start must make the loop see the
+// given error once and then a successful operation (if it retries). It
+// returns a channel that receives when the successful operation happens.
+func testErrorClasses(t *testing.T,
+	start func(*Server, error) (<-chan struct{}, error)) {
+	for _, test := range errorClassTests {
+		s, err := New("udp", "", func(conn net.Conn) {
+			io.Copy(io.Discard, conn)
+		})
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		s.SetRetryPolicy(RetryPolicy{time.Millisecond, time.Millisecond, 1})
+
+		successCh, err := start(s, test.err)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		shouldRetry := test.class == ErrorClassTimeout ||
+			test.class == ErrorClassTransient ||
+			test.class == ErrorClassResourceExhausted
+
+		select {
+		case <-successCh:
+			if !shouldRetry {
+				t.Errorf("%v: expected loop to stop", test.err)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if shouldRetry {
+				t.Errorf("%v: expected loop to retry", test.err)
+			}
+		}
+
+		s.Stop()
+	}
+}
+
+func TestRetryPolicy_ListenLoop(t *testing.T) {
+	testErrorClasses(t, func(s *Server, e error) (<-chan struct{}, error) {
+		s.network = "tcp"
+
+		successCh := make(chan struct{})
+		closeCh := make(chan struct{})
+		calls := 0
+		s.listen = func(string, string) (net.Listener, error) {
+			return &testing2.MockListener{
+				AcceptFunc: func() (net.Conn, error) {
+					calls++
+					switch calls {
+					case 1:
+						return nil, e
+					case 2:
+						close(successCh)
+					}
+
+					<-closeCh
+					return nil, net.ErrClosed
+				},
+				CloseFunc: func() error {
+					close(closeCh)
+					return nil
+				},
+			}, nil
+		}
+
+		return successCh, s.Start()
+	})
+}
+
+func TestRetryPolicy_PacketListenLoop(t *testing.T) {
+	testErrorClasses(t, func(s *Server, e error) (<-chan struct{}, error) {
+		successCh := make(chan struct{})
+		closeCh := make(chan struct{})
+		calls := 0
+		s.listenPacket = func(string, string) (net.PacketConn, error) {
+			return &testing2.MockPacketConn{
+				ReadFromFunc: func(b []byte) (int, net.Addr, error) {
+					calls++
+					switch calls {
+					case 1:
+						return 0, nil, e
+					case 2:
+						close(successCh)
+					}
+
+					<-closeCh
+					return 0, nil, net.ErrClosed
+				},
+				CloseFunc: func() error {
+					close(closeCh)
+					return nil
+				},
+			}, nil
+		}
+
+		return successCh, s.Start()
+	})
+}
+
+func TestRetryPolicy_PacketSessionWrite(t *testing.T) {
+	testErrorClasses(t, func(s *Server, e error) (<-chan struct{}, error) {
+		s.connectionHandler = func(conn net.Conn) {
+			io.ReadFull(conn, make([]byte, 5))
+
+			// Retries happen inside Write, so the datagram is only sent a
+			// second time if the error is retried.
+			conn.Write([]byte("hello"))
+			io.Copy(io.Discard, conn)
+		}
+
+		successCh := make(chan struct{})
+		closeCh := make(chan struct{})
+		reads := 0
+		writes := 0
+		s.listenPacket = func(string, string) (net.PacketConn, error) {
+			return &testing2.MockPacketConn{
+				ReadFromFunc: func(b []byte) (int, net.Addr, error) {
+					reads++
+					if reads == 1 {
+						return copy(b, "hello"), &testing2.MockAddr{}, nil
+					}
+
+					<-closeCh
+					return 0, nil, net.ErrClosed
+				},
+				WriteToFunc: func(b []byte, addr net.Addr) (int, error) {
+					writes++
+					switch writes {
+					case 1:
+						return 0, e
+					case 2:
+						close(successCh)
+					}
+
+					return len(b), nil
+				},
+				CloseFunc: func() error {
+					close(closeCh)
+					return nil
+				},
+			}, nil
+		}
+
+		return successCh, s.Start()
+	})
+}
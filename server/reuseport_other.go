@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package server
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setReusePort is a net.ListenConfig Control function that sets SO_REUSEPORT
+// on the socket. It is not supported on this platform.
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT not supported")
+}
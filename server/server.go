@@ -1,35 +1,59 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"os"
 	"sync"
 )
 
 // Server is a server that handles both packet and stream protocols with the
 // same API. There is some complexity in achieving that so I would not use
-// it for performance-critical servers (that is being said without any
-// performance testing being done whatsoever). Clients of the API just need
-// to provide a connection handler and just read/write data from/to the
+// it for performance-critical servers (package load and the netload command
+// can be used to measure how it behaves under load). Clients of the API just
+// need to provide a connection handler and just read/write data from/to the
 // associated connection (in exactly the same way for underlying stream or
 // packet connections).
 type Server struct {
+	// Accessed atomically. Kept first for 64-bit alignment.
+	packetDrops uint64
+
 	network           string
 	address           string
 	connectionHandler ConnectionHandler
+	packetHandler     PacketHandler
+	packetWorkers     int
+	packetIO          PacketIOConfig
+	acceptors         int
+	retryPolicy       RetryPolicy
+	middlewares       []Middleware
+	proxyProtocol     *proxyProtocol
+	packetGoodbye     []byte
 
 	// For testing purposes only.
 	listen       func(string, string) (net.Listener, error)
 	listenPacket func(string, string) (net.PacketConn, error)
 
-	packetAddrConnMap map[string]net.Conn
+	listenReusePort       func(string, string, int) ([]net.Listener, error)
+	listenPacketReusePort func(string, string, int) ([]net.PacketConn, error)
 
 	wg sync.WaitGroup
 
+	// Tracks stream connection handlers, which are not waited for by Stop.
+	streamHandlersWg sync.WaitGroup
+
 	m          sync.Mutex
 	listener   net.Listener   // nil if packetConn is not
 	packetConn net.PacketConn // nil if listener is not
-	started    bool
+
+	// All listeners and packet sockets (listener and packetConn are the first
+	// ones).
+	listeners   []net.Listener
+	packetConns []net.PacketConn
+	handler     ConnectionHandler
+	stopCh      chan struct{}
+	started     bool
 }
 
 // ConnectionHandler is the signature for functions that that will handle
@@ -51,12 +75,73 @@ func New(network, address string,
 		network:           network,
 		address:           address,
 		connectionHandler: connectionHandler,
+		retryPolicy:       DefaultRetryPolicy,
 		listen:            net.Listen,
 		listenPacket:      net.ListenPacket,
-		packetAddrConnMap: make(map[string]net.Conn),
+
+		listenReusePort:       listenReusePort,
+		listenPacketReusePort: listenPacketReusePort,
 	}, nil
 }
 
+// NewWithListener creates a new Server instance that will use the given
+// listener (instead of creating its own) and that will call the given
+// connectionHandler to handle incoming connections. The listener is closed
+// when the Server is stopped. If it is started again, it will try to listen at
+// the same network and address as the original listener.
+func NewWithListener(listener net.Listener,
+	connectionHandler ConnectionHandler) (*Server, error) {
+	if listener == nil {
+		return nil, fmt.Errorf("listener cannot be nil")
+	}
+
+	network, address := addrNetworkAndString(listener.Addr())
+
+	s, err := New(network, address, connectionHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	s.listener = listener
+
+	return s, nil
+}
+
+// NewWithPacketConn creates a new Server instance that will use the given
+// packetConn (instead of creating its own) and that will call the given
+// connectionHandler to handle incoming "fake" connections. The packetConn is
+// closed when the Server is stopped. If it is started again, it will try to
+// listen at the same network and address as the original packetConn.
+func NewWithPacketConn(packetConn net.PacketConn,
+	connectionHandler ConnectionHandler) (*Server, error) {
+	if packetConn == nil {
+		return nil, fmt.Errorf("packetConn cannot be nil")
+	}
+
+	network, address := addrNetworkAndString(packetConn.LocalAddr())
+
+	s, err := New(network, address, connectionHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	s.packetConn = packetConn
+
+	return s, nil
+}
+
+// Use appends the given middlewares to the chain that wraps the
+// connectionHandler. Middlewares are applied in the order they were added, so
+// the first one added is the outermost one (the first to see a connection).
+// The chain is built when Start is called, so calling Use on a started Server
+// only takes effect after it is stopped and started again.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
 // Start tries to start listening for incoming connections. It returns a nil
 // error on success and a non-nil error on failure.
 func (s *Server) Start() error {
@@ -67,27 +152,80 @@ func (s *Server) Start() error {
 		return fmt.Errorf("server already started")
 	}
 
-	switch s.network {
-	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
-		listener, err := s.listen(s.network, s.address)
-		if err != nil {
-			return err
+	s.handler = chain(s.connectionHandler, s.middlewares)
+	s.stopCh = make(chan struct{})
+
+	// The listener or packetConn might already be set if this Server was
+	// created from an existing one (see NewWithListener and
+	// NewWithPacketConn).
+	if s.listener == nil && s.packetConn == nil {
+		if s.packetHandler != nil && isStreamNetwork(s.network) {
+			return fmt.Errorf("packet handlers require a packet network")
 		}
 
-		s.listener = listener
+		if isStreamNetwork(s.network) && s.acceptors > 1 {
+			listeners, err := s.listenReusePort(s.network, s.address,
+				s.acceptors)
+			if err != nil {
+				return err
+			}
 
-		s.wg.Add(1)
-		go s.listenLoop()
-	default:
-		packetConn, err := s.listenPacket(s.network, s.address)
-		if err != nil {
-			return err
+			s.listener = listeners[0]
+			s.listeners = listeners
+		} else if isStreamNetwork(s.network) {
+			listener, err := s.listen(s.network, s.address)
+			if err != nil {
+				return err
+			}
+
+			s.listener = listener
+		} else if s.packetIO.withDefaults().Sockets > 1 {
+			packetConns, err := s.listenPacketReusePort(s.network, s.address,
+				s.packetIO.Sockets)
+			if err != nil {
+				return err
+			}
+
+			s.packetConn = packetConns[0]
+			s.packetConns = packetConns
+		} else {
+			packetConn, err := s.listenPacket(s.network, s.address)
+			if err != nil {
+				return err
+			}
+
+			s.packetConn = packetConn
 		}
+	}
 
-		s.packetConn = packetConn
+	if s.listener != nil {
+		if len(s.listeners) == 0 {
+			s.listeners = []net.Listener{s.listener}
+		}
 
-		s.wg.Add(1)
-		go s.packetListenLoop()
+		for _, listener := range s.listeners {
+			s.wg.Add(1)
+			go s.listenLoop(listener)
+		}
+	} else {
+		if len(s.packetConns) == 0 {
+			s.packetConns = []net.PacketConn{s.packetConn}
+		}
+
+		config := s.packetIO.withDefaults()
+		bufferPool := newBufferPool(config.BufferSize)
+		for _, packetConn := range s.packetConns {
+			socket := newPacketSocket(s, packetConn, config, bufferPool)
+			if s.packetHandler != nil {
+				for i := 0; i < s.packetWorkers; i++ {
+					s.wg.Add(1)
+					go s.packetWorkerLoop(socket)
+				}
+			} else {
+				s.wg.Add(1)
+				go s.packetListenLoop(socket)
+			}
+		}
 	}
 
 	s.started = true
@@ -106,10 +244,15 @@ func (s *Server) Stop() error {
 	}
 
 	// Signal listener goroutines to exit.
+	close(s.stopCh)
 	if s.listener != nil {
-		s.listener.Close()
-	} else if s.packetConn != nil {
-		s.packetConn.Close()
+		for _, listener := range s.listeners {
+			listener.Close()
+		}
+	} else {
+		for _, packetConn := range s.packetConns {
+			packetConn.Close()
+		}
 	}
 
 	// Unlock while we wait for the gotoutines to cleanup.
@@ -121,106 +264,241 @@ func (s *Server) Stop() error {
 	// TODO(bga): Revisit this.
 	s.m.Lock()
 
+	s.listener = nil
+	s.listeners = nil
+	s.packetConn = nil
+	s.packetConns = nil
 	s.started = false
 
 	return nil
 }
 
-func (s *Server) listenLoop() {
+// closeSockets closes the listener or packet connection given to a Server
+// that was never started.
+func (s *Server) closeSockets() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+
+	if s.packetConn != nil {
+		s.packetConn.Close()
+		s.packetConn = nil
+	}
+}
+
+// Shutdown stops the Server (see Stop) and then waits for all active stream
+// connection handlers to return or for the given context to be done,
+// whatever happens first. Handlers for packet connections are already waited
+// for by Stop. It returns a nil error if all handlers returned and a non-nil
+// error otherwise.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Stop()
+	if err != nil {
+		return err
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		s.streamHandlersWg.Wait()
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// File returns a copy of the underlying listening socket file. It is the
+// caller's responsibility to close it when done. It returns a non-nil error if
+// the Server is not started or if the underlying listener or packet
+// connection does not support it.
+func (s *Server) File() (*os.File, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var socket interface{}
+	if s.listener != nil {
+		socket = s.listener
+	} else if s.packetConn != nil {
+		socket = s.packetConn
+	} else {
+		return nil, fmt.Errorf("server not started")
+	}
+
+	filer, ok := socket.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("%T does not support File", socket)
+	}
+
+	return filer.File()
+}
+
+// Addr returns the address the Server is listening at or nil if it is not
+// started.
+func (s *Server) Addr() net.Addr {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.listener != nil {
+		return s.listener.Addr()
+	} else if s.packetConn != nil {
+		return s.packetConn.LocalAddr()
+	}
+
+	return nil
+}
+
+func (s *Server) listenLoop(listener net.Listener) {
+	retrier := newRetrier(s.retryPolicy, s.stopCh)
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
+			if retrier.retry(err) {
 				continue
 			}
 			break
 		}
 
-		go s.connectionHandler(conn)
-	}
+		retrier.reset()
 
-	s.listener = nil
+		s.streamHandlersWg.Add(1)
+		go s.streamHandlerRunner(conn)
+	}
 
 	s.wg.Done()
 }
 
-func (s *Server) packetListenLoop() {
+func (s *Server) packetListenLoop(socket *packetSocket) {
+	if socket.config.BatchSize > 1 {
+		socket.writer = newPacketWriter(socket.conn, socket.bufferPool,
+			socket.config.BatchSize)
+
+		s.wg.Add(1)
+		go func() {
+			socket.writer.writeLoop()
+			s.wg.Done()
+		}()
+	}
+
+	retrier := newRetrier(s.retryPolicy, s.stopCh)
+	messages := newMessages(socket.config.BatchSize, socket.config.BufferSize)
 	for {
-		buffer := make([]byte, 4096)
-		n, addr, err := s.packetConn.ReadFrom(buffer)
+		n, err := socket.conn.ReadBatch(messages, 0)
 		if err != nil {
-			if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
+			if retrier.retry(err) {
 				continue
 			}
 			break
 		}
 
-		var localConn, remoteConn net.Conn
-		var ok bool
-		if localConn, ok = s.packetAddrConnMap[addr.String()]; !ok {
-			localConn, remoteConn = net.Pipe()
-			s.packetAddrConnMap[addr.String()] = localConn
-
-			// Read from localConn and relay data to packetConn.
-			s.wg.Add(1)
-			go s.relayLoop(localConn, addr)
+		retrier.reset()
 
-			// Handle connection.
-			s.wg.Add(1)
-			go s.connectionHandlerRunner(newConnAddrWrapper(remoteConn,
-				s.packetConn.LocalAddr(), addr))
+		for i := 0; i < n; i++ {
+			s.handleDatagram(socket,
+				messages[i].Buffers[0][:messages[i].N], messages[i].Addr)
 		}
+	}
 
-		_, err = localConn.Write(buffer[:n])
+	socket.closeSessions()
+
+	if socket.writer != nil {
+		socket.writer.close()
+	}
+
+	s.wg.Done()
+}
+
+// handleDatagram delivers the given datagram to the session associated with
+// its sender, creating a new session if needed.
+func (s *Server) handleDatagram(socket *packetSocket, data []byte,
+	addr net.Addr) {
+	localAddr := socket.packetConn.LocalAddr()
+	remoteAddr := addr
+	key := addr.String()
+	if s.proxyProtocol != nil && s.proxyProtocol.trusted(addr) {
+		header, headerLength, err := parseProxyHeaderV2(data)
 		if err != nil {
-			if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
-				continue
-			}
+			// Drop datagrams without a valid header.
+			return
+		}
 
-			break
+		data = data[headerLength:]
+		if header.srcAddr != nil {
+			localAddr = header.dstAddr
+			remoteAddr = header.srcAddr
+
+			// Several clients might be behind the same upstream.
+			key += "|" + remoteAddr.String()
 		}
 	}
 
-	for _, conn := range s.packetAddrConnMap {
-		conn.Close()
-	}
+	// If the session handler already closed its connection, try again with
+	// a new session.
+	for attempt := 0; attempt < 2; attempt++ {
+		session, created, ok := socket.session(key, addr, localAddr,
+			remoteAddr)
+		if !ok {
+			return
+		}
 
-	s.packetAddrConnMap = nil
-	s.packetConn = nil
+		if created {
+			s.wg.Add(1)
+			go s.connectionHandlerRunner(session)
+		}
 
-	s.wg.Done()
+		if session.enqueue(data) {
+			return
+		}
+
+		socket.removeSession(key, session)
+	}
 }
 
-func (s *Server) relayLoop(localConn net.Conn, addr net.Addr) {
-	buffer := make([]byte, 4096)
-	for {
-		n, err := localConn.Read(buffer)
+func (s *Server) streamHandlerRunner(conn net.Conn) {
+	if s.proxyProtocol != nil {
+		proxiedConn, err := s.proxyProtocol.wrapConn(conn)
 		if err != nil {
-			if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
-				continue
-			}
-			break
+			conn.Close()
+			s.streamHandlersWg.Done()
+			return
 		}
 
-		_, err = s.packetConn.WriteTo(buffer[:n], addr)
-		if err != nil {
-			if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
-				continue
-			}
-			break
-		}
+		conn = proxiedConn
 	}
 
-	localConn.Close()
-	delete(s.packetAddrConnMap, addr.String())
+	s.handler(conn)
 
-	s.wg.Done()
+	s.streamHandlersWg.Done()
 }
 
 func (s *Server) connectionHandlerRunner(conn net.Conn) {
-	s.connectionHandler(conn)
+	s.handler(conn)
 
 	conn.Close()
 
 	s.wg.Done()
 }
+
+func addrNetworkAndString(addr net.Addr) (string, string) {
+	if addr == nil {
+		return "", ""
+	}
+
+	return addr.Network(), addr.String()
+}
+
+func isStreamNetwork(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+		return true
+	}
+
+	return false
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	testing2 "github.com/brunoga/net/testing"
 )
@@ -142,6 +144,44 @@ func TestStop_TCP(t *testing.T) {
 	}
 }
 
+func TestStop_ClosesListener(t *testing.T) {
+	s, err := New("tcp", "127.0.0.1:0", func(net.Conn) {})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	closeCh := make(chan struct{})
+	listener := &testing2.MockListener{
+		AcceptFunc: func() (net.Conn, error) {
+			<-closeCh
+
+			return nil, net.ErrClosed
+		},
+		CloseFunc: func() error {
+			close(closeCh)
+
+			return nil
+		},
+	}
+	s.listen = func(string, string) (net.Listener, error) {
+		return listener, nil
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	listener.AssertNotCalled(t, "Close")
+
+	err = s.Stop()
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	listener.AssertClosed(t)
+}
+
 func TestStop_UDP(t *testing.T) {
 	s, err := New("udp", "127.0.0.1:8080", func(net.Conn) {})
 	if err != nil {
@@ -358,3 +398,123 @@ func TestConnection_UDP(t *testing.T) {
 
 	defer s.Stop()
 }
+
+func TestShutdown(t *testing.T) {
+	releaseCh := make(chan struct{})
+	startedCh := make(chan struct{})
+	s, err := New("tcp", "", func(net.Conn) {
+		close(startedCh)
+		<-releaseCh
+	})
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	connCh := make(chan net.Conn)
+	s.listen = func(string, string) (net.Listener, error) {
+		return &testing2.MockListener{
+			AcceptFunc: func() (net.Conn, error) {
+				conn := <-connCh
+				if conn == nil {
+					return nil, fmt.Errorf("accept error")
+				}
+
+				return conn, nil
+			},
+			CloseFunc: func() error {
+				close(connCh)
+				return nil
+			},
+		}, nil
+	}
+
+	err = s.Shutdown(context.Background())
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	err = s.Start()
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	_, remoteConn := net.Pipe()
+	connCh <- remoteConn
+	<-startedCh
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+
+	err = s.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded error, got %v", err)
+	}
+
+	close(releaseCh)
+
+	s.streamHandlersWg.Wait()
+}
+
+func TestSetNetwork(t *testing.T) {
+	for _, network := range []string{"tcp", "udp"} {
+		s, err := New(network, "", func(conn net.Conn) {
+			buffer := make([]byte, 64)
+			n, err := conn.Read(buffer)
+			if err == nil {
+				conn.Write(buffer[:n])
+			}
+
+			conn.Close()
+		})
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		n := testing2.NewNetwork()
+
+		err = s.SetNetwork(n)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		// SO_REUSEPORT is not supported.
+		s.SetAcceptors(2)
+		s.SetPacketIO(PacketIOConfig{Sockets: 2})
+
+		err = s.Start()
+		if err == nil {
+			t.Error("expected non-nil error, got nil")
+		}
+
+		s.SetAcceptors(1)
+		s.SetPacketIO(PacketIOConfig{})
+
+		err = s.Start()
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		err = s.SetNetwork(n)
+		if err == nil {
+			t.Error("expected non-nil error, got nil")
+		}
+
+		conn, err := n.Dial(network, s.Addr().String())
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte("hello"))
+
+		buffer := make([]byte, 64)
+		read, err := conn.Read(buffer)
+		if err != nil || string(buffer[:read]) != "hello" {
+			t.Errorf("expected %q, got %q (%v)", "hello", buffer[:read], err)
+		}
+
+		conn.Close()
+		s.Stop()
+	}
+}
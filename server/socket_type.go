@@ -0,0 +1,25 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// isDatagramSocket returns true if the given file is a SOCK_DGRAM socket.
+func isDatagramSocket(f *os.File) bool {
+	rawConn, err := f.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	var sockType int
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		sockType, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET,
+			syscall.SO_TYPE)
+	})
+
+	return err == nil && sockErr == nil && sockType == syscall.SOCK_DGRAM
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package server
+
+import "os"
+
+// isDatagramSocket returns true if the given file is a SOCK_DGRAM socket. The
+// socket type can not be checked on this platform, so it always returns
+// false.
+func isDatagramSocket(f *os.File) bool {
+	return false
+}
@@ -0,0 +1,279 @@
+package testing
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Condition returns a net.Conn that sends data through the given conn
+// according to the given Conditions. If conn is also a net.PacketConn (for
+// example, a connected UDP socket), each write is handled as a datagram.
+// Otherwise, writes are never dropped, duplicated or reordered. Data pending
+// delivery is still delivered after Close.
+func Condition(conn net.Conn, conditions Conditions) net.Conn {
+	_, datagrams := conn.(net.PacketConn)
+
+	return &conditionedConn{
+		Conn:       conn,
+		conditions: conditions,
+		link: newLink(conditions, datagrams,
+			func(b []byte, _ net.Addr) error {
+				_, err := conn.Write(b)
+
+				return err
+			}),
+	}
+}
+
+type conditionedConn struct {
+	net.Conn
+	conditions Conditions
+	link       *link
+
+	m             sync.Mutex
+	writeDeadline time.Time
+	written       int64
+	transferred   int64 // Read and written.
+	writeClosed   bool
+	closed        bool
+	reset         bool
+}
+
+func (c *conditionedConn) Read(b []byte) (int, error) {
+	if err := c.checkState("read", false); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(b)
+
+	c.m.Lock()
+
+	if c.closed {
+		c.m.Unlock()
+		return 0, net.ErrClosed
+	}
+
+	if c.reset {
+		c.m.Unlock()
+		return 0, c.opError("read", syscall.ECONNRESET)
+	}
+
+	limit := c.conditions.ResetAfter
+	if limit > 0 && c.transferred+int64(n) >= limit {
+		n = int(limit - c.transferred)
+		c.transferred = limit
+		c.m.Unlock()
+
+		c.abort()
+
+		return n, c.opError("read", syscall.ECONNRESET)
+	}
+
+	c.transferred += int64(n)
+
+	c.m.Unlock()
+
+	return n, err
+}
+
+func (c *conditionedConn) Write(b []byte) (int, error) {
+	if err := c.checkState("write", true); err != nil {
+		return 0, err
+	}
+
+	c.m.Lock()
+
+	n := int64(len(b))
+	closeWrite, reset := false, false
+	if limit := c.conditions.CloseWriteAfter; limit > 0 &&
+		c.written+n >= limit {
+		n = limit - c.written
+		closeWrite = true
+	}
+	if limit := c.conditions.ResetAfter; limit > 0 &&
+		c.transferred+n >= limit {
+		n = limit - c.transferred
+		closeWrite, reset = false, true
+	}
+
+	c.written += n
+	c.transferred += n
+
+	c.m.Unlock()
+
+	if n > 0 {
+		err := c.link.send(b[:n], nil, c.getWriteDeadline)
+		if err != nil {
+			return 0, c.opError("write", err)
+		}
+	}
+
+	if reset {
+		c.abort()
+
+		return int(n), c.opError("write", syscall.ECONNRESET)
+	}
+
+	if closeWrite {
+		c.CloseWrite()
+
+		return int(n), c.opError("write", syscall.EPIPE)
+	}
+
+	return int(n), nil
+}
+
+// CloseWrite shuts down the writing side of the connection once all pending
+// data is delivered (if the underlying connection supports it).
+func (c *conditionedConn) CloseWrite() error {
+	c.m.Lock()
+	c.writeClosed = true
+	c.m.Unlock()
+
+	c.link.close(true)
+
+	go func() {
+		<-c.link.doneCh
+
+		if closeWriter, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+			closeWriter.CloseWrite()
+		}
+	}()
+
+	return nil
+}
+
+func (c *conditionedConn) Close() error {
+	c.m.Lock()
+
+	if c.closed {
+		c.m.Unlock()
+		return net.ErrClosed
+	}
+
+	c.closed = true
+
+	c.m.Unlock()
+
+	c.link.close(true)
+
+	// Unblock readers.
+	c.Conn.SetReadDeadline(time.Unix(1, 0))
+
+	go func() {
+		<-c.link.doneCh
+		c.Conn.Close()
+	}()
+
+	return nil
+}
+
+// abort resets the connection, discarding pending data.
+func (c *conditionedConn) abort() {
+	c.m.Lock()
+	c.reset = true
+	c.m.Unlock()
+
+	c.link.close(false)
+
+	if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+		// Send a RST instead of a FIN.
+		tcpConn.SetLinger(0)
+	}
+
+	c.Conn.Close()
+}
+
+func (c *conditionedConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for queueing data for delivery (see
+// Conditions). It is not passed to the underlying connection.
+func (c *conditionedConn) SetWriteDeadline(t time.Time) error {
+	c.m.Lock()
+	c.writeDeadline = t
+	c.m.Unlock()
+
+	c.link.notify()
+
+	return nil
+}
+
+func (c *conditionedConn) getWriteDeadline() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.writeDeadline
+}
+
+// checkState returns an error if the connection can not be read from (or
+// written to, if write is true).
+func (c *conditionedConn) checkState(op string, write bool) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	switch {
+	case c.closed:
+		return net.ErrClosed
+	case c.reset:
+		return c.opError(op, syscall.ECONNRESET)
+	case write && c.writeClosed:
+		return c.opError(op, syscall.EPIPE)
+	}
+
+	return nil
+}
+
+func (c *conditionedConn) opError(op string, err error) error {
+	if _, ok := err.(*net.OpError); ok {
+		return err
+	}
+
+	opError := &net.OpError{Op: op, Source: c.LocalAddr(),
+		Addr: c.RemoteAddr(), Err: err}
+	if opError.Source != nil {
+		opError.Net = opError.Source.Network()
+	}
+
+	return opError
+}
+
+// ConditionListener returns a net.Listener that conditions (see Condition)
+// all connections accepted by the given listener according to the given
+// Conditions. Each connection uses a different seed (Seed plus the number of
+// connections accepted before it).
+func ConditionListener(listener net.Listener,
+	conditions Conditions) net.Listener {
+	return &conditionedListener{
+		Listener:   listener,
+		conditions: conditions,
+	}
+}
+
+type conditionedListener struct {
+	net.Listener
+	conditions Conditions
+
+	m        sync.Mutex
+	accepted int64
+}
+
+func (l *conditionedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.m.Lock()
+	conditions := l.conditions
+	conditions.Seed += l.accepted
+	l.accepted++
+	l.m.Unlock()
+
+	return Condition(conn, conditions), nil
+}
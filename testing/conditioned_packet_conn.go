@@ -0,0 +1,108 @@
+package testing
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ConditionPacketConn returns a net.PacketConn that sends datagrams through
+// the given packetConn according to the given Conditions (CloseWriteAfter
+// and ResetAfter are ignored). Datagrams pending delivery are still delivered
+// after Close.
+func ConditionPacketConn(packetConn net.PacketConn,
+	conditions Conditions) net.PacketConn {
+	return &conditionedPacketConn{
+		PacketConn: packetConn,
+		link: newLink(conditions, true, func(b []byte, addr net.Addr) error {
+			_, err := packetConn.WriteTo(b, addr)
+
+			return err
+		}),
+	}
+}
+
+type conditionedPacketConn struct {
+	net.PacketConn
+	link *link
+
+	m             sync.Mutex
+	writeDeadline time.Time
+	closed        bool
+}
+
+func (c *conditionedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if c.isClosed() {
+		return 0, nil, net.ErrClosed
+	}
+
+	return n, addr, err
+}
+
+func (c *conditionedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	err := c.link.send(b, addr, c.getWriteDeadline)
+	if err != nil {
+		return 0, &net.OpError{Op: "write", Net: addr.Network(),
+			Source: c.LocalAddr(), Addr: addr, Err: err}
+	}
+
+	return len(b), nil
+}
+
+func (c *conditionedPacketConn) Close() error {
+	c.m.Lock()
+
+	if c.closed {
+		c.m.Unlock()
+		return net.ErrClosed
+	}
+
+	c.closed = true
+
+	c.m.Unlock()
+
+	c.link.close(true)
+
+	// Unblock readers.
+	c.PacketConn.SetReadDeadline(time.Unix(1, 0))
+
+	go func() {
+		<-c.link.doneCh
+		c.PacketConn.Close()
+	}()
+
+	return nil
+}
+
+func (c *conditionedPacketConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+
+	return c.PacketConn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for queueing datagrams for delivery. It
+// is not passed to the underlying packet connection.
+func (c *conditionedPacketConn) SetWriteDeadline(t time.Time) error {
+	c.m.Lock()
+	c.writeDeadline = t
+	c.m.Unlock()
+
+	c.link.notify()
+
+	return nil
+}
+
+func (c *conditionedPacketConn) getWriteDeadline() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.writeDeadline
+}
+
+func (c *conditionedPacketConn) isClosed() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.closed
+}
@@ -0,0 +1,291 @@
+package testing
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brunoga/net/internal/waiter"
+)
+
+// Maximum number of bytes queued for sending by a conditioned connection.
+// Stream writes block and datagrams are dropped once it is reached.
+const maxConditionedQueuedBytes = 64 * 1024
+
+// Conditions describes the network conditions simulated by conditioned
+// connections and listeners (see Condition, ConditionPacketConn and
+// ConditionListener). Conditions only apply to data sent through the
+// conditioned side, so both sides must be conditioned to impair both
+// directions. Zero values disable the respective condition.
+//
+// Faults are decided by a random number generator initialized with Seed, so
+// the same seed and the same sequence of writes always result in the same
+// faults (delivery times, being wall clock based, are not reproducible).
+type Conditions struct {
+	// Seed for the random number generator that decides which faults are
+	// injected.
+	Seed int64
+
+	// Latency is added to every write.
+	Latency time.Duration
+
+	// Jitter is the maximum random delay added to the Latency of every
+	// write. Stream writes are never reordered by it.
+	Jitter time.Duration
+
+	// Bandwidth is the maximum number of bytes per second sent.
+	Bandwidth int
+
+	// Loss is the probability (0.0 to 1.0) of a datagram being dropped.
+	// Only applies to packet connections.
+	Loss float64
+
+	// Duplication is the probability (0.0 to 1.0) of a datagram being sent
+	// twice. Only applies to packet connections.
+	Duplication float64
+
+	// Reordering is the probability (0.0 to 1.0) of a datagram being delayed
+	// by an extra ReorderDelay, so it arrives after datagrams sent after it.
+	// Only applies to packet connections.
+	Reordering float64
+
+	// ReorderDelay is the extra delay for reordered datagrams. Defaults to
+	// 10ms.
+	ReorderDelay time.Duration
+
+	// Corruption is the probability (0.0 to 1.0) of a write (a datagram for
+	// packet connections) having a random bit flipped.
+	Corruption float64
+
+	// CloseWriteAfter is the number of bytes after which the writing side of
+	// a connection is shut down (the peer reads io.EOF and further writes
+	// fail with EPIPE). Only applies to connections (see Condition).
+	CloseWriteAfter int64
+
+	// ResetAfter is the number of bytes (read and written) after which a
+	// connection is abruptly reset (pending data is discarded and further
+	// reads and writes fail with ECONNRESET). Only applies to connections
+	// (see Condition).
+	ResetAfter int64
+}
+
+func (c Conditions) withDefaults() Conditions {
+	if c.ReorderDelay <= 0 {
+		c.ReorderDelay = 10 * time.Millisecond
+	}
+
+	return c
+}
+
+type delivery struct {
+	data []byte
+	addr net.Addr
+	due  time.Time
+}
+
+// link delivers data written to a conditioned connection according to its
+// Conditions.
+type link struct {
+	conditions Conditions
+	datagrams  bool // Data is sent as datagrams (instead of a stream).
+	write      func([]byte, net.Addr) error
+	doneCh     chan struct{} // Closed when the link is closed and drained.
+
+	m           sync.Mutex
+	changed     waiter.Waiter // Notified when the state changes.
+	random      *rand.Rand
+	queue       []delivery // Sorted by due time.
+	queuedBytes int
+	free        time.Time // When all queued data has been transmitted.
+	last        time.Time // Due time of the last stream write.
+	err         error     // First stream write error.
+	closed      bool
+}
+
+func newLink(conditions Conditions, datagrams bool,
+	write func([]byte, net.Addr) error) *link {
+	l := &link{
+		conditions: conditions.withDefaults(),
+		datagrams:  datagrams,
+		write:      write,
+		doneCh:     make(chan struct{}),
+		random:     rand.New(rand.NewSource(conditions.Seed)),
+	}
+
+	go l.deliverLoop()
+
+	return l
+}
+
+// send schedules the given data to be sent to the given address (ignored by
+// streams) according to the link Conditions. For streams, it blocks while
+// too much data is queued.
+func (l *link) send(b []byte, addr net.Addr,
+	deadline func() time.Time) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for {
+		if l.err != nil {
+			return l.err
+		}
+
+		if l.closed {
+			return net.ErrClosed
+		}
+
+		if expired(deadline()) {
+			return os.ErrDeadlineExceeded
+		}
+
+		if l.queuedBytes == 0 ||
+			l.queuedBytes+len(b) <= maxConditionedQueuedBytes {
+			break
+		}
+
+		if l.datagrams {
+			// Dropped, as by a congested router.
+			return nil
+		}
+
+		if !l.changed.Wait(&l.m, deadline()) {
+			return os.ErrDeadlineExceeded
+		}
+	}
+
+	// Always draw the same random values (in the same order) so faults only
+	// depend on the seed and the sequence of writes.
+	lost := l.random.Float64() < l.conditions.Loss
+	duplicated := l.random.Float64() < l.conditions.Duplication
+	reordered := l.random.Float64() < l.conditions.Reordering
+	corrupted := l.random.Float64() < l.conditions.Corruption
+	jitter := time.Duration(l.random.Int63n(int64(l.conditions.Jitter) + 1))
+
+	data := append([]byte(nil), b...)
+	if corrupted && len(data) > 0 {
+		bit := l.random.Intn(len(data) * 8)
+		data[bit/8] ^= 1 << (bit % 8)
+	}
+
+	if l.datagrams && lost {
+		return nil
+	}
+
+	now := time.Now()
+	if l.free.Before(now) {
+		l.free = now
+	}
+
+	if l.conditions.Bandwidth > 0 {
+		l.free = l.free.Add(time.Duration(len(data)) * time.Second /
+			time.Duration(l.conditions.Bandwidth))
+	}
+
+	due := l.free.Add(l.conditions.Latency + jitter)
+	if l.datagrams {
+		if reordered {
+			due = due.Add(l.conditions.ReorderDelay)
+		}
+	} else {
+		if due.Before(l.last) {
+			due = l.last
+		}
+
+		l.last = due
+	}
+
+	l.enqueueLocked(delivery{data, addr, due})
+	if l.datagrams && duplicated {
+		l.enqueueLocked(delivery{data, addr, due})
+	}
+
+	return nil
+}
+
+// enqueueLocked inserts the given delivery after all deliveries due before or
+// at the same time.
+func (l *link) enqueueLocked(d delivery) {
+	i := len(l.queue)
+	for i > 0 && l.queue[i-1].due.After(d.due) {
+		i--
+	}
+
+	l.queue = append(l.queue, delivery{})
+	copy(l.queue[i+1:], l.queue[i:])
+	l.queue[i] = d
+
+	l.queuedBytes += len(d.data)
+	l.changed.Notify()
+}
+
+func (l *link) deliverLoop() {
+	defer close(l.doneCh)
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for {
+		if len(l.queue) == 0 {
+			if l.closed {
+				return
+			}
+
+			l.changed.Wait(&l.m, time.Time{})
+
+			continue
+		}
+
+		d := l.queue[0]
+		if time.Now().Before(d.due) {
+			l.changed.Wait(&l.m, d.due)
+
+			continue
+		}
+
+		l.queue = l.queue[1:]
+		l.queuedBytes -= len(d.data)
+		l.changed.Notify()
+
+		l.m.Unlock()
+		err := l.write(d.data, d.addr)
+		l.m.Lock()
+
+		// Datagrams are best effort.
+		if err != nil && !l.datagrams && l.err == nil {
+			l.err = err
+			l.discardLocked()
+		}
+	}
+}
+
+// close stops accepting data. If flush is true, queued data is still
+// delivered. Otherwise it is discarded. doneCh is closed once no data is
+// queued.
+func (l *link) close(flush bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.closed = true
+	if !flush {
+		l.discardLocked()
+	}
+
+	l.changed.Notify()
+}
+
+func (l *link) discardLocked() {
+	l.queue = nil
+	l.queuedBytes = 0
+	l.changed.Notify()
+}
+
+// notify wakes up senders blocked in send (for example, after a deadline
+// change).
+func (l *link) notify() {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.changed.Notify()
+}
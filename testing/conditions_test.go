@@ -0,0 +1,257 @@
+package testing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// conditionedStream returns both sides of a stream connection whose first
+// side is conditioned with the given Conditions.
+func conditionedStream(t *testing.T,
+	conditions Conditions) (net.Conn, net.Conn) {
+	n := NewNetwork()
+
+	l, err := n.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer l.Close()
+
+	conn, err := n.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	peer, err := l.Accept()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	return Condition(conn, conditions), peer
+}
+
+// receiveDatagrams sends count datagrams (their index as a string) through a
+// packet connection conditioned with the given Conditions and returns the
+// ones received within 100ms of the last one being sent.
+func receiveDatagrams(t *testing.T, conditions Conditions,
+	count int) []string {
+	n := NewNetwork()
+
+	receiver, err := n.ListenPacket("udp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer receiver.Close()
+
+	sender, err := n.ListenPacket("udp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	sender = ConditionPacketConn(sender, conditions)
+	defer sender.Close()
+
+	for i := 0; i < count; i++ {
+		sender.WriteTo([]byte(fmt.Sprint(i)), receiver.LocalAddr())
+	}
+
+	receiver.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	var received []string
+	buffer := make([]byte, 64)
+	for {
+		n, _, err := receiver.ReadFrom(buffer)
+		if err != nil {
+			return received
+		}
+
+		received = append(received, string(buffer[:n]))
+	}
+}
+
+func TestCondition_Latency(t *testing.T) {
+	conn, peer := conditionedStream(t, Conditions{
+		Latency: 50 * time.Millisecond,
+		Jitter:  10 * time.Millisecond,
+	})
+	defer conn.Close()
+	defer peer.Close()
+
+	start := time.Now()
+	for _, data := range []string{"a", "b", "c"} {
+		conn.Write([]byte(data))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(peer, 3))
+	if err != nil || string(data) != "abc" {
+		t.Errorf("expected %q, got %q (%v)", "abc", data, err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected at least 50ms, got %v", elapsed)
+	}
+}
+
+func TestCondition_Bandwidth(t *testing.T) {
+	conn, peer := conditionedStream(t, Conditions{Bandwidth: 100000})
+	defer conn.Close()
+	defer peer.Close()
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		conn.Write(make([]byte, 1000))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(peer, 10000))
+	if err != nil || len(data) != 10000 {
+		t.Errorf("expected 10000 bytes, got %d (%v)", len(data), err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected at least 100ms, got %v", elapsed)
+	}
+}
+
+func TestCondition_Corruption(t *testing.T) {
+	conn, peer := conditionedStream(t, Conditions{Corruption: 1})
+	defer conn.Close()
+	defer peer.Close()
+
+	sent := []byte("hello")
+	conn.Write(sent)
+
+	received := make([]byte, len(sent))
+	io.ReadFull(peer, received)
+
+	flipped := 0
+	for i := range sent {
+		for diff := sent[i] ^ received[i]; diff != 0; diff &= diff - 1 {
+			flipped++
+		}
+	}
+
+	if flipped != 1 {
+		t.Errorf("expected 1 flipped bit, got %d", flipped)
+	}
+}
+
+func TestCondition_CloseWriteAfter(t *testing.T) {
+	conn, peer := conditionedStream(t, Conditions{CloseWriteAfter: 5})
+	defer conn.Close()
+	defer peer.Close()
+
+	n, err := conn.Write([]byte("hello world"))
+	if n != 5 || !errors.Is(err, syscall.EPIPE) {
+		t.Errorf("expected 5 bytes and EPIPE, got %d (%v)", n, err)
+	}
+
+	_, err = conn.Write([]byte("again"))
+	if !errors.Is(err, syscall.EPIPE) {
+		t.Errorf("expected EPIPE, got %v", err)
+	}
+
+	data, err := io.ReadAll(peer)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("expected %q, got %q (%v)", "hello", data, err)
+	}
+
+	// Reading still works.
+	peer.Write([]byte("bye"))
+
+	buffer := make([]byte, 3)
+	_, err = io.ReadFull(conn, buffer)
+	if err != nil || string(buffer) != "bye" {
+		t.Errorf("expected %q, got %q (%v)", "bye", buffer, err)
+	}
+}
+
+func TestCondition_ResetAfter(t *testing.T) {
+	conn, peer := conditionedStream(t, Conditions{ResetAfter: 8})
+	defer peer.Close()
+
+	peer.Write([]byte("hi!"))
+
+	buffer := make([]byte, 3)
+	_, err := io.ReadFull(conn, buffer)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	n, err := conn.Write([]byte("hello world"))
+	if n != 5 || !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("expected 5 bytes and ECONNRESET, got %d (%v)", n, err)
+	}
+
+	_, err = conn.Read(buffer)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("expected ECONNRESET, got %v", err)
+	}
+
+	// Pending data is discarded.
+	_, err = peer.Read(buffer)
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestConditionPacketConn(t *testing.T) {
+	received := receiveDatagrams(t, Conditions{Loss: 0.5, Seed: 1}, 100)
+	if len(received) < 25 || len(received) > 75 {
+		t.Errorf("expected about 50 datagrams, got %d", len(received))
+	}
+
+	// Same seed, same faults.
+	again := receiveDatagrams(t, Conditions{Loss: 0.5, Seed: 1}, 100)
+	if fmt.Sprint(again) != fmt.Sprint(received) {
+		t.Errorf("expected %v, got %v", received, again)
+	}
+
+	received = receiveDatagrams(t, Conditions{Duplication: 1}, 3)
+	if fmt.Sprint(received) != "[0 0 1 1 2 2]" {
+		t.Errorf("expected [0 0 1 1 2 2], got %v", received)
+	}
+
+	received = receiveDatagrams(t, Conditions{Reordering: 0.5, Seed: 1}, 10)
+	if len(received) != 10 || fmt.Sprint(received) ==
+		"[0 1 2 3 4 5 6 7 8 9]" {
+		t.Errorf("expected 10 reordered datagrams, got %v", received)
+	}
+}
+
+func TestConditionListener(t *testing.T) {
+	n := NewNetwork()
+
+	l, err := n.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	l = ConditionListener(l, Conditions{Corruption: 1})
+	defer l.Close()
+
+	conn, err := n.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer conn.Close()
+
+	peer, err := l.Accept()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer peer.Close()
+
+	peer.Write([]byte("hello"))
+
+	buffer := make([]byte, 5)
+	io.ReadFull(conn, buffer)
+	if bytes.Equal(buffer, []byte("hello")) {
+		t.Error("expected corrupted data, got original")
+	}
+}
@@ -0,0 +1,418 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MakePipe creates a pair of connected net.Conns for the conformance tests
+// (see TestConn and TestDatagramConn). The stop function is called once a
+// test is done with them.
+type MakePipe func() (c1, c2 net.Conn, stop func(), err error)
+
+type conformanceTest struct {
+	name string
+	f    func(t *testing.T, c1, c2 net.Conn)
+}
+
+// TestConn tests that the stream net.Conns created by makePipe behave like
+// the ones in package net: data is delivered in order and without message
+// boundaries, deadlines (past, future and changed while blocked) are
+// honored, Close unblocks pending reads and writes, concurrent use is safe
+// (run with -race) and addresses are reported consistently. Writes must
+// block once enough data is written without being read.
+func TestConn(t *testing.T, makePipe MakePipe) {
+	runConformanceTests(t, makePipe, []conformanceTest{
+		{"BasicIO", testStreamBasicIO},
+		{"PingPong", testPingPong},
+		{"RacyRead", testRacyRead},
+		{"RacyWrite", testRacyWrite},
+		{"ReadTimeout", testReadTimeout},
+		{"WriteTimeout", testWriteTimeout},
+		{"BlockedWriteTimeout", testBlockedWriteTimeout},
+		{"CloseUnblocksRead", testCloseUnblocksRead},
+		{"CloseUnblocksWrite", testCloseUnblocksWrite},
+		{"UseAfterClose", testUseAfterClose},
+		{"Addresses", testAddresses},
+	})
+}
+
+// TestDatagramConn is like TestConn, but for net.Conns backed by datagrams
+// (for example, connected UDP sockets or the packet session connections
+// created by server.Server): each Write must be read by exactly one Read and
+// writes are never expected to block. Datagrams must not be lost or
+// reordered for small bursts.
+func TestDatagramConn(t *testing.T, makePipe MakePipe) {
+	runConformanceTests(t, makePipe, []conformanceTest{
+		{"MessageBoundaries", testMessageBoundaries},
+		{"PingPong", testPingPong},
+		{"RacyRead", testRacyRead},
+		{"RacyWrite", testRacyWrite},
+		{"ReadTimeout", testReadTimeout},
+		{"WriteTimeout", testWriteTimeout},
+		{"CloseUnblocksRead", testCloseUnblocksRead},
+		{"UseAfterClose", testUseAfterClose},
+		{"Addresses", testAddresses},
+	})
+}
+
+func runConformanceTests(t *testing.T, makePipe MakePipe,
+	tests []conformanceTest) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			c1, c2, stop, err := makePipe()
+			if err != nil {
+				t.Fatalf("unable to make pipe: %v", err)
+			}
+			defer stop()
+
+			test.f(t, c1, c2)
+		})
+	}
+}
+
+// testStreamBasicIO checks that data written in random sized chunks is read
+// in full and in order.
+func testStreamBasicIO(t *testing.T, c1, c2 net.Conn) {
+	data := make([]byte, 1<<20)
+	random := rand.New(rand.NewSource(0))
+	random.Read(data)
+
+	go func() {
+		remaining := data
+		for len(remaining) > 0 {
+			n := random.Intn(64*1024) + 1
+			if n > len(remaining) {
+				n = len(remaining)
+			}
+
+			_, err := c1.Write(remaining[:n])
+			if err != nil {
+				t.Errorf("unexpected Write error: %v", err)
+				break
+			}
+
+			remaining = remaining[n:]
+		}
+
+		c1.Close()
+	}()
+
+	received, err := io.ReadAll(c2)
+	if err != nil {
+		t.Errorf("unexpected Read error: %v", err)
+	}
+
+	if !bytes.Equal(received, data) {
+		t.Errorf("expected %d bytes, got %d (different data)", len(data),
+			len(received))
+	}
+}
+
+// testMessageBoundaries checks that each write is read by exactly one read.
+func testMessageBoundaries(t *testing.T, c1, c2 net.Conn) {
+	messages := []string{"a", "bb", "ccc", "dddd"}
+	for _, message := range messages {
+		_, err := c1.Write([]byte(message))
+		if err != nil {
+			t.Fatalf("unexpected Write error: %v", err)
+		}
+	}
+
+	c2.SetReadDeadline(time.Now().Add(time.Second))
+
+	buffer := make([]byte, 64)
+	for _, message := range messages {
+		n, err := c2.Read(buffer)
+		if err != nil || string(buffer[:n]) != message {
+			t.Errorf("expected %q, got %q (%v)", message, buffer[:n], err)
+		}
+	}
+}
+
+// testPingPong checks that both sides can alternate reading and writing.
+func testPingPong(t *testing.T, c1, c2 net.Conn) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// The first side writes odd numbers and the second one even numbers.
+	pingPong := func(c net.Conn, odd bool) {
+		defer wg.Done()
+
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+
+		buffer := make([]byte, 8)
+		for i := uint64(1); i <= 100; i++ {
+			if (i%2 == 1) == odd {
+				binary.BigEndian.PutUint64(buffer, i)
+
+				_, err := c.Write(buffer)
+				if err != nil {
+					t.Errorf("unexpected Write error: %v", err)
+					return
+				}
+
+				continue
+			}
+
+			_, err := io.ReadFull(c, buffer)
+			if err != nil {
+				t.Errorf("unexpected Read error: %v", err)
+				return
+			}
+
+			if got := binary.BigEndian.Uint64(buffer); got != i {
+				t.Errorf("expected %d, got %d", i, got)
+				return
+			}
+		}
+	}
+
+	wg.Add(2)
+	go pingPong(c1, true)
+	go pingPong(c2, false)
+}
+
+// testRacyRead checks that concurrent reads (and deadline changes) are safe.
+func testRacyRead(t *testing.T, c1, c2 net.Conn) {
+	go func() {
+		for {
+			_, err := c2.Write(make([]byte, 1024))
+			if err != nil {
+				return
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	c1.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			buffer := make([]byte, 1024)
+			for j := 0; j < 10; j++ {
+				_, err := c1.Read(buffer)
+				if err != nil && !isTimeout(err) {
+					t.Errorf("unexpected Read error: %v", err)
+					return
+				}
+
+				c1.SetReadDeadline(time.Now().Add(time.Duration(i+j) *
+					time.Millisecond))
+			}
+		}(i)
+	}
+}
+
+// testRacyWrite checks that concurrent writes (and deadline changes) are
+// safe.
+func testRacyWrite(t *testing.T, c1, c2 net.Conn) {
+	go io.Copy(io.Discard, c2)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	c1.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			buffer := make([]byte, 1024)
+			for j := 0; j < 10; j++ {
+				_, err := c1.Write(buffer)
+				if err != nil && !isTimeout(err) {
+					t.Errorf("unexpected Write error: %v", err)
+					return
+				}
+
+				c1.SetWriteDeadline(time.Now().Add(time.Duration(i+j) *
+					time.Millisecond))
+			}
+		}(i)
+	}
+}
+
+// testReadTimeout checks past, future and changed read deadlines.
+func testReadTimeout(t *testing.T, c1, c2 net.Conn) {
+	buffer := make([]byte, 64)
+
+	// Past.
+	c1.SetReadDeadline(time.Now().Add(-time.Second))
+
+	_, err := c1.Read(buffer)
+	if !isTimeout(err) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+
+	// Future.
+	start := time.Now()
+	c1.SetReadDeadline(start.Add(50 * time.Millisecond))
+
+	_, err = c1.Read(buffer)
+	if !isTimeout(err) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond ||
+		elapsed > time.Second {
+		t.Errorf("expected about 50ms, got %v", elapsed)
+	}
+
+	// Changed while blocked.
+	c1.SetReadDeadline(time.Time{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		c1.SetReadDeadline(time.Now())
+	})
+
+	_, err = c1.Read(buffer)
+	if !isTimeout(err) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+
+	// Cleared.
+	c1.SetReadDeadline(time.Time{})
+
+	go c2.Write([]byte("data"))
+
+	n, err := io.ReadFull(c1, buffer[:4])
+	if err != nil || string(buffer[:n]) != "data" {
+		t.Errorf("expected %q, got %q (%v)", "data", buffer[:n], err)
+	}
+}
+
+// testWriteTimeout checks past write deadlines.
+func testWriteTimeout(t *testing.T, c1, c2 net.Conn) {
+	c1.SetWriteDeadline(time.Now().Add(-time.Second))
+
+	_, err := c1.Write([]byte("data"))
+	if !isTimeout(err) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+// testBlockedWriteTimeout checks that writes blocked because data is not
+// being read honor the write deadline.
+func testBlockedWriteTimeout(t *testing.T, c1, c2 net.Conn) {
+	start := time.Now()
+	c1.SetWriteDeadline(start.Add(50 * time.Millisecond))
+
+	buffer := make([]byte, 64*1024)
+	for {
+		_, err := c1.Write(buffer)
+		if err != nil {
+			if !isTimeout(err) {
+				t.Errorf("expected timeout error, got %v", err)
+			}
+
+			break
+		}
+
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("expected Write to block, got no blocking")
+		}
+	}
+}
+
+// testCloseUnblocksRead checks that Close unblocks a pending Read.
+func testCloseUnblocksRead(t *testing.T, c1, c2 net.Conn) {
+	time.AfterFunc(50*time.Millisecond, func() {
+		c1.Close()
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c1.Read(make([]byte, 64))
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("expected non-nil error, got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected Read to be unblocked by Close")
+	}
+}
+
+// testCloseUnblocksWrite checks that Close unblocks a pending Write.
+func testCloseUnblocksWrite(t *testing.T, c1, c2 net.Conn) {
+	time.AfterFunc(50*time.Millisecond, func() {
+		c1.Close()
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		buffer := make([]byte, 64*1024)
+		for {
+			_, err := c1.Write(buffer)
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-errCh:
+	case <-time.After(5 * time.Second):
+		t.Error("expected Write to be unblocked by Close")
+	}
+}
+
+// testUseAfterClose checks that Read and Write fail after Close.
+func testUseAfterClose(t *testing.T, c1, c2 net.Conn) {
+	err := c1.Close()
+	if err != nil {
+		t.Errorf("unexpected Close error: %v", err)
+	}
+
+	_, err = c1.Read(make([]byte, 64))
+	if err == nil {
+		t.Error("expected Read error, got nil")
+	}
+
+	_, err = c1.Write([]byte("data"))
+	if err == nil {
+		t.Error("expected Write error, got nil")
+	}
+}
+
+// testAddresses checks that both sides report consistent addresses.
+func testAddresses(t *testing.T, c1, c2 net.Conn) {
+	for _, addr := range []net.Addr{c1.LocalAddr(), c1.RemoteAddr(),
+		c2.LocalAddr(), c2.RemoteAddr()} {
+		if addr == nil || addr.Network() == "" {
+			t.Fatalf("expected address with network, got %v", addr)
+		}
+	}
+
+	if c1.LocalAddr().String() != c2.RemoteAddr().String() ||
+		c1.RemoteAddr().String() != c2.LocalAddr().String() {
+		t.Errorf("expected matching addresses, got %v <-> %v and %v <-> %v",
+			c1.LocalAddr(), c1.RemoteAddr(), c2.LocalAddr(),
+			c2.RemoteAddr())
+	}
+}
+
+// isTimeout returns true if the given error is a timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,91 @@
+package testing
+
+import (
+	"net"
+	"testing"
+)
+
+// networkPipe returns a MakePipe that creates connections over a new Network
+// with the given network name.
+func networkPipe(network string) MakePipe {
+	return func() (net.Conn, net.Conn, func(), error) {
+		n := NewNetwork()
+
+		if network == "udp" {
+			c2, err := n.Dial("udp", "127.0.0.1:1000")
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			// There is no way to bind a connected socket, so do it by hand.
+			n.m.Lock()
+			addr, key, err := n.bindLocked("udp", "127.0.0.1:1000")
+			if err != nil {
+				n.m.Unlock()
+				return nil, nil, nil, err
+			}
+
+			c1 := newNetworkPacketConn(n, "udp", addr, key, c2.LocalAddr())
+			n.packetConns[key] = c1
+			n.m.Unlock()
+
+			return c1, c2, func() {
+				c1.Close()
+				c2.Close()
+			}, nil
+		}
+
+		l, err := n.Listen(network, "")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		defer l.Close()
+
+		c1, err := n.Dial(network, l.Addr().String())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		c2, err := l.Accept()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		return c1, c2, func() {
+			c1.Close()
+			c2.Close()
+		}, nil
+	}
+}
+
+func TestConn_Pipe(t *testing.T) {
+	TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		c1, c2 := net.Pipe()
+
+		return c1, c2, func() {
+			c1.Close()
+			c2.Close()
+		}, nil
+	})
+}
+
+func TestConn_Network(t *testing.T) {
+	TestConn(t, networkPipe("tcp"))
+}
+
+func TestDatagramConn_Network(t *testing.T) {
+	TestDatagramConn(t, networkPipe("udp"))
+}
+
+func TestConn_Conditioned(t *testing.T) {
+	makePipe := networkPipe("tcp")
+
+	TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		c1, c2, stop, err := makePipe()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		return Condition(c1, Conditions{}), c2, stop, nil
+	})
+}
@@ -1,12 +1,17 @@
 package testing
 
-// MockAddr is a mockable implementation of net.Addr.
+// MockAddr is a mockable implementation of net.Addr that records all calls (see
+// CallRecorder).
 type MockAddr struct {
 	NetworkFunc func() string
 	StringFunc  func() string
+
+	CallRecorder
 }
 
 func (m *MockAddr) Network() string {
+	m.record("Network")
+
 	if m.NetworkFunc != nil {
 		return m.NetworkFunc()
 	}
@@ -15,6 +20,8 @@ func (m *MockAddr) Network() string {
 }
 
 func (m *MockAddr) String() string {
+	m.record("String")
+
 	if m.StringFunc != nil {
 		return m.StringFunc()
 	}
@@ -0,0 +1,139 @@
+package testing
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brunoga/net/internal/waiter"
+)
+
+// TB is the subset of testing.TB used by mock assertions.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Call is a call to a mock method.
+type Call struct {
+	Method string
+	Args   []interface{}
+	Time   time.Time
+}
+
+func (c Call) String() string {
+	return fmt.Sprintf("%s%v", c.Method, c.Args)
+}
+
+// CallRecorder records calls to the methods of the mock it is embedded in.
+// Calls are recorded when they start, before the mock functions are called.
+// It is safe for concurrent use. The zero value is ready to use.
+type CallRecorder struct {
+	m       sync.Mutex
+	changed waiter.Waiter // Notified when a call is recorded.
+	calls   []Call
+}
+
+// Calls returns all recorded calls, in order.
+func (r *CallRecorder) Calls() []Call {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]Call(nil), r.calls...)
+}
+
+// CallsTo returns all recorded calls to the given method, in order.
+func (r *CallRecorder) CallsTo(method string) []Call {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.callsToLocked(method)
+}
+
+// ResetCalls forgets all recorded calls.
+func (r *CallRecorder) ResetCalls() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.calls = nil
+}
+
+// WaitForCalls waits for up to the given timeout for at least n calls to be
+// recorded. It returns an error with the recorded calls on timeout.
+func (r *CallRecorder) WaitForCalls(n int, timeout time.Duration) error {
+	return r.WaitForCallsTo("", n, timeout)
+}
+
+// WaitForCallsTo is like WaitForCalls, but only counts calls to the given
+// method (all methods if empty).
+func (r *CallRecorder) WaitForCallsTo(method string, n int,
+	timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for {
+		calls := r.callsToLocked(method)
+		if len(calls) >= n {
+			return nil
+		}
+
+		if !r.changed.Wait(&r.m, deadline) {
+			calls = r.callsToLocked(method)
+
+			return fmt.Errorf("expected %d calls, got %d: %v", n,
+				len(calls), calls)
+		}
+	}
+}
+
+// AssertCalled reports an error to t if the given method was not called.
+func (r *CallRecorder) AssertCalled(t TB, method string) {
+	t.Helper()
+
+	if len(r.CallsTo(method)) == 0 {
+		t.Errorf("expected call to %s, got %v", method, r.Calls())
+	}
+}
+
+// AssertNotCalled reports an error to t if the given method was called.
+func (r *CallRecorder) AssertNotCalled(t TB, method string) {
+	t.Helper()
+
+	if calls := r.CallsTo(method); len(calls) != 0 {
+		t.Errorf("expected no calls to %s, got %v", method, calls)
+	}
+}
+
+// AssertClosed reports an error to t if Close was not called.
+func (r *CallRecorder) AssertClosed(t TB) {
+	t.Helper()
+
+	r.AssertCalled(t, "Close")
+}
+
+// record records a call to the given method with the given arguments.
+func (r *CallRecorder) record(method string, args ...interface{}) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.calls = append(r.calls, Call{method, args, time.Now()})
+
+	r.changed.Notify()
+}
+
+func (r *CallRecorder) callsToLocked(method string) []Call {
+	if method == "" {
+		return append([]Call(nil), r.calls...)
+	}
+
+	var calls []Call
+	for _, call := range r.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
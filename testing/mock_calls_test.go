@@ -0,0 +1,76 @@
+package testing
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTB is a TB that records reported errors.
+type fakeTB struct {
+	errors []string
+}
+
+func (t *fakeTB) Helper() {}
+
+func (t *fakeTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCallRecorder(t *testing.T) {
+	m := &MockConn{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			m.Write([]byte("data"))
+		}()
+	}
+
+	err := m.WaitForCallsTo("Write", 10, time.Second)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	wg.Wait()
+
+	for _, call := range m.CallsTo("Write") {
+		if string(call.Args[0].([]byte)) != "data" || call.Time.IsZero() {
+			t.Errorf("expected Write[data] with time, got %v", call)
+		}
+	}
+
+	tb := &fakeTB{}
+	m.AssertClosed(tb)
+	m.AssertNotCalled(tb, "Write")
+	if len(tb.errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", tb.errors)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.Close()
+	}()
+
+	err = m.WaitForCalls(11, time.Second)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	tb = &fakeTB{}
+	m.AssertClosed(tb)
+	if len(tb.errors) != 0 {
+		t.Errorf("expected no errors, got %v", tb.errors)
+	}
+
+	m.ResetCalls()
+
+	err = m.WaitForCalls(1, 10*time.Millisecond)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
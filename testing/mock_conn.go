@@ -0,0 +1,102 @@
+package testing
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// MockConn is a mockable implementation of net.Conn that records all calls (see
+// CallRecorder).
+type MockConn struct {
+	ReadFunc             func(b []byte) (int, error)
+	WriteFunc            func(b []byte) (int, error)
+	CloseFunc            func() error
+	LocalAddrFunc        func() net.Addr
+	RemoteAddrFunc       func() net.Addr
+	SetDeadlineFunc      func(t time.Time) error
+	SetReadDeadlineFunc  func(t time.Time) error
+	SetWriteDeadlineFunc func(t time.Time) error
+
+	CallRecorder
+}
+
+func (m *MockConn) Read(b []byte) (int, error) {
+	m.record("Read", len(b))
+
+	if m.ReadFunc != nil {
+		return m.ReadFunc(b)
+	}
+
+	return 0, fmt.Errorf("read error")
+}
+
+func (m *MockConn) Write(b []byte) (int, error) {
+	m.record("Write", append([]byte(nil), b...))
+
+	if m.WriteFunc != nil {
+		return m.WriteFunc(b)
+	}
+
+	return 0, fmt.Errorf("write error")
+}
+
+func (m *MockConn) Close() error {
+	m.record("Close")
+
+	if m.CloseFunc != nil {
+		return m.CloseFunc()
+	}
+
+	return fmt.Errorf("close error")
+}
+
+func (m *MockConn) LocalAddr() net.Addr {
+	m.record("LocalAddr")
+
+	if m.LocalAddrFunc != nil {
+		return m.LocalAddrFunc()
+	}
+
+	return nil
+}
+
+func (m *MockConn) RemoteAddr() net.Addr {
+	m.record("RemoteAddr")
+
+	if m.RemoteAddrFunc != nil {
+		return m.RemoteAddrFunc()
+	}
+
+	return nil
+}
+
+func (m *MockConn) SetDeadline(t time.Time) error {
+	m.record("SetDeadline", t)
+
+	if m.SetDeadlineFunc != nil {
+		return m.SetDeadlineFunc(t)
+	}
+
+	return fmt.Errorf("setdeadline error")
+}
+
+func (m *MockConn) SetReadDeadline(t time.Time) error {
+	m.record("SetReadDeadline", t)
+
+	if m.SetReadDeadlineFunc != nil {
+		return m.SetReadDeadlineFunc(t)
+	}
+
+	return fmt.Errorf("setreaddeadline error")
+}
+
+func (m *MockConn) SetWriteDeadline(t time.Time) error {
+	m.record("SetWriteDeadline", t)
+
+	if m.SetWriteDeadlineFunc != nil {
+		return m.SetWriteDeadlineFunc(t)
+	}
+
+	return fmt.Errorf("setwritedeadline error")
+}
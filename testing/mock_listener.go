@@ -5,14 +5,19 @@ import (
 	"net"
 )
 
-// MockListener is a mockable implementation of net.Listener.
+// MockListener is a mockable implementation of net.Listener that records all
+// calls (see CallRecorder).
 type MockListener struct {
 	AcceptFunc func() (net.Conn, error)
 	CloseFunc  func() error
 	AddrFunc   func() net.Addr
+
+	CallRecorder
 }
 
 func (m *MockListener) Accept() (net.Conn, error) {
+	m.record("Accept")
+
 	if m.AcceptFunc != nil {
 		return m.AcceptFunc()
 	}
@@ -21,6 +26,8 @@ func (m *MockListener) Accept() (net.Conn, error) {
 }
 
 func (m *MockListener) Close() error {
+	m.record("Close")
+
 	if m.CloseFunc != nil {
 		return m.CloseFunc()
 	}
@@ -29,6 +36,8 @@ func (m *MockListener) Close() error {
 }
 
 func (m *MockListener) Addr() net.Addr {
+	m.record("Addr")
+
 	if m.AddrFunc != nil {
 		return m.AddrFunc()
 	}
@@ -6,7 +6,8 @@ import (
 	"time"
 )
 
-// MockPacketConn is a mockable implementation of net.PacketConn.
+// MockPacketConn is a mockable implementation of net.PacketConn that records
+// all calls (see CallRecorder).
 type MockPacketConn struct {
 	ReadFromFunc         func(b []byte) (int, net.Addr, error)
 	WriteToFunc          func(b []byte, addr net.Addr) (int, error)
@@ -15,9 +16,13 @@ type MockPacketConn struct {
 	SetDeadlineFunc      func(t time.Time) error
 	SetReadDeadlineFunc  func(t time.Time) error
 	SetWriteDeadlineFunc func(t time.Time) error
+
+	CallRecorder
 }
 
 func (m *MockPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	m.record("ReadFrom", len(b))
+
 	if m.ReadFromFunc != nil {
 		return m.ReadFromFunc(b)
 	}
@@ -26,6 +31,8 @@ func (m *MockPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
 }
 
 func (m *MockPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	m.record("WriteTo", append([]byte(nil), b...), addr)
+
 	if m.WriteToFunc != nil {
 		return m.WriteToFunc(b, addr)
 	}
@@ -34,6 +41,8 @@ func (m *MockPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
 }
 
 func (m *MockPacketConn) Close() error {
+	m.record("Close")
+
 	if m.CloseFunc != nil {
 		return m.CloseFunc()
 	}
@@ -42,6 +51,8 @@ func (m *MockPacketConn) Close() error {
 }
 
 func (m *MockPacketConn) LocalAddr() net.Addr {
+	m.record("LocalAddr")
+
 	if m.LocalAddrFunc != nil {
 		return m.LocalAddrFunc()
 	}
@@ -50,6 +61,8 @@ func (m *MockPacketConn) LocalAddr() net.Addr {
 }
 
 func (m *MockPacketConn) SetDeadline(t time.Time) error {
+	m.record("SetDeadline", t)
+
 	if m.SetDeadlineFunc != nil {
 		return m.SetDeadlineFunc(t)
 	}
@@ -58,6 +71,8 @@ func (m *MockPacketConn) SetDeadline(t time.Time) error {
 }
 
 func (m *MockPacketConn) SetReadDeadline(t time.Time) error {
+	m.record("SetReadDeadline", t)
+
 	if m.SetReadDeadlineFunc != nil {
 		return m.SetReadDeadlineFunc(t)
 	}
@@ -66,6 +81,8 @@ func (m *MockPacketConn) SetReadDeadline(t time.Time) error {
 }
 
 func (m *MockPacketConn) SetWriteDeadline(t time.Time) error {
+	m.record("SetWriteDeadline", t)
+
 	if m.SetWriteDeadlineFunc != nil {
 		return m.SetWriteDeadlineFunc(t)
 	}
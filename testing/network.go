@@ -0,0 +1,413 @@
+package testing
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+const firstEphemeralPort = 49152
+
+// Network is an in-memory network for hermetic tests. It provides Listen,
+// ListenPacket and Dial with the same semantics as the functions with the
+// same names in package net, for "tcp", "tcp4", "tcp6", "udp", "udp4",
+// "udp6", "unix", "unixpacket" and "unixgram" networks, without using any
+// real sockets. Hosts are only used in reported addresses (any host reaches
+// listeners on the same port), so there is no name resolution.
+//
+// Stream connections are buffered and support deadlines and CloseWrite.
+// Datagrams sent to addresses no one is listening at are silently dropped,
+// as are datagrams sent to sockets whose receive queue is full.
+type Network struct {
+	m           sync.Mutex
+	listeners   map[string]*networkListener
+	packetConns map[string]*networkPacketConn
+	nextPort    int
+}
+
+// NewNetwork creates a new, empty, Network.
+func NewNetwork() *Network {
+	return &Network{
+		listeners:   make(map[string]*networkListener),
+		packetConns: make(map[string]*networkPacketConn),
+		nextPort:    firstEphemeralPort,
+	}
+}
+
+// Listen announces on the given address of the given stream network.
+func (n *Network) Listen(network, address string) (net.Listener, error) {
+	family, err := networkFamily(network, true)
+	if err != nil {
+		return nil, err
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	addr, key, err := n.bindLocked(family, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: err}
+	}
+
+	listener := newNetworkListener(n, addr, key)
+	n.listeners[key] = listener
+
+	return listener, nil
+}
+
+// ListenPacket announces on the given address of the given packet network.
+func (n *Network) ListenPacket(network,
+	address string) (net.PacketConn, error) {
+	family, err := networkFamily(network, false)
+	if err != nil {
+		return nil, err
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	addr, key, err := n.bindLocked(family, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: err}
+	}
+
+	packetConn := newNetworkPacketConn(n, family, addr, key, nil)
+	n.packetConns[key] = packetConn
+
+	return packetConn, nil
+}
+
+// Dial connects to the given address on the given network. For stream
+// networks, it fails if no one is listening at the address. For packet
+// networks, it returns a "connected" socket that only exchanges datagrams
+// with the given address.
+func (n *Network) Dial(network, address string) (net.Conn, error) {
+	stream := true
+	family, err := networkFamily(network, true)
+	if err != nil {
+		stream = false
+		family, err = networkFamily(network, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	remoteAddr, remoteKey, err := resolve(family, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	localAddress := ""
+	if family == "tcp" || family == "udp" {
+		localAddress = net.JoinHostPort(hostOf(remoteAddr), "0")
+	}
+
+	if !stream {
+		localAddr, localKey, err := n.bindLocked(family, localAddress)
+		if err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+
+		packetConn := newNetworkPacketConn(n, family, localAddr, localKey,
+			remoteAddr)
+		n.packetConns[localKey] = packetConn
+
+		return packetConn, nil
+	}
+
+	listener, ok := n.listeners[remoteKey]
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: remoteAddr,
+			Err: syscall.ECONNREFUSED}
+	}
+
+	// Stream client sockets only need a unique port (ports are never
+	// reused), not a binding.
+	var localAddr net.Addr
+	if family == "tcp" {
+		localAddr, _, _ = n.bindLocked(family, localAddress)
+	} else {
+		localAddr = &net.UnixAddr{Name: "", Net: network}
+	}
+
+	clientConn, serverConn := newNetworkConnPair(localAddr, listener.addr)
+	if !listener.enqueue(serverConn) {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: remoteAddr,
+			Err: syscall.ECONNREFUSED}
+	}
+
+	return clientConn, nil
+}
+
+// bindLocked resolves the given address, allocating a port if needed, and
+// makes sure it is not in use.
+func (n *Network) bindLocked(family, address string) (net.Addr, string,
+	error) {
+	if address == "" {
+		if family == "tcp" || family == "udp" {
+			address = ":0"
+		} else {
+			// Autobind.
+			address = fmt.Sprintf("@network-%d", n.nextPort)
+			n.nextPort++
+		}
+	}
+
+	addr, key, err := resolve(family, address)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if port := portOf(addr); port == 0 && (family == "tcp" ||
+		family == "udp") {
+		for {
+			port = n.nextPort
+			n.nextPort++
+
+			addr = withPort(addr, port)
+			key = addrKey(family, addr)
+			if !n.inUseLocked(key) {
+				break
+			}
+		}
+	}
+
+	if n.inUseLocked(key) {
+		return nil, "", syscall.EADDRINUSE
+	}
+
+	return addr, key, nil
+}
+
+func (n *Network) inUseLocked(key string) bool {
+	_, listening := n.listeners[key]
+	_, bound := n.packetConns[key]
+
+	return listening || bound
+}
+
+func (n *Network) removeListener(key string, listener *networkListener) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if n.listeners[key] == listener {
+		delete(n.listeners, key)
+	}
+}
+
+func (n *Network) removePacketConn(key string,
+	packetConn *networkPacketConn) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if n.packetConns[key] == packetConn {
+		delete(n.packetConns, key)
+	}
+}
+
+// packetConn returns the packet connection bound to the given address of the
+// given family, if any.
+func (n *Network) packetConn(family string,
+	addr net.Addr) *networkPacketConn {
+	_, key, err := resolve(family, addr.String())
+	if err != nil {
+		return nil
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	return n.packetConns[key]
+}
+
+// networkFamily returns the address family for the given network name
+// ("tcp", "udp", "unix" or "unixgram").
+func networkFamily(network string, stream bool) (string, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		if stream {
+			return "tcp", nil
+		}
+	case "unix", "unixpacket":
+		if stream {
+			return "unix", nil
+		}
+	case "udp", "udp4", "udp6":
+		if !stream {
+			return "udp", nil
+		}
+	case "unixgram":
+		if !stream {
+			return "unixgram", nil
+		}
+	}
+
+	return "", net.UnknownNetworkError(network)
+}
+
+// resolve parses the given address for the given family and returns it
+// together with the key used to look it up.
+func resolve(family, address string) (net.Addr, string, error) {
+	var addr net.Addr
+	switch family {
+	case "tcp", "udp":
+		host, portString, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, "", err
+		}
+
+		port, err := strconv.Atoi(portString)
+		if err != nil || port < 0 || port > 65535 {
+			return nil, "", fmt.Errorf("invalid port %q", portString)
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil {
+			// Unspecified or a name (no resolution is done).
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+
+		if family == "tcp" {
+			addr = &net.TCPAddr{IP: ip, Port: port}
+		} else {
+			addr = &net.UDPAddr{IP: ip, Port: port}
+		}
+	default:
+		if address == "" {
+			return nil, "", fmt.Errorf("missing address")
+		}
+
+		addr = &net.UnixAddr{Name: address, Net: family}
+	}
+
+	return addr, addrKey(family, addr), nil
+}
+
+// addrKey returns the key for the given address. Hosts are ignored.
+func addrKey(family string, addr net.Addr) string {
+	if family == "tcp" || family == "udp" {
+		return family + ":" + strconv.Itoa(portOf(addr))
+	}
+
+	return family + ":" + addr.String()
+}
+
+func portOf(addr net.Addr) int {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.Port
+	case *net.UDPAddr:
+		return addr.Port
+	}
+
+	return 0
+}
+
+func hostOf(addr net.Addr) string {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	}
+
+	return ""
+}
+
+func withPort(addr net.Addr, port int) net.Addr {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return &net.TCPAddr{IP: addr.IP, Port: port}
+	case *net.UDPAddr:
+		return &net.UDPAddr{IP: addr.IP, Port: port}
+	}
+
+	return addr
+}
+
+// networkListener is a net.Listener for a Network.
+type networkListener struct {
+	network *Network
+	addr    net.Addr
+	key     string
+
+	m       sync.Mutex
+	backlog chan net.Conn
+	closeCh chan struct{}
+	closed  bool
+}
+
+func newNetworkListener(network *Network, addr net.Addr,
+	key string) *networkListener {
+	return &networkListener{
+		network: network,
+		addr:    addr,
+		key:     key,
+		backlog: make(chan net.Conn, 128),
+		closeCh: make(chan struct{}),
+	}
+}
+
+// enqueue adds the given connection to the backlog. It returns false if the
+// listener is closed or its backlog is full.
+func (l *networkListener) enqueue(conn net.Conn) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.closed {
+		return false
+	}
+
+	select {
+	case l.backlog <- conn:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *networkListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.backlog:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, &net.OpError{Op: "accept", Net: l.addr.Network(),
+			Addr: l.addr, Err: net.ErrClosed}
+	}
+}
+
+func (l *networkListener) Close() error {
+	l.m.Lock()
+
+	if l.closed {
+		l.m.Unlock()
+		return &net.OpError{Op: "close", Net: l.addr.Network(), Addr: l.addr,
+			Err: net.ErrClosed}
+	}
+
+	l.closed = true
+	close(l.closeCh)
+
+	l.m.Unlock()
+
+	l.network.removeListener(l.key, l)
+
+	// Reset connections that were never accepted.
+	for {
+		select {
+		case conn := <-l.backlog:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (l *networkListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,243 @@
+package testing
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/brunoga/net/internal/waiter"
+)
+
+// Maximum number of bytes buffered in each direction of a stream connection.
+const networkConnBufferSize = 64 * 1024
+
+// pipeBuffer is one direction of an in-memory stream connection.
+type pipeBuffer struct {
+	m       sync.Mutex
+	changed waiter.Waiter // Notified when the state changes.
+	data    []byte
+	eof     bool // The writer will not write anymore.
+	broken  bool // The reader is gone.
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{}
+}
+
+func (p *pipeBuffer) notify() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.changed.Notify()
+}
+
+func (p *pipeBuffer) read(b []byte, deadline func() time.Time) (int, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for {
+		if p.broken {
+			return 0, net.ErrClosed
+		}
+
+		if expired(deadline()) {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		if len(p.data) > 0 {
+			n := copy(b, p.data)
+			p.data = p.data[n:]
+			p.changed.Notify()
+
+			return n, nil
+		}
+
+		if p.eof {
+			return 0, io.EOF
+		}
+
+		if !p.changed.Wait(&p.m, deadline()) {
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (p *pipeBuffer) write(b []byte, deadline func() time.Time) (int, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		if p.eof {
+			return written, net.ErrClosed
+		}
+
+		if p.broken {
+			return written, syscall.EPIPE
+		}
+
+		if expired(deadline()) {
+			return written, os.ErrDeadlineExceeded
+		}
+
+		space := networkConnBufferSize - len(p.data)
+		if space > 0 {
+			if space > len(b) {
+				space = len(b)
+			}
+
+			p.data = append(p.data, b[:space]...)
+			b = b[space:]
+			written += space
+			p.changed.Notify()
+
+			continue
+		}
+
+		if !p.changed.Wait(&p.m, deadline()) {
+			return written, os.ErrDeadlineExceeded
+		}
+	}
+
+	return written, nil
+}
+
+// expired returns true if the given deadline is set and has passed.
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// closeWrite makes the reader get io.EOF once buffered data is read.
+func (p *pipeBuffer) closeWrite() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.eof = true
+	p.changed.Notify()
+}
+
+// closeRead discards buffered data and makes writes fail.
+func (p *pipeBuffer) closeRead() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.broken = true
+	p.data = nil
+	p.changed.Notify()
+}
+
+// networkConn is one side of an in-memory stream connection.
+type networkConn struct {
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	in         *pipeBuffer
+	out        *pipeBuffer
+
+	m             sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	closed        bool
+}
+
+// newNetworkConnPair returns both sides of a new in-memory stream connection
+// between the given addresses.
+func newNetworkConnPair(clientAddr,
+	serverAddr net.Addr) (*networkConn, *networkConn) {
+	clientToServer := newPipeBuffer()
+	serverToClient := newPipeBuffer()
+
+	return &networkConn{
+		localAddr:  clientAddr,
+		remoteAddr: serverAddr,
+		in:         serverToClient,
+		out:        clientToServer,
+	}, &networkConn{
+		localAddr:  serverAddr,
+		remoteAddr: clientAddr,
+		in:         clientToServer,
+		out:        serverToClient,
+	}
+}
+
+func (c *networkConn) Read(b []byte) (int, error) {
+	return c.in.read(b, c.getReadDeadline)
+}
+
+func (c *networkConn) Write(b []byte) (int, error) {
+	return c.out.write(b, c.getWriteDeadline)
+}
+
+// CloseWrite shuts down the writing side of the connection. The peer reads
+// io.EOF once it has read all data written before.
+func (c *networkConn) CloseWrite() error {
+	c.out.closeWrite()
+
+	return nil
+}
+
+func (c *networkConn) Close() error {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.m.Unlock()
+
+	c.in.closeRead()
+	c.out.closeWrite()
+
+	return nil
+}
+
+func (c *networkConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *networkConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *networkConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	c.SetWriteDeadline(t)
+
+	return nil
+}
+
+func (c *networkConn) SetReadDeadline(t time.Time) error {
+	c.m.Lock()
+	c.readDeadline = t
+	c.m.Unlock()
+
+	c.in.notify()
+
+	return nil
+}
+
+func (c *networkConn) SetWriteDeadline(t time.Time) error {
+	c.m.Lock()
+	c.writeDeadline = t
+	c.m.Unlock()
+
+	c.out.notify()
+
+	return nil
+}
+
+func (c *networkConn) getReadDeadline() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.readDeadline
+}
+
+func (c *networkConn) getWriteDeadline() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.writeDeadline
+}
@@ -0,0 +1,193 @@
+package testing
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Maximum number of datagrams queued for reading in a packet connection.
+const networkPacketQueueSize = 1024
+
+type networkDatagram struct {
+	data []byte
+	from net.Addr
+}
+
+// networkPacketConn is an in-memory packet connection. It implements both
+// net.PacketConn and net.Conn (the latter only makes sense for "connected"
+// sockets, which have a remote address).
+type networkPacketConn struct {
+	network    *Network
+	family     string
+	localAddr  net.Addr
+	key        string
+	remoteAddr net.Addr // nil if not connected.
+
+	queue   chan networkDatagram
+	closeCh chan struct{}
+
+	m             sync.Mutex
+	changedCh     chan struct{} // Closed (and replaced) on deadline changes.
+	readDeadline  time.Time
+	writeDeadline time.Time
+	closed        bool
+}
+
+func newNetworkPacketConn(network *Network, family string, localAddr net.Addr,
+	key string, remoteAddr net.Addr) *networkPacketConn {
+	return &networkPacketConn{
+		network:    network,
+		family:     family,
+		localAddr:  localAddr,
+		key:        key,
+		remoteAddr: remoteAddr,
+		queue:      make(chan networkDatagram, networkPacketQueueSize),
+		closeCh:    make(chan struct{}),
+		changedCh:  make(chan struct{}),
+	}
+}
+
+func (c *networkPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.m.Lock()
+		deadline, changedCh := c.readDeadline, c.changedCh
+		c.m.Unlock()
+
+		var timeoutCh <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			timeout := time.Until(deadline)
+			if timeout <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+
+			timer = time.NewTimer(timeout)
+			timeoutCh = timer.C
+		}
+
+		select {
+		case datagram := <-c.queue:
+			if timer != nil {
+				timer.Stop()
+			}
+
+			// Connected sockets only get datagrams from their peer.
+			if c.remoteAddr != nil &&
+				datagram.from.String() != c.remoteAddr.String() {
+				continue
+			}
+
+			return copy(b, datagram.data), datagram.from, nil
+		case <-c.closeCh:
+			if timer != nil {
+				timer.Stop()
+			}
+
+			return 0, nil, net.ErrClosed
+		case <-changedCh:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-timeoutCh:
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (c *networkPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.m.Lock()
+	closed, deadline := c.closed, c.writeDeadline
+	c.m.Unlock()
+
+	if closed {
+		return 0, net.ErrClosed
+	}
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	target := c.network.packetConn(c.family, addr)
+	if target != nil {
+		target.deliver(networkDatagram{append([]byte(nil), b...),
+			c.localAddr})
+	}
+
+	return len(b), nil
+}
+
+// deliver queues the given datagram for reading, dropping it if the queue is
+// full.
+func (c *networkPacketConn) deliver(datagram networkDatagram) {
+	select {
+	case c.queue <- datagram:
+	default:
+	}
+}
+
+func (c *networkPacketConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+
+	return n, err
+}
+
+func (c *networkPacketConn) Write(b []byte) (int, error) {
+	if c.remoteAddr == nil {
+		return 0, &net.OpError{Op: "write", Net: c.family, Addr: c.localAddr,
+			Err: os.ErrInvalid}
+	}
+
+	return c.WriteTo(b, c.remoteAddr)
+}
+
+func (c *networkPacketConn) Close() error {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.m.Unlock()
+
+	close(c.closeCh)
+	c.network.removePacketConn(c.key, c)
+
+	return nil
+}
+
+func (c *networkPacketConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *networkPacketConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *networkPacketConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	c.SetWriteDeadline(t)
+
+	return nil
+}
+
+func (c *networkPacketConn) SetReadDeadline(t time.Time) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.readDeadline = t
+	close(c.changedCh)
+	c.changedCh = make(chan struct{})
+
+	return nil
+}
+
+func (c *networkPacketConn) SetWriteDeadline(t time.Time) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.writeDeadline = t
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package testing
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNetwork_Stream(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		n := NewNetwork()
+
+		address := ""
+		if network == "unix" {
+			address = "/tmp/test.sock"
+		}
+
+		l, err := n.Listen(network, address)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		// Echo server.
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			io.Copy(conn, conn)
+			conn.Close()
+		}()
+
+		conn, err := n.Dial(network, l.Addr().String())
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		if conn.RemoteAddr().String() != l.Addr().String() {
+			t.Errorf("expected %v, got %v", l.Addr(), conn.RemoteAddr())
+		}
+
+		_, err = conn.Write([]byte("hello"))
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		// The echo server only closes the connection after reading EOF.
+		conn.(interface{ CloseWrite() error }).CloseWrite()
+
+		data, err := io.ReadAll(conn)
+		if err != nil || string(data) != "hello" {
+			t.Errorf("expected %q, got %q (%v)", "hello", data, err)
+		}
+
+		conn.Close()
+		l.Close()
+
+		_, err = n.Dial(network, l.Addr().String())
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			t.Errorf("expected ECONNREFUSED, got %v", err)
+		}
+	}
+}
+
+func TestNetwork_Packet(t *testing.T) {
+	n := NewNetwork()
+
+	server, err := n.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer server.Close()
+
+	unconnected, err := n.ListenPacket("udp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer unconnected.Close()
+
+	connected, err := n.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer connected.Close()
+
+	unconnected.WriteTo([]byte("unconnected"), server.LocalAddr())
+	connected.Write([]byte("connected"))
+
+	buffer := make([]byte, 64)
+	for _, c := range []net.Conn{nil, connected} {
+		expected := "unconnected"
+		from := unconnected.LocalAddr()
+		if c != nil {
+			expected = "connected"
+			from = connected.LocalAddr()
+		}
+
+		read, addr, err := server.ReadFrom(buffer)
+		if err != nil || string(buffer[:read]) != expected ||
+			addr.String() != from.String() {
+			t.Errorf("expected %q from %v, got %q from %v (%v)", expected,
+				from, buffer[:read], addr, err)
+		}
+	}
+
+	// Connected sockets ignore datagrams from anyone but their peer.
+	unconnected.WriteTo([]byte("ignored"), connected.LocalAddr())
+	server.WriteTo([]byte("reply"), connected.LocalAddr())
+
+	read, err := connected.Read(buffer)
+	if err != nil || string(buffer[:read]) != "reply" {
+		t.Errorf("expected %q, got %q (%v)", "reply", buffer[:read], err)
+	}
+}
+
+func TestNetwork_AddressInUse(t *testing.T) {
+	n := NewNetwork()
+
+	l, err := n.Listen("tcp", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	_, err = n.Listen("tcp", "0.0.0.0:8080")
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("expected EADDRINUSE, got %v", err)
+	}
+
+	// Different families do not conflict.
+	p, err := n.ListenPacket("udp", ":8080")
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+	p.Close()
+
+	l.Close()
+
+	l, err = n.Listen("tcp", ":8080")
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+	l.Close()
+
+	_, err = n.Listen("udp", ":8080")
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestNetwork_Deadlines(t *testing.T) {
+	n := NewNetwork()
+
+	l, err := n.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer l.Close()
+
+	conn, err := n.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected os.ErrDeadlineExceeded, got %v", err)
+	}
+
+	// Writes block once the peer buffer is full.
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+
+	written, err := conn.Write(make([]byte, 2*networkConnBufferSize))
+	if !errors.Is(err, os.ErrDeadlineExceeded) ||
+		written != networkConnBufferSize {
+		t.Errorf("expected %d bytes and os.ErrDeadlineExceeded, got %d (%v)",
+			networkConnBufferSize, written, err)
+	}
+
+	p, err := n.ListenPacket("udp", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	p.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	_, _, err = p.ReadFrom(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected os.ErrDeadlineExceeded, got %v", err)
+	}
+
+	// Closing unblocks readers.
+	p.SetReadDeadline(time.Time{})
+	time.AfterFunc(10*time.Millisecond, func() { p.Close() })
+
+	_, _, err = p.ReadFrom(make([]byte, 1))
+	if !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected net.ErrClosed, got %v", err)
+	}
+}
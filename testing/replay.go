@@ -0,0 +1,65 @@
+package testing
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/brunoga/net/record"
+)
+
+// NewReplayConn creates a ScriptedConn that replays the recording (see
+// package record) read from r against the code using it, which plays the
+// recorded side of the conversation: recorded reads are replied, recorded
+// writes are expected, a recorded EOF is a Hangup and a recorded close is
+// expected. Replies are paced like the recording, with delays divided by the
+// given speed (2.0 replays twice as fast). If speed is 0, replies are not
+// delayed at all. Use ScriptedConn.Wait to check that the outputs matched
+// the recording. Recordings of packet connections must only have events for
+// a single peer (see record.Event.Addr), as a ScriptedConn has only one.
+// Events without an address (like the close of a packet connection) do not
+// count as a peer.
+func NewReplayConn(r io.Reader, speed float64) (*ScriptedConn, error) {
+	events, err := record.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := ""
+	for _, event := range events {
+		if event.Addr == "" {
+			continue
+		}
+
+		if peer == "" {
+			peer = event.Addr
+		} else if event.Addr != peer {
+			return nil, fmt.Errorf("recording has events for multiple "+
+				"peers (%q and %q)", peer, event.Addr)
+		}
+	}
+
+	var steps []ScriptStep
+	var last time.Duration
+	for _, event := range events {
+		switch event.Kind {
+		case record.Read:
+			if speed > 0 && event.Time > last {
+				steps = append(steps, Pause(time.Duration(
+					float64(event.Time-last)/speed)))
+			}
+
+			steps = append(steps, Reply(event.Data))
+		case record.Write:
+			steps = append(steps, Expect(event.Data))
+		case record.EOF:
+			steps = append(steps, Hangup())
+		case record.Close:
+			steps = append(steps, ExpectClose())
+		}
+
+		last = event.Time
+	}
+
+	return NewScriptedConn(steps...), nil
+}
@@ -0,0 +1,136 @@
+package testing
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brunoga/net/record"
+)
+
+// recordConversation returns a recording of a client talking to a server
+// that takes 50ms to reply.
+func recordConversation(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	w, err := record.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	w.Record(record.Write, "", []byte("hello\n"))
+	time.Sleep(50 * time.Millisecond)
+	w.Record(record.Read, "", []byte("HELLO\n"))
+	w.Record(record.Write, "", []byte("QUIT\n"))
+	w.Record(record.EOF, "", nil)
+	w.Record(record.Close, "", nil)
+
+	return buffer.Bytes()
+}
+
+func TestNewReplayConn(t *testing.T) {
+	recording := recordConversation(t)
+
+	// The recorded side was the client, so the replay plays the server.
+	for _, speed := range []float64{0, 1, 10} {
+		conn, err := NewReplayConn(bytes.NewReader(recording), speed)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		start := time.Now()
+		go func() {
+			conn.Write([]byte("hello\n"))
+			io.ReadFull(conn, make([]byte, 6))
+			conn.Write([]byte("QUIT\n"))
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		}()
+
+		err = conn.Wait(time.Second)
+		if err != nil {
+			t.Errorf("expected nil error, got %v", err)
+		}
+
+		elapsed := time.Since(start)
+		if speed == 1 && elapsed < 50*time.Millisecond {
+			t.Errorf("expected at least 50ms, got %v", elapsed)
+		}
+		if speed != 1 && elapsed > 40*time.Millisecond {
+			t.Errorf("expected at most 40ms, got %v", elapsed)
+		}
+	}
+
+	_, err := NewReplayConn(strings.NewReader("invalid"), 1)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestNewReplayConn_PacketConn(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	defer peer.Close()
+
+	var buffer bytes.Buffer
+	w, err := record.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	recorded := record.WrapPacketConn(packetConn, w)
+	recorded.WriteTo([]byte("ping"), peer.LocalAddr())
+
+	b := make([]byte, 16)
+	_, addr, err := peer.ReadFrom(b)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	peer.WriteTo([]byte("pong"), addr)
+	recorded.ReadFrom(b)
+
+	// The close is recorded without an address.
+	recorded.Close()
+
+	conn, err := NewReplayConn(&buffer, 0)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	go func() {
+		conn.Write([]byte("ping"))
+		conn.Read(b)
+		conn.Close()
+	}()
+
+	err = conn.Wait(time.Second)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+}
+
+func TestNewReplayConn_MultiplePeers(t *testing.T) {
+	var buffer bytes.Buffer
+	w, err := record.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	w.Record(record.Read, "127.0.0.1:1", []byte("a"))
+	w.Record(record.Read, "127.0.0.1:2", []byte("b"))
+
+	_, err = NewReplayConn(&buffer, 0)
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
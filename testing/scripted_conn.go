@@ -0,0 +1,343 @@
+package testing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brunoga/net/internal/waiter"
+)
+
+type scriptStepKind int
+
+const (
+	expectStep scriptStepKind = iota
+	replyStep
+	hangupStep
+	expectCloseStep
+	pauseStep
+)
+
+// ScriptStep is a step in the conversation played by a ScriptedConn.
+type ScriptStep struct {
+	kind     scriptStepKind
+	data     []byte
+	duration time.Duration
+}
+
+// Expect returns a ScriptStep that expects the given data to be written to
+// the ScriptedConn. It may be written in any number of writes.
+func Expect(data []byte) ScriptStep {
+	return ScriptStep{kind: expectStep, data: data}
+}
+
+// Reply returns a ScriptStep that makes the given data available for reading
+// from the ScriptedConn. It may be read in any number of reads.
+func Reply(data []byte) ScriptStep {
+	return ScriptStep{kind: replyStep, data: data}
+}
+
+// Hangup returns a ScriptStep that makes all further reads from the
+// ScriptedConn return io.EOF, as when the peer shuts down its writing side.
+func Hangup() ScriptStep {
+	return ScriptStep{kind: hangupStep}
+}
+
+// ExpectClose returns a ScriptStep that expects the ScriptedConn to be
+// closed.
+func ExpectClose() ScriptStep {
+	return ScriptStep{kind: expectCloseStep}
+}
+
+// Pause returns a ScriptStep that delays the following Reply steps by the
+// given duration. Writes during a Pause end it immediately.
+func Pause(duration time.Duration) ScriptStep {
+	return ScriptStep{kind: pauseStep, duration: duration}
+}
+
+func (s ScriptStep) String() string {
+	switch s.kind {
+	case expectStep:
+		return fmt.Sprintf("expect %q", s.data)
+	case replyStep:
+		return fmt.Sprintf("reply %q", s.data)
+	case hangupStep:
+		return "hangup"
+	case pauseStep:
+		return fmt.Sprintf("pause %v", s.duration)
+	}
+
+	return "expect close"
+}
+
+// ScriptedConn is a net.Conn that plays the peer side of a scripted
+// conversation (see NewScriptedConn) with the code using it. Any deviation
+// from the script (unexpected data written, unexpected close) fails all
+// further reads and writes and is reported by Wait.
+type ScriptedConn struct {
+	steps []ScriptStep
+
+	m            sync.Mutex
+	changed      waiter.Waiter // Notified when the state changes.
+	step         int           // Index of the current step.
+	offset       int           // Bytes of the current step written or read.
+	pauseEnd     time.Time     // End of the current Pause step.
+	hungUp       bool
+	closed       bool
+	err          error // First deviation from the script.
+	readDeadline time.Time
+}
+
+// NewScriptedConn creates a new ScriptedConn that plays the given steps in
+// order. Reads block until a Reply step is reached and return io.EOF once the
+// script ends.
+func NewScriptedConn(steps ...ScriptStep) *ScriptedConn {
+	c := &ScriptedConn{}
+
+	for _, step := range steps {
+		if (step.kind == expectStep || step.kind == replyStep) &&
+			len(step.data) == 0 {
+			continue
+		}
+
+		c.steps = append(c.steps, step)
+	}
+
+	c.advanceLocked()
+
+	return c
+}
+
+func (c *ScriptedConn) Read(b []byte) (int, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for {
+		if c.closed {
+			return 0, net.ErrClosed
+		}
+
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		if c.hungUp || c.step == len(c.steps) {
+			return 0, io.EOF
+		}
+
+		if c.steps[c.step].kind == replyStep {
+			n := copy(b, c.steps[c.step].data[c.offset:])
+			c.offset += n
+			c.advanceLocked()
+
+			return n, nil
+		}
+
+		if !c.waitStepLocked(c.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (c *ScriptedConn) Write(b []byte) (int, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	written := 0
+	for written < len(b) {
+		for c.step < len(c.steps) && c.steps[c.step].kind == pauseStep {
+			c.step++
+			c.pauseEnd = time.Time{}
+			c.advanceLocked()
+		}
+
+		if c.step == len(c.steps) {
+			return written, c.failLocked(fmt.Errorf(
+				"unexpected write of %q after the end of the script",
+				b[written:]))
+		}
+
+		step := c.steps[c.step]
+		if step.kind != expectStep {
+			return written, c.failLocked(fmt.Errorf(
+				"step %d (%v): unexpected write of %q", c.step+1, step,
+				b[written:]))
+		}
+
+		expected := step.data[c.offset:]
+		n := len(expected)
+		if n > len(b)-written {
+			n = len(b) - written
+		}
+
+		if i := mismatch(expected[:n], b[written:written+n]); i >= 0 {
+			got := append(append([]byte(nil), step.data[:c.offset]...),
+				b[written:]...)
+
+			return written, c.failLocked(fmt.Errorf(
+				"step %d (%v): mismatch at byte %d\n"+
+					"  expected: %q\n"+
+					"  got:      %q", c.step+1, step, c.offset+i, step.data,
+				got))
+		}
+
+		written += n
+		c.offset += n
+		c.advanceLocked()
+	}
+
+	return written, nil
+}
+
+// Close closes the ScriptedConn. Closing it before the script ends (unless
+// the current step is ExpectClose) is a deviation from the script.
+func (c *ScriptedConn) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	if c.err == nil && c.step < len(c.steps) {
+		if c.steps[c.step].kind == expectCloseStep {
+			c.step++
+			c.advanceLocked()
+		} else {
+			c.failLocked(fmt.Errorf("step %d (%v): unexpected close",
+				c.step+1, c.steps[c.step]))
+		}
+	}
+
+	c.closed = true
+	c.changed.Notify()
+
+	return nil
+}
+
+// Wait waits for the script to end (or for a deviation from it) for up to the
+// given timeout. It returns nil if the script ended as expected and an error
+// describing the deviation or the step not completed otherwise.
+func (c *ScriptedConn) Wait(timeout time.Duration) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for c.err == nil && c.step < len(c.steps) {
+		if !c.waitStepLocked(deadline) {
+			step := c.steps[c.step]
+			if step.kind == expectStep && c.offset > 0 {
+				return fmt.Errorf("step %d (%v): got only %q", c.step+1,
+					step, step.data[:c.offset])
+			}
+
+			return fmt.Errorf("step %d (%v): not completed", c.step+1, step)
+		}
+	}
+
+	return c.err
+}
+
+func (c *ScriptedConn) LocalAddr() net.Addr {
+	return &MockAddr{}
+}
+
+func (c *ScriptedConn) RemoteAddr() net.Addr {
+	return &MockAddr{}
+}
+
+func (c *ScriptedConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *ScriptedConn) SetReadDeadline(t time.Time) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.readDeadline = t
+	c.changed.Notify()
+
+	return nil
+}
+
+// SetWriteDeadline does nothing, as writes never block.
+func (c *ScriptedConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// advanceLocked moves to the next step if the current one is complete,
+// processing Hangup and Pause steps.
+func (c *ScriptedConn) advanceLocked() {
+	for c.step < len(c.steps) {
+		step := c.steps[c.step]
+		if step.kind == hangupStep {
+			c.hungUp = true
+		} else if step.kind == pauseStep {
+			if c.pauseEnd.IsZero() {
+				c.pauseEnd = time.Now().Add(step.duration)
+			}
+
+			if time.Now().Before(c.pauseEnd) {
+				break
+			}
+
+			c.pauseEnd = time.Time{}
+		} else if step.kind == expectCloseStep || c.offset < len(step.data) {
+			break
+		}
+
+		c.step++
+		c.offset = 0
+	}
+
+	c.changed.Notify()
+}
+
+func (c *ScriptedConn) failLocked(err error) error {
+	c.err = err
+	c.changed.Notify()
+
+	return err
+}
+
+// waitStepLocked is like Waiter.Wait but also returns (with the current step
+// advanced) when the current Pause step ends.
+func (c *ScriptedConn) waitStepLocked(deadline time.Time) bool {
+	if !c.pauseEnd.IsZero() && (deadline.IsZero() ||
+		c.pauseEnd.Before(deadline)) {
+		c.changed.Wait(&c.m, c.pauseEnd)
+		c.advanceLocked()
+
+		return true
+	}
+
+	return c.changed.Wait(&c.m, deadline)
+}
+
+// mismatch returns the index of the first byte that differs between a and b
+// (which have the same length) or -1 if they are equal.
+func mismatch(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return -1
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,115 @@
+package testing
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoHandler writes back each line read from the given connection in upper
+// case and closes it on "QUIT".
+func echoHandler(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if scanner.Text() == "QUIT" {
+			return
+		}
+
+		conn.Write([]byte(strings.ToUpper(scanner.Text()) + "\n"))
+	}
+}
+
+func TestScriptedConn(t *testing.T) {
+	conn := NewScriptedConn(
+		Reply([]byte("hel")),
+		Reply([]byte("lo\n")),
+		Expect([]byte("HELLO\n")),
+		Reply([]byte("world\n")),
+		Expect([]byte("WORLD\n")),
+		Reply([]byte("QUIT\n")),
+		ExpectClose(),
+	)
+
+	go echoHandler(conn)
+
+	err := conn.Wait(time.Second)
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+}
+
+func TestScriptedConn_Mismatch(t *testing.T) {
+	conn := NewScriptedConn(
+		Reply([]byte("hello\n")),
+		Expect([]byte("HELLO!\n")),
+	)
+
+	go echoHandler(conn)
+
+	err := conn.Wait(time.Second)
+	expected := "step 2 (expect \"HELLO!\\n\"): mismatch at byte 5\n" +
+		"  expected: \"HELLO!\\n\"\n" +
+		"  got:      \"HELLO\\n\""
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+
+	_, err = conn.Write([]byte("more"))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}
+
+func TestScriptedConn_UnexpectedClose(t *testing.T) {
+	conn := NewScriptedConn(
+		Reply([]byte("hello\n")),
+		Hangup(),
+		Expect([]byte("HELLO\n")),
+		Expect([]byte("BYE\n")),
+	)
+
+	// Closes on io.EOF.
+	go echoHandler(conn)
+
+	err := conn.Wait(time.Second)
+	expected := "step 4 (expect \"BYE\\n\"): unexpected close"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+}
+
+func TestScriptedConn_NotCompleted(t *testing.T) {
+	conn := NewScriptedConn(Expect([]byte("hello")))
+
+	conn.Write([]byte("he"))
+
+	err := conn.Wait(10 * time.Millisecond)
+	expected := "step 1 (expect \"hello\"): got only \"he\""
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+
+	conn.Write([]byte("llo"))
+
+	_, err = conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	_, err = conn.Write([]byte("!"))
+	if err == nil {
+		t.Error("expected non-nil error, got nil")
+	}
+}